@@ -0,0 +1,379 @@
+// Command smscp-admin runs maintenance against the production data store, and
+// does what operators do in /admin. It reads the same config as the server.
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/base64"
+	"fmt"
+	"log"
+	"os"
+	"os/user"
+	"sort"
+	"text/tabwriter"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/urfave/cli/v2"
+	"smscp.xyz/internal/api"
+	"smscp.xyz/internal/common"
+	"smscp.xyz/internal/config"
+	"smscp.xyz/internal/envelope"
+	"smscp.xyz/internal/export"
+	"smscp.xyz/internal/fs"
+	"smscp.xyz/internal/security"
+	"smscp.xyz/internal/sms/twilio"
+	"smscp.xyz/pkg/mode"
+)
+
+func load(c *cli.Context) (config.Config, error) {
+	var args []string
+	if file := c.String("config"); file != "" {
+		args = []string{"-config", file}
+	}
+	return config.Load(mode.Prod, args)
+}
+
+func data(ctx context.Context, cfg config.Config) (fs.FS, error) {
+	conn, err := fs.ConnDefault(ctx, cfg.ProjectID)
+	if err != nil {
+		return fs.FS{}, errors.Wrap(err, "failed to connect to firestore")
+	}
+
+	keys, err := envelope.Parse(cfg.MasterKeys)
+	if err != nil {
+		return fs.FS{}, errors.Wrap(err, "invalid MASTER_KEYS")
+	}
+
+	return fs.Default(security.Default(cfg.JWTSecret), conn).WithKeyring(keys), nil
+}
+
+// cli commands
+
+func newKey(c *cli.Context) error {
+	key := make([]byte, 32)
+	if _, err := rand.Read(key); err != nil {
+		return errors.Wrap(err, "failed to create key")
+	}
+
+	fmt.Println(base64.StdEncoding.EncodeToString(key))
+	return nil
+}
+
+func rotateKeys(c *cli.Context) error {
+	ctx := context.Background()
+
+	cfg, err := load(c)
+	if err != nil {
+		return err
+	}
+	store, err := data(ctx, cfg)
+	if err != nil {
+		return err
+	}
+
+	n, err := store.NoteRotateKeys(ctx)
+	fmt.Printf("rotated %d notes\n", n)
+	return err
+}
+
+// sweep is for deployments without a long running server to sweep for them.
+func sweep(c *cli.Context) error {
+	ctx := context.Background()
+
+	cfg, err := load(c)
+	if err != nil {
+		return err
+	}
+	store, err := data(ctx, cfg)
+	if err != nil {
+		return err
+	}
+
+	n, err := store.NoteSweepExpired(ctx, time.Now())
+	fmt.Printf("deleted %d expired notes\n", n)
+	return err
+}
+
+// purge enforces retention, or with --dry-run reports what it would delete.
+func purge(c *cli.Context) error {
+	ctx := context.Background()
+
+	cfg, err := load(c)
+	if err != nil {
+		return err
+	}
+	store, err := data(ctx, cfg)
+	if err != nil {
+		return err
+	}
+
+	dryRun := c.Bool("dry-run")
+	report, err := store.NotePurge(ctx, time.Now(), dryRun)
+
+	users := make([]string, 0, len(report))
+	for user := range report {
+		users = append(users, user)
+	}
+	sort.Strings(users)
+
+	total := 0
+	for _, user := range users {
+		fmt.Printf("%s\t%d\n", user, report[user])
+		total += report[user]
+	}
+	if dryRun {
+		fmt.Printf("would delete %d notes from %d users\n", total, len(users))
+	} else {
+		fmt.Printf("deleted %d notes from %d users\n", total, len(users))
+	}
+
+	return err
+}
+
+// purgeAccounts deletes accounts past their deletion grace period and texts
+// each to confirm.
+func purgeAccounts(c *cli.Context) error {
+	ctx := context.Background()
+
+	cfg, err := load(c)
+	if err != nil {
+		return err
+	}
+	store, err := data(ctx, cfg)
+	if err != nil {
+		return err
+	}
+
+	sms := twilio.Default(cfg.TwilioID, cfg.TwilioSecret, cfg.TwilioFrom)
+	app := api.AppDefault(store, sms, nil, nil, nil, nil)
+
+	n, err := app.UserPurgeDeleted(ctx, time.Now())
+	fmt.Printf("deleted %d accounts\n", n)
+	return err
+}
+
+// accounts
+
+// admin is the service behind /admin, run against the data store directly.
+func admin(c *cli.Context) (context.Context, api.App, fs.FS, error) {
+	ctx := context.Background()
+
+	cfg, err := load(c)
+	if err != nil {
+		return nil, api.App{}, fs.FS{}, err
+	}
+	store, err := data(ctx, cfg)
+	if err != nil {
+		return nil, api.App{}, fs.FS{}, err
+	}
+
+	return ctx, api.AppDefault(store, nil, nil, nil, nil, nil), store, nil
+}
+
+func search(c *cli.Context) error {
+	ctx, app, _, err := admin(c)
+	if err != nil {
+		return err
+	}
+
+	accounts, err := app.AdminSearch(ctx, c.Args().First())
+	if err != nil {
+		return err
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(w, "ID\tUSERNAME\tPHONE\tLOCKED\tADMIN")
+	for _, a := range accounts {
+		locked := !a.LockedAt.IsZero() || a.LockedByAdmin
+		fmt.Fprintf(w, "%s\t%s\t%s\t%t\t%t\n", a.ID, a.Username, a.Phone, locked, a.Admin)
+	}
+	return w.Flush()
+}
+
+func show(c *cli.Context) error {
+	ctx, app, _, err := admin(c)
+	if err != nil {
+		return err
+	}
+
+	a, err := app.AdminAccount(ctx, c.Args().First())
+	if err != nil {
+		return err
+	}
+
+	now := time.Now()
+	w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintf(w, "id\t%s\n", a.ID)
+	fmt.Fprintf(w, "username\t%s\n", a.Username)
+	fmt.Fprintf(w, "phone\t%s\n", a.Phone)
+	fmt.Fprintf(w, "created\t%s\n", a.CreatedAt.Format(time.RFC3339))
+	fmt.Fprintf(w, "admin\t%t\n", a.Admin)
+	fmt.Fprintf(w, "notes\t%d\n", a.Notes)
+	fmt.Fprintf(w, "sms today\t%d\n", a.SMSSince(now))
+	fmt.Fprintf(w, "sms %d days\t%d\n", common.SMSDays, a.SMSSince(now.AddDate(0, 0, 1-common.SMSDays)))
+	fmt.Fprintf(w, "failed logins\t%d\n", a.FailedLogins)
+	switch {
+	case a.LockedByAdmin:
+		fmt.Fprintf(w, "locked\tby an operator at %s\n", a.LockedAt.Format(time.RFC3339))
+	case !a.LockedAt.IsZero():
+		fmt.Fprintf(w, "locked\tby failed logins at %s\n", a.LockedAt.Format(time.RFC3339))
+	}
+	if !a.DeleteAt.IsZero() {
+		fmt.Fprintf(w, "deleted on\t%s\n", a.DeleteAt.Format(time.RFC3339))
+	}
+	return w.Flush()
+}
+
+// operator names who ran the command in the audit log.
+func operator() string {
+	if u, err := user.Current(); err == nil {
+		return "smscp-admin:" + u.Username
+	}
+	return "smscp-admin"
+}
+
+// action runs one of the account actions of /admin on the id given.
+func action(do func(app api.App, ctx context.Context, actor, id string) error, done string) cli.ActionFunc {
+	return func(c *cli.Context) error {
+		if c.NArg() != 1 {
+			return errors.New("usage: smscp-admin " + c.Command.Name + " <user id>")
+		}
+		ctx, app, _, err := admin(c)
+		if err != nil {
+			return err
+		}
+		if err := do(app, ctx, operator(), c.Args().First()); err != nil {
+			return err
+		}
+		fmt.Println(done)
+		return nil
+	}
+}
+
+// audit prints the audit log of an account, or of every account, for
+// compliance reviews.
+func audit(c *cli.Context) error {
+	ctx, app, _, err := admin(c)
+	if err != nil {
+		return err
+	}
+
+	events, err := app.AdminAudit(ctx, c.Args().First())
+	if err != nil {
+		return err
+	}
+
+	return export.Default().WriteAudit(os.Stdout, c.String("format"), events)
+}
+
+// setAdmin grants or revokes the admin role, which only this command can.
+func setAdmin(grant bool) cli.ActionFunc {
+	return func(c *cli.Context) error {
+		if c.NArg() != 1 {
+			return errors.New("usage: smscp-admin " + c.Command.Name + " <user id>")
+		}
+		ctx, _, store, err := admin(c)
+		if err != nil {
+			return err
+		}
+		return store.UserSetAdmin(ctx, c.Args().First(), grant)
+	}
+}
+
+func main() {
+	app := cli.NewApp()
+	app.Name = "smscp-admin"
+	app.Usage = "maintenance for https://smscp.xyz/"
+	app.Flags = []cli.Flag{
+		&cli.StringFlag{Name: "config", EnvVars: []string{config.FileEnv}, Usage: "YAML or TOML config file"},
+	}
+
+	app.Commands = []*cli.Command{
+		{
+			Name:   "new-key",
+			Usage:  "print a random master key for MASTER_KEYS",
+			Action: newKey,
+		},
+		{
+			Name: "rotate-keys",
+			Usage: "re-wrap every note's data key with the first key in MASTER_KEYS; " +
+				"keep the old key listed after it until this completes",
+			Action: rotateKeys,
+		},
+		{
+			Name:   "sweep",
+			Usage:  "delete expired notes; run from cron when serverless",
+			Action: sweep,
+		},
+		{
+			Name:   "purge",
+			Usage:  "delete notes past each user's retention setting",
+			Action: purge,
+			Flags: []cli.Flag{
+				&cli.BoolFlag{Name: "dry-run", Usage: "only report how many notes would be deleted"},
+			},
+		},
+		{
+			Name:   "purge-accounts",
+			Usage:  "delete accounts past their deletion grace period",
+			Action: purgeAccounts,
+		},
+		{
+			Name:      "search",
+			Usage:     "find accounts by username prefix or phone",
+			ArgsUsage: "<username or phone>",
+			Action:    search,
+		},
+		{
+			Name:      "show",
+			Usage:     "show an account with its note count and sms usage",
+			ArgsUsage: "<user id>",
+			Action:    show,
+		},
+		{
+			Name:      "lock",
+			Usage:     "lock an account and end its sessions until unlocked",
+			ArgsUsage: "<user id>",
+			Action:    action(api.App.AdminLock, "locked"),
+		},
+		{
+			Name:      "unlock",
+			Usage:     "unlock an account however it was locked",
+			ArgsUsage: "<user id>",
+			Action:    action(api.App.AdminUnlock, "unlocked"),
+		},
+		{
+			Name:      "logout",
+			Usage:     "end every session and token of an account",
+			ArgsUsage: "<user id>",
+			Action:    action(api.App.AdminLogout, "logged out"),
+		},
+		{
+			Name:      "audit",
+			Usage:     "print the audit log of an account, or of every account, newest first",
+			ArgsUsage: "[user id]",
+			Action:    audit,
+			Flags: []cli.Flag{
+				&cli.StringFlag{Name: "format", Value: export.CSV, Usage: "csv or json"},
+			},
+		},
+		{
+			Name:      "grant-admin",
+			Usage:     "let an account use /admin",
+			ArgsUsage: "<user id>",
+			Action:    setAdmin(true),
+		},
+		{
+			Name:      "revoke-admin",
+			Usage:     "stop an account using /admin",
+			ArgsUsage: "<user id>",
+			Action:    setAdmin(false),
+		},
+	}
+
+	if err := app.Run(os.Args); err != nil {
+		log.Fatal(err)
+	}
+}
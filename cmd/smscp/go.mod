@@ -1,9 +0,0 @@
-module github.com/mini-eggs/smscp/cmd/smscp
-
-go 1.13
-
-require (
-	github.com/pkg/errors v0.8.1
-	github.com/urfave/cli/v2 v2.0.0
-	golang.org/x/crypto v0.0.0-20191122220453-ac88ee75c92c
-)
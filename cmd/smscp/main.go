@@ -2,12 +2,11 @@ package main
 
 import (
 	"bufio"
+	"context"
 	"encoding/json"
 	"fmt"
 	"io/ioutil"
 	"log"
-	"net/http"
-	"net/url"
 	"os"
 	"os/user"
 	"path"
@@ -16,263 +15,255 @@ import (
 	"github.com/pkg/errors"
 	"github.com/urfave/cli/v2"
 	"golang.org/x/crypto/ssh/terminal"
+	"smscp.xyz/pkg/client"
+	"smscp.xyz/pkg/e2e"
 )
 
 const (
 	// BASE = "http://localhost:3000"
 	BASE = "https://smscp.xyz"
 	// BASE        = "https://beta.smscp.xyz"
-	APILogin    = BASE + "/cli/user/login"
-	APIRegister = BASE + "/cli/user/create"
-	APICreate   = BASE + "/cli/note/create"
-	APILatest   = BASE + "/cli/note/latest"
 )
 
 type config struct {
 	Token string
 }
 
-type hash map[string]string
-
 // helper
 
-func post(dest string, values hash) (*http.Response, error) {
-	next := url.Values{}
-	for key, val := range values {
-		// Remote white spaces, new lines, and windows line endings.
-		val = strings.Trim(strings.Trim(strings.Trim(val, " "), "\n"), "\r\n")
-		next.Add(key, val)
-	}
-	return http.PostForm(dest, next) // nolint - dest is always a constant (look up)
+// trim removes white spaces, new lines, and windows line endings.
+func trim(val string) string {
+	return strings.Trim(strings.Trim(strings.Trim(val, " "), "\n"), "\r\n")
 }
 
-// cli commands
-
-func register(c *cli.Context) error {
-	fmt.Printf("Username: ")
-	username, err := bufio.NewReader(os.Stdin).ReadString('\n')
+func configPath() (string, error) {
+	usr, err := user.Current()
 	if err != nil {
-		return errors.Wrap(err, "failed to read username from standard in")
+		return "", errors.Wrap(err, "failed to retrieve current user from operating system")
 	}
-	username = strings.Trim(strings.Trim(username, " "), "\n")
+	return path.Join(usr.HomeDir, ".smscp"), nil
+}
 
-	fmt.Printf("Password: ")
-	pass, err := terminal.ReadPassword(int(os.Stdin.Fd()))
-	if err != nil {
-		return errors.Wrap(err, "failed to read password from standard in")
+func saveToken(token string) error {
+	if token == "" {
+		return fmt.Errorf("no token received from remote server")
 	}
-	fmt.Println()
 
-	fmt.Printf("Verify password: ")
-	verify, err := terminal.ReadPassword(int(os.Stdin.Fd()))
+	file, err := configPath()
 	if err != nil {
-		return errors.Wrap(err, "failed to read password from standard in")
+		return err
 	}
-	fmt.Println()
 
-	fmt.Printf("Phone number (ten or eleven digit, US number): ")
-	phone, err := bufio.NewReader(os.Stdin).ReadString('\n')
+	bytes, err := json.Marshal(config{token})
 	if err != nil {
-		return errors.Wrap(err, "failed to read phone number from standard in")
+		return errors.Wrap(err, "failed to encode config file")
 	}
-	phone = strings.Trim(strings.Trim(phone, " "), "\n")
-
-	/* make http req */
 
-	resp, err := post(APIRegister, hash{
-		"Username": username,
-		"Phone":    phone,
-		"Password": string(pass),
-		"Verify":   string(verify),
-	})
+	err = ioutil.WriteFile(file, bytes, 0600)
 	if err != nil {
-		return errors.Wrap(err, "failed to create request to remote server")
+		return errors.Wrap(err, "failed to write config file")
 	}
 
-	defer resp.Body.Close()
+	return nil
+}
 
-	res, err := ioutil.ReadAll(resp.Body)
+// authed returns a client for the user logged in through `smscp login`.
+func authed() (*client.Client, error) {
+	file, err := configPath()
 	if err != nil {
-		return errors.Wrap(err, "failed to read response from to remote server")
+		return nil, err
 	}
 
-	if resp.StatusCode != http.StatusOK {
-		return errors.Wrap(fmt.Errorf(string(res)), "OK not received from remote server")
+	bytes, err := ioutil.ReadFile(file)
+	if err != nil {
+		return nil, errors.New("failed to read local file; please login")
 	}
 
 	var cfg config
-	err = json.Unmarshal(res, &cfg)
+	err = json.Unmarshal(bytes, &cfg)
 	if err != nil {
-		return errors.Wrap(err, "failed to read remote server response")
+		return nil, errors.New("failed to read local file; file of wrong format; please login")
 	} else if cfg.Token == "" {
-		return fmt.Errorf("no token received from remote server")
+		return nil, fmt.Errorf("failed to get user token; please login")
 	}
 
-	usr, err := user.Current()
+	return client.New(client.WithBaseURL(BASE), client.WithTraceparent(), client.WithChannel("cli"), client.WithToken(cfg.Token)), nil
+}
+
+func readLine(prompt string) (string, error) {
+	fmt.Print(prompt)
+	line, err := bufio.NewReader(os.Stdin).ReadString('\n')
+	return trim(line), err
+}
+
+func readPassword(prompt string) (string, error) {
+	fmt.Print(prompt)
+	pass, err := terminal.ReadPassword(int(os.Stdin.Fd()))
+	fmt.Println()
+	return trim(string(pass)), err
+}
+
+// readPassphrase asks on the terminal rather than standard in/out, which carry
+// the note itself for `new` and `latest`.
+func readPassphrase(prompt string) (string, error) {
+	tty, err := os.OpenFile("/dev/tty", os.O_RDWR, 0)
 	if err != nil {
-		return errors.Wrap(err, "failed to retrieve current user from operating system")
+		return "", errors.Wrap(err, "failed to open terminal for passphrase")
 	}
+	defer tty.Close()
 
-	err = ioutil.WriteFile(path.Join(usr.HomeDir, ".smscp"), res, 0644)
+	fmt.Fprint(tty, prompt)
+	pass, err := terminal.ReadPassword(int(tty.Fd()))
+	fmt.Fprintln(tty)
 	if err != nil {
-		return errors.Wrap(err, "failed to write config file")
+		return "", errors.Wrap(err, "failed to read passphrase from terminal")
 	}
-
-	return nil
+	return string(pass), nil
 }
 
-func login(c *cli.Context) error {
-	fmt.Printf("Username: ")
-	username, err := bufio.NewReader(os.Stdin).ReadString('\n')
+// cli commands
+
+func register(c *cli.Context) error {
+	username, err := readLine("Username: ")
 	if err != nil {
 		return errors.Wrap(err, "failed to read username from standard in")
 	}
-	username = strings.Trim(strings.Trim(username, " "), "\n")
 
-	fmt.Printf("Password: ")
-	pass, err := terminal.ReadPassword(int(os.Stdin.Fd()))
+	pass, err := readPassword("Password: ")
 	if err != nil {
 		return errors.Wrap(err, "failed to read password from standard in")
 	}
-	fmt.Println()
-
-	/* make http req */
 
-	resp, err := post(APILogin, hash{
-		"Username": username,
-		"Password": string(pass),
-	})
+	verify, err := readPassword("Verify password: ")
 	if err != nil {
-		return errors.Wrap(err, "failed to create request to remote server")
+		return errors.Wrap(err, "failed to read password from standard in")
 	}
 
-	defer resp.Body.Close()
-
-	res, err := ioutil.ReadAll(resp.Body)
+	phone, err := readLine("Phone number (ten or eleven digit, US number): ")
 	if err != nil {
-		return errors.Wrap(err, "failed to read response from to remote server")
+		return errors.Wrap(err, "failed to read phone number from standard in")
 	}
 
-	if resp.StatusCode != http.StatusOK {
-		return errors.Wrap(fmt.Errorf(string(res)), "OK not received from remote server")
+	session, err := client.New(client.WithBaseURL(BASE), client.WithTraceparent()).Register(context.Background(), username, pass, verify, phone)
+	if err != nil {
+		return err
 	}
 
-	var cfg config
-	err = json.Unmarshal(res, &cfg)
+	return saveToken(session.Token)
+}
+
+func login(c *cli.Context) error {
+	username, err := readLine("Username: ")
 	if err != nil {
-		return errors.Wrap(err, "failed to read remote server response")
-	} else if cfg.Token == "" {
-		return fmt.Errorf("no token received from remote server")
+		return errors.Wrap(err, "failed to read username from standard in")
 	}
 
-	usr, err := user.Current()
+	pass, err := readPassword("Password: ")
 	if err != nil {
-		return errors.Wrap(err, "failed to retrieve current user from operating system")
+		return errors.Wrap(err, "failed to read password from standard in")
 	}
 
-	err = ioutil.WriteFile(path.Join(usr.HomeDir, ".smscp"), res, 0644)
+	session, err := client.New(client.WithBaseURL(BASE), client.WithTraceparent()).Login(context.Background(), username, pass)
 	if err != nil {
-		return errors.Wrap(err, "failed to write config file")
+		return err
 	}
 
-	return nil
+	return saveToken(session.Token)
 }
 
 func create(c *cli.Context) error {
-	usr, err := user.Current()
+	api, err := authed()
 	if err != nil {
-		return errors.Wrap(err, "failed to retrieve current user from operating system")
+		return err
 	}
 
-	bytes, err := ioutil.ReadFile(path.Join(usr.HomeDir, ".smscp"))
+	text, err := ioutil.ReadAll(os.Stdin)
 	if err != nil {
-		return errors.New("failed to read local file; please login")
+		return err
 	}
 
-	var cfg config
-	err = json.Unmarshal(bytes, &cfg)
-	if err != nil {
-		return errors.New("failed to read local file; file of wrong format; please login")
-	} else if cfg.Token == "" {
-		return fmt.Errorf("failed to get user token; please login")
+	opts := client.NoteOptions{TTL: c.Duration("ttl"), Burn: c.Bool("burn")}
+	if !c.Bool("encrypt") {
+		_, err = api.CreateNoteWith(context.Background(), trim(string(text)), opts)
+		return err
 	}
 
-	text, err := ioutil.ReadAll(os.Stdin)
+	pass, err := readPassphrase("Passphrase: ")
 	if err != nil {
 		return err
 	}
-
-	resp, err := post(APICreate, hash{
-		"Token": cfg.Token,
-		"Text":  string(text),
-	})
+	verify, err := readPassphrase("Verify passphrase: ")
 	if err != nil {
-		return errors.Wrap(err, "failed to create request to remote server")
+		return err
 	}
-
-	defer resp.Body.Close()
-
-	res, err := ioutil.ReadAll(resp.Body)
-	if err != nil {
-		return errors.Wrap(err, "failed to read response from to remote server")
+	if pass != verify {
+		return errors.New("passphrases do not match")
 	}
 
-	if resp.StatusCode != http.StatusOK {
-		return errors.Wrap(fmt.Errorf(string(res)), "OK not received from remote server")
+	sealed, err := e2e.Seal(pass, trim(string(text)))
+	if err != nil {
+		return err
 	}
 
-	return nil
+	opts.Encrypted = true
+	_, err = api.CreateNoteWith(context.Background(), sealed, opts)
+	return err
 }
 
 func latest(c *cli.Context) error {
-	usr, err := user.Current()
+	api, err := authed()
 	if err != nil {
-		return errors.Wrap(err, "failed to retrieve current user from operating system")
+		return err
 	}
 
-	bytes, err := ioutil.ReadFile(path.Join(usr.HomeDir, ".smscp"))
-	if err != nil {
-		return errors.New("failed to read local file; please login")
+	note, err := api.Latest(context.Background())
+	if e, ok := err.(*client.Error); ok && e.NotFound() {
+		return errors.New("no note availavle; you have not made any?")
+	} else if err != nil {
+		return err
 	}
 
-	var cfg config
-	err = json.Unmarshal(bytes, &cfg)
-	if err != nil {
-		return errors.New("failed to read local file; file of wrong format; please login")
-	} else if cfg.Token == "" {
-		return fmt.Errorf("failed to get user token; please login")
+	text := note.Text
+	if note.Encrypted {
+		pass, err := readPassphrase("Passphrase: ")
+		if err != nil {
+			return err
+		}
+		if text, err = e2e.Open(pass, note.Text); err != nil {
+			return err
+		}
 	}
 
-	resp, err := post(APILatest, hash{"Token": cfg.Token})
-	if err != nil {
-		return errors.Wrap(err, "failed to create request to remote server")
+	fmt.Println(strings.TrimSpace(text))
+	return nil
+}
+
+func importNotes(c *cli.Context) error {
+	if c.NArg() != 1 {
+		return errors.New("usage: smscp import <file>")
 	}
-	defer resp.Body.Close()
 
-	res, err := ioutil.ReadAll(resp.Body)
+	api, err := authed()
 	if err != nil {
-		return errors.Wrap(err, "failed to read response from to remote server")
+		return err
 	}
 
-	if resp.StatusCode != http.StatusOK {
-		return errors.Wrap(fmt.Errorf(string(res)), "OK not received from remote server")
+	file, err := os.Open(c.Args().First())
+	if err != nil {
+		return errors.Wrap(err, "failed to open import file")
 	}
+	defer file.Close()
 
-	var response struct {
-		Note struct {
-			NoteText string
-		}
-	}
-	err = json.Unmarshal(res, &response)
+	res, err := api.Import(context.Background(), file)
 	if err != nil {
-		return errors.Wrap(err, "invalid response from to remote server")
+		return err
 	}
 
-	if response.Note.NoteText == "" {
-		return errors.New("no note availavle; you have not made any?")
+	for _, row := range res.Errors {
+		fmt.Fprintf(os.Stderr, "line %d: %s\n", row.Line, row.Message)
 	}
+	fmt.Printf("imported %d notes, skipped %d duplicates, %d errors\n", res.Imported, res.Duplicates, len(res.Errors))
 
-	fmt.Println(strings.TrimSpace(response.Note.NoteText))
 	return nil
 }
 
@@ -280,16 +271,33 @@ func main() {
 	app := cli.NewApp()
 	app.Name = "smscp"
 	app.Usage = "CLI for https://smscp.xyz/"
-	app.Version = "0.1.7"
+	app.Version = "0.5.0"
 
 	app.Commands = []*cli.Command{
 		{Name: "register", Action: register},
 		{Name: "login", Action: login},
-		{Name: "new", Action: create},
+		{
+			Name:   "new",
+			Action: create,
+			Flags: []cli.Flag{
+				&cli.BoolFlag{Name: "encrypt", Usage: "encrypt with a passphrase before sending; the server only stores ciphertext"},
+				&cli.DurationFlag{Name: "ttl", Usage: "delete the note after this long, e.g. 10m"},
+				&cli.BoolFlag{Name: "burn", Usage: "delete the note once it is fetched by latest"},
+			},
+		},
 		{Name: "latest", Action: latest},
+		{
+			Name:      "import",
+			Usage:     "load notes from an export or a CSV with a text column, without texting them",
+			ArgsUsage: "<file>",
+			Action:    importNotes,
+		},
 	}
 
 	if err := app.Run(os.Args); err != nil {
+		if e, ok := err.(*client.Error); ok && e.TraceID != "" && e.StatusCode >= 500 {
+			log.Fatalf("%s (trace %s)", err, e.TraceID)
+		}
 		log.Fatal(err)
 	}
 }
@@ -6,27 +6,61 @@ import (
 	"net/http/httptest"
 	"net/url"
 	"os"
+	"regexp"
 	"testing"
 
 	"github.com/Pallinder/go-randomdata"
 	"github.com/davecgh/go-spew/spew"
 	"gopkg.in/go-playground/assert.v1"
+	"smscp.xyz/internal/config"
 	"smscp.xyz/pkg/builder"
 	"smscp.xyz/pkg/mode"
 )
 
 var (
-	server, _ = builder.Build(mode.Test)
+	cfg, _    = config.Load(mode.Test, nil)
+	server, _ = builder.New(mode.Test, builder.WithConfig(cfg))
 )
 
 // helpers
 
-func fromSession(in *httptest.ResponseRecorder, req *http.Request) *httptest.ResponseRecorder {
-	out := httptest.NewRecorder()
-	for _, item := range in.Result().Cookies() {
+// browser keeps the session cookie between requests and sends back the csrf
+// token rendered into the page, as a browser submitting our forms would.
+type browser struct {
+	cookies []*http.Cookie
+	csrf    string
+}
+
+var csrfInput = regexp.MustCompile(`name='_csrf' value='([^']+)'`)
+
+func newBrowser() *browser {
+	b := &browser{}
+	w := b.do(httptest.NewRecorder(), "GET", "/", nil)
+	if match := csrfInput.FindStringSubmatch(w.Body.String()); match != nil {
+		b.csrf = match[1]
+	}
+	return b
+}
+
+func (b *browser) do(w *httptest.ResponseRecorder, method, path string, form url.Values) *httptest.ResponseRecorder {
+	req, _ := http.NewRequest(method, path, nil)
+	if form != nil {
+		form.Set("_csrf", b.csrf)
+		req.PostForm = form
+		req.Header.Add("Content-Type", "application/x-www-form-urlencoded")
+	}
+	for _, item := range b.cookies {
 		req.AddCookie(item)
 	}
-	return out
+	server.ServeHTTP(w, req)
+	if cookies := w.Result().Cookies(); len(cookies) > 0 {
+		b.cookies = cookies
+	}
+	return w
+}
+
+func (b *browser) post(path string, form url.Values) *httptest.ResponseRecorder {
+	return b.do(httptest.NewRecorder(), "POST", path, form)
 }
 
 // Prehook to remove test db data
@@ -92,11 +126,7 @@ func TestBasic(t *testing.T) {
 func TestUserCreationGood(t *testing.T) {
 	t.Parallel()
 	user := goodUser()
-	w := httptest.NewRecorder()
-	req, _ := http.NewRequest("POST", "/user/create", nil)
-	req.PostForm = user
-	req.Header.Add("Content-Type", "application/x-www-form-urlencoded")
-	server.ServeHTTP(w, req)
+	w := newBrowser().post("/user/create", user)
 	if w.Code != http.StatusTemporaryRedirect {
 		spew.Dump("TestUserCreationGood", w.Body.String(), user)
 	}
@@ -105,34 +135,31 @@ func TestUserCreationGood(t *testing.T) {
 
 func TestUserCreationBadPass(t *testing.T) {
 	t.Parallel()
-	w := httptest.NewRecorder()
-	req, _ := http.NewRequest("POST", "/user/create", nil)
-	req.PostForm = badUserPass()
-	req.Header.Add("Content-Type", "application/x-www-form-urlencoded")
-	server.ServeHTTP(w, req)
-	assert.Equal(t, http.StatusInternalServerError, w.Code)
+	w := newBrowser().post("/user/create", badUserPass())
+	assert.Equal(t, http.StatusBadRequest, w.Code)
 }
 
 func TestUserCreationBadPhone(t *testing.T) {
 	t.Parallel()
-	w := httptest.NewRecorder()
-	req, _ := http.NewRequest("POST", "/user/create", nil)
-	req.PostForm = badUserPhone()
-	req.Header.Add("Content-Type", "application/x-www-form-urlencoded")
-	server.ServeHTTP(w, req)
-	assert.Equal(t, http.StatusInternalServerError, w.Code)
+	w := newBrowser().post("/user/create", badUserPhone())
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+}
+
+func TestUserCreationNoCSRF(t *testing.T) {
+	t.Parallel()
+	b := newBrowser()
+	b.csrf = "bogus"
+	w := b.post("/user/create", goodUser())
+	assert.Equal(t, http.StatusForbidden, w.Code)
 }
 
 func TestUserUpdateGood(t *testing.T) {
 	t.Parallel()
 	user := goodUser()
+	b := newBrowser()
 
 	// create user
-	req, _ := http.NewRequest("POST", "/user/create", nil)
-	req.PostForm = user
-	req.Header.Add("Content-Type", "application/x-www-form-urlencoded")
-	w := httptest.NewRecorder()
-	server.ServeHTTP(w, req)
+	w := b.post("/user/create", user)
 	if w.Code != http.StatusTemporaryRedirect {
 		spew.Dump("TestUserUpdateGood", w.Body.String(), user)
 	}
@@ -143,31 +170,20 @@ func TestUserUpdateGood(t *testing.T) {
 	user.Add("Username", randomdata.SillyName())
 
 	// update user
-	req, _ = http.NewRequest("POST", "/user/update", nil)
-	req.PostForm = user
-	req.Header.Add("Content-Type", "application/x-www-form-urlencoded")
-	w = fromSession(w, req)
-	server.ServeHTTP(w, req)
+	w = b.post("/user/update", user)
 	assert.Equal(t, http.StatusTemporaryRedirect, w.Code)
 
 	// logout user
-	req, _ = http.NewRequest("POST", "/user/logout", nil)
-	w = fromSession(w, req)
-	server.ServeHTTP(w, req)
+	w = b.post("/user/logout", url.Values{})
 	assert.Equal(t, http.StatusTemporaryRedirect, w.Code)
 
 	// login user with new creds
-	req, _ = http.NewRequest("POST", "/user/login", nil)
-	req.PostForm = user
-	req.Header.Add("Content-Type", "application/x-www-form-urlencoded")
-	w = httptest.NewRecorder()
-	server.ServeHTTP(w, req)
+	w = b.post("/user/login", user)
 	assert.Equal(t, http.StatusTemporaryRedirect, w.Code)
 }
 
 func TestUserUpdateBad(t *testing.T) {
 	t.Parallel()
-
 	badUsers := []url.Values{
 		badUserPass(),
 		badUserPhone(),
@@ -175,13 +191,10 @@ func TestUserUpdateBad(t *testing.T) {
 
 	for _, badUser := range badUsers {
 		user := goodUser()
+		b := newBrowser()
 
 		// create user
-		req, _ := http.NewRequest("POST", "/user/create", nil)
-		req.PostForm = user
-		req.Header.Add("Content-Type", "application/x-www-form-urlencoded")
-		w := httptest.NewRecorder()
-		server.ServeHTTP(w, req)
+		w := b.post("/user/create", user)
 		assert.Equal(t, http.StatusTemporaryRedirect, w.Code)
 
 		// modify user
@@ -189,33 +202,19 @@ func TestUserUpdateBad(t *testing.T) {
 		badUser.Set("Username", user.Get("Username"))
 
 		// update user
-		req, _ = http.NewRequest("POST", "/user/update", nil)
-		req.PostForm = badUser
-		req.Header.Add("Content-Type", "application/x-www-form-urlencoded")
-		w = fromSession(w, req)
-		server.ServeHTTP(w, req)
-		assert.Equal(t, http.StatusInternalServerError, w.Code)
+		w = b.post("/user/update", badUser)
+		assert.Equal(t, http.StatusBadRequest, w.Code)
 
 		// logout user
-		req, _ = http.NewRequest("POST", "/user/logout", nil)
-		w = fromSession(w, req)
-		server.ServeHTTP(w, req)
+		w = b.post("/user/logout", url.Values{})
 		assert.Equal(t, http.StatusTemporaryRedirect, w.Code)
 
 		// login user with bad creds
-		req, _ = http.NewRequest("POST", "/user/login", nil)
-		req.PostForm = badUser
-		req.Header.Add("Content-Type", "application/x-www-form-urlencoded")
-		w = fromSession(w, req)
-		server.ServeHTTP(w, req)
-		assert.Equal(t, http.StatusInternalServerError, w.Code)
+		w = b.post("/user/login", badUser)
+		assert.Equal(t, http.StatusUnauthorized, w.Code)
 
 		// login user with good creds
-		req, _ = http.NewRequest("POST", "/user/login", nil)
-		req.PostForm = user
-		req.Header.Add("Content-Type", "application/x-www-form-urlencoded")
-		w = fromSession(w, req)
-		server.ServeHTTP(w, req)
+		w = b.post("/user/login", user)
 		assert.Equal(t, http.StatusTemporaryRedirect, w.Code)
 	}
 }
@@ -223,13 +222,10 @@ func TestUserUpdateBad(t *testing.T) {
 func TestUserLoginBad(t *testing.T) {
 	t.Parallel()
 	user := goodUser()
+	b := newBrowser()
 
 	// create user
-	w := httptest.NewRecorder()
-	req, _ := http.NewRequest("POST", "/user/create", nil)
-	req.PostForm = user
-	req.Header.Add("Content-Type", "application/x-www-form-urlencoded")
-	server.ServeHTTP(w, req)
+	w := b.post("/user/create", user)
 	if w.Code != http.StatusTemporaryRedirect {
 		spew.Dump("TestUserLoginBad", w.Body.String(), user)
 	}
@@ -240,62 +236,48 @@ func TestUserLoginBad(t *testing.T) {
 	user.Add("Password", randomdata.SillyName())
 
 	// login bad user
-	req, _ = http.NewRequest("POST", "/user/login", nil)
-	req.PostForm = user
-	req.Header.Add("Content-Type", "application/x-www-form-urlencoded")
-	w = fromSession(w, req)
-	server.ServeHTTP(w, req)
-	assert.Equal(t, http.StatusInternalServerError, w.Code)
+	w = b.post("/user/login", user)
+	assert.Equal(t, http.StatusUnauthorized, w.Code)
 }
 
 func TestNoteGood(t *testing.T) {
 	t.Parallel()
-	user := goodUser()
+	b := newBrowser()
 
 	// create user
-	w := httptest.NewRecorder()
-	req, _ := http.NewRequest("POST", "/user/create", nil)
-	req.PostForm = user
-	req.Header.Add("Content-Type", "application/x-www-form-urlencoded")
-	server.ServeHTTP(w, req)
+	w := b.post("/user/create", goodUser())
 	assert.Equal(t, http.StatusTemporaryRedirect, w.Code)
 
 	// create note
-	req, _ = http.NewRequest("POST", "/note/create", nil)
-	req.PostForm = goodNote()
-	req.Header.Add("Content-Type", "application/x-www-form-urlencoded")
-	w = fromSession(w, req)
-	server.ServeHTTP(w, req)
+	w = b.post("/note/create", goodNote())
 	assert.Equal(t, http.StatusTemporaryRedirect, w.Code)
 }
 
 func TestNoteBad(t *testing.T) {
 	t.Parallel()
-	user := goodUser()
+	b := newBrowser()
 
 	// create user
-	w := httptest.NewRecorder()
-	req, _ := http.NewRequest("POST", "/user/create", nil)
-	req.PostForm = user
-	req.Header.Add("Content-Type", "application/x-www-form-urlencoded")
-	server.ServeHTTP(w, req)
+	w := b.post("/user/create", goodUser())
 	assert.Equal(t, http.StatusTemporaryRedirect, w.Code)
 
 	// create note
-	req, _ = http.NewRequest("POST", "/note/create", nil)
-	req.PostForm = badNote()
-	req.Header.Add("Content-Type", "application/x-www-form-urlencoded")
-	w = fromSession(w, req)
-	server.ServeHTTP(w, req)
+	w = b.post("/note/create", badNote())
 	assert.Equal(t, http.StatusTemporaryRedirect, w.Code)
 }
 
 func TestNoteNoUser(t *testing.T) {
+	t.Parallel()
+	w := newBrowser().post("/note/create", badNote())
+	assert.Equal(t, http.StatusUnauthorized, w.Code)
+}
+
+func TestNoteNoUserJSON(t *testing.T) {
 	t.Parallel()
 	w := httptest.NewRecorder()
-	req, _ := http.NewRequest("POST", "/note/create", nil)
-	req.PostForm = badNote()
-	req.Header.Add("Content-Type", "application/x-www-form-urlencoded")
+	req, _ := http.NewRequest("GET", "/note/list/1", nil)
+	req.Header.Add("Accept", "application/json")
 	server.ServeHTTP(w, req)
-	assert.Equal(t, http.StatusInternalServerError, w.Code)
+	assert.Equal(t, http.StatusUnauthorized, w.Code)
+	assert.Equal(t, "application/json; charset=utf-8", w.Header().Get("Content-Type"))
 }
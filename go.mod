@@ -1,26 +1,32 @@
 module smscp.xyz
 
-go 1.13
+go 1.16
 
 require (
 	cloud.google.com/go v0.37.4
+	github.com/BurntSushi/toml v1.2.1
 	github.com/Pallinder/go-randomdata v1.2.0
+	github.com/alicebob/miniredis/v2 v2.17.0
 	github.com/davecgh/go-spew v1.1.1
 	github.com/dgrijalva/jwt-go v3.2.0+incompatible
 	github.com/gin-contrib/sessions v0.0.1
 	github.com/gin-gonic/gin v1.4.0
+	github.com/go-redis/redis/v7 v7.4.1
 	github.com/kr/pretty v0.1.0 // indirect
-	github.com/pkg/errors v0.8.1
+	github.com/pkg/errors v0.9.1
+	github.com/prometheus/client_golang v1.11.1
 	github.com/sfreiberg/gotwilio v0.0.0-20191103223526-1b5db731dc0a
-	github.com/tdewolff/minify v2.3.6+incompatible
-	github.com/tdewolff/parse v2.3.4+incompatible // indirect
-	github.com/tdewolff/test v1.0.5 // indirect
 	github.com/ttacon/builder v0.0.0-20170518171403-c099f663e1c2 // indirect
 	github.com/ttacon/libphonenumber v1.0.1
-	github.com/urfave/cli v1.22.1
-	golang.org/x/crypto v0.0.0-20191111213947-16651526fdb4
+	github.com/urfave/cli/v2 v2.0.0
+	go.opentelemetry.io/otel v1.0.1
+	go.opentelemetry.io/otel/exporters/stdout/stdouttrace v1.0.1
+	go.opentelemetry.io/otel/sdk v1.0.1
+	go.opentelemetry.io/otel/trace v1.0.1
+	golang.org/x/crypto v0.0.0-20200622213623-75b288015ac9
 	golang.org/x/exp v0.0.0-20190121172915-509febef88a4
 	google.golang.org/api v0.3.1
-	gopkg.in/check.v1 v1.0.0-20180628173108-788fd7840127 // indirect
+	google.golang.org/grpc v1.19.0
 	gopkg.in/go-playground/assert.v1 v1.2.1
+	gopkg.in/yaml.v2 v2.3.0
 )
@@ -0,0 +1,205 @@
+package api
+
+import (
+	"context"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/pkg/errors"
+	"smscp.xyz/internal/clientip"
+	"smscp.xyz/internal/common"
+)
+
+// adminSearchLimit caps how many accounts a search returns.
+const adminSearchLimit = 50
+
+type accountResource struct {
+	ID            string     `json:"id"`
+	Username      string     `json:"username"`
+	Phone         string     `json:"phone"`
+	CreatedAt     time.Time  `json:"created_at"`
+	Admin         bool       `json:"admin"`
+	FailedLogins  int        `json:"failed_logins"`
+	LockedAt      *time.Time `json:"locked_at,omitempty"`
+	LockedByAdmin bool       `json:"locked_by_admin"`
+	DeleteAt      *time.Time `json:"delete_at,omitempty"`
+	Notes         int        `json:"notes"`
+	SMSToday      int        `json:"sms_today"`
+	SMS30Days     int        `json:"sms_30_days"`
+}
+
+type accountListResource struct {
+	Query    string            `json:"query"`
+	Accounts []accountResource `json:"accounts"`
+}
+
+func (app App) toAccountResource(account common.Account) accountResource {
+	now := app.cfg.now()
+	res := accountResource{
+		ID:            account.ID,
+		Username:      account.Username,
+		Phone:         account.Phone,
+		CreatedAt:     account.CreatedAt,
+		Admin:         account.Admin,
+		FailedLogins:  account.FailedLogins,
+		LockedByAdmin: account.LockedByAdmin,
+		Notes:         account.Notes,
+		SMSToday:      account.SMSSince(now),
+		SMS30Days:     account.SMSSince(now.AddDate(0, 0, 1-common.SMSDays)),
+	}
+	if at := account.LockedAt; !at.IsZero() {
+		res.LockedAt = &at
+	}
+	if at := account.DeleteAt; !at.IsZero() {
+		res.DeleteAt = &at
+	}
+	return res
+}
+
+// service; smscp-admin calls these directly
+
+// AdminSearch finds accounts by username prefix or, when query reads as a
+// phone number in any format, by phone.
+func (app App) AdminSearch(ctx context.Context, query string) ([]common.Account, error) {
+	query = strings.TrimSpace(query)
+	if query == "" {
+		return nil, nil
+	}
+	if phone, err := parsePhone(query); err == nil {
+		query = phone
+	}
+	return app.data.UserSearch(ctx, query, adminSearchLimit)
+}
+
+func (app App) AdminAccount(ctx context.Context, id string) (common.Account, error) {
+	return app.data.UserAccount(ctx, id)
+}
+
+// AdminLock locks the account until an operator unlocks it. Its sessions stop
+// working at once and a password reset doesn't unlock it. Like the other
+// actions it is audited as done by actor.
+func (app App) AdminLock(ctx context.Context, actor, id string) error {
+	return app.adminDo(ctx, common.AuditLocked, actor, id, func() error {
+		return app.data.UserSetLocked(ctx, id, true)
+	})
+}
+
+// AdminUnlock unlocks the account, whether an operator or failed logins locked
+// it.
+func (app App) AdminUnlock(ctx context.Context, actor, id string) error {
+	return app.adminDo(ctx, common.AuditUnlocked, actor, id, func() error {
+		return app.data.UserSetLocked(ctx, id, false)
+	})
+}
+
+// AdminLogout ends every session of the account: web, cli and api tokens.
+func (app App) AdminLogout(ctx context.Context, actor, id string) error {
+	return app.adminDo(ctx, common.AuditSessionsEnded, actor, id, func() error {
+		return app.data.UserEndSessions(ctx, id)
+	})
+}
+
+// adminDo runs action on the account with id, then audits it. Requests from
+// /admin also record where the operator was.
+func (app App) adminDo(ctx context.Context, typ common.AuditType, actor, id string, action func() error) error {
+	account, err := app.data.UserAccount(ctx, id)
+	if err != nil {
+		return err
+	}
+	if err := action(); err != nil {
+		return err
+	}
+
+	event := common.AuditEvent{Type: typ, UserID: id, Username: account.Username, Actor: actor, At: app.cfg.now().UTC()}
+	if c, ok := ctx.(*gin.Context); ok {
+		event.IP, event.UserAgent = clientip.Get(c), c.Request.UserAgent()
+	}
+	return errors.Wrap(app.data.AuditAppend(ctx, event), "failed to record audit event")
+}
+
+// http
+
+// Admin guards /admin: only a signed in user with the admin role passes.
+// Grant it with smscp-admin grant-admin.
+func (app App) Admin(c *gin.Context) {
+	user, err := app.currentUser(c)
+	if err != nil {
+		app.error(c, err)
+		return
+	}
+	if !user.Admin() {
+		app.error(c, forbidden(errors.New("admins only")))
+		return
+	}
+	c.Next()
+}
+
+// PageAdmin searches accounts by ?q=, rendering the admin page or, when asked
+// for, JSON.
+func (app App) PageAdmin(c *gin.Context) {
+	query := c.Query("q")
+	accounts, err := app.AdminSearch(c, query)
+	if err != nil {
+		app.error(c, err)
+		return
+	}
+
+	res := accountListResource{Query: query, Accounts: []accountResource{}}
+	for _, account := range accounts {
+		res.Accounts = append(res.Accounts, app.toAccountResource(account))
+	}
+
+	c.Negotiate(http.StatusOK, gin.Negotiate{
+		Offered:  []string{gin.MIMEHTML, gin.MIMEJSON},
+		HTMLName: "admin.html",
+		HTMLData: gin.H{"Query": res.Query, "Accounts": res.Accounts},
+		JSONData: res,
+	})
+}
+
+func (app App) PageAdminUser(c *gin.Context) {
+	account, err := app.AdminAccount(c, c.Param("id"))
+	if err != nil {
+		app.error(c, err)
+		return
+	}
+	res := app.toAccountResource(account)
+
+	c.Negotiate(http.StatusOK, gin.Negotiate{
+		Offered:  []string{gin.MIMEHTML, gin.MIMEJSON},
+		HTMLName: "admin-user.html",
+		HTMLData: gin.H{"Account": res, "CSRF": csrfToken(c)},
+		JSONData: res,
+	})
+}
+
+func (app App) AdminUserLock(c *gin.Context) {
+	app.adminAction(c, app.AdminLock)
+}
+
+func (app App) AdminUserUnlock(c *gin.Context) {
+	app.adminAction(c, app.AdminUnlock)
+}
+
+func (app App) AdminUserLogout(c *gin.Context) {
+	app.adminAction(c, app.AdminLogout)
+}
+
+// adminAction runs action, as the signed in admin, on the account in the
+// path, then shows the account.
+func (app App) adminAction(c *gin.Context, action func(ctx context.Context, actor, id string) error) {
+	admin, err := app.currentUser(c)
+	if err != nil {
+		app.error(c, err)
+		return
+	}
+
+	id := c.Param("id")
+	if err := action(c, admin.Username(), id); err != nil {
+		app.error(c, err)
+		return
+	}
+	c.Redirect(http.StatusSeeOther, "/admin/users/"+id)
+}
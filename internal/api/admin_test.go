@@ -0,0 +1,118 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/pkg/errors"
+	"gopkg.in/go-playground/assert.v1"
+	"smscp.xyz/internal/common"
+)
+
+func (d *fakeData) UserSearch(ctx context.Context, query string, limit int) ([]common.Account, error) {
+	var accounts []common.Account
+	for _, user := range d.users {
+		if strings.HasPrefix(user.username, query) || user.phone == query {
+			accounts = append(accounts, common.Account{ID: user.id, Username: user.username, Phone: user.phone})
+		}
+	}
+	return accounts, nil
+}
+
+func (d *fakeData) UserAccount(ctx context.Context, id string) (common.Account, error) {
+	for _, user := range d.users {
+		if user.id != id {
+			continue
+		}
+		account := common.Account{ID: id, Username: user.username, LockedByAdmin: d.locked[user.username]}
+		for _, note := range d.notes {
+			if note.userID == id {
+				account.Notes++
+			}
+		}
+		account.SMSSent = map[string]int{time.Now().UTC().Format("2006-01-02"): d.sms[id]}
+		return account, nil
+	}
+	return common.Account{}, notFound(errors.New("no such user"))
+}
+
+func (d *fakeData) UserSetLocked(ctx context.Context, id string, locked bool) error {
+	for _, user := range d.users {
+		if user.id == id {
+			d.locked[user.username] = locked
+			return nil
+		}
+	}
+	return notFound(errors.New("no such user"))
+}
+
+func (d *fakeData) UserEndSessions(ctx context.Context, id string) error {
+	d.ended[id] = true
+	return nil
+}
+
+func TestAdmin(t *testing.T) {
+	router, data, _ := testRouter()
+	data.users["bob"] = &fakeUser{id: "bob", username: "bob", phone: "12085550101"}
+	data.notes = []fakeNote{{id: "1", text: "hello", userID: "bob"}}
+	send := testWebRouter(t, data, &fakeSMS{}, func(app App, router *gin.Engine) {
+		admin := router.Group("/admin", app.Admin)
+		admin.GET("", app.PageAdmin)
+		admin.GET("/users/:id", app.PageAdminUser)
+		admin.POST("/users/:id/lock", app.AdminUserLock)
+		admin.POST("/users/:id/unlock", app.AdminUserUnlock)
+		admin.POST("/users/:id/logout", app.AdminUserLogout)
+	})
+
+	assert.Equal(t, http.StatusForbidden, send("GET", "/admin?q=bo", "").Code)
+	data.users["alice"].admin = true
+
+	// By username prefix, or by phone as typed.
+	for _, q := range []string{"bo", "(208)+555-0101"} {
+		w := send("GET", "/admin?q="+q, "")
+		assert.Equal(t, http.StatusOK, w.Code)
+		var list accountListResource
+		assert.Equal(t, nil, json.Unmarshal(w.Body.Bytes(), &list))
+		assert.Equal(t, 1, len(list.Accounts))
+		assert.Equal(t, "bob", list.Accounts[0].ID)
+	}
+
+	// Texting bob counts towards his usage.
+	assert.Equal(t, http.StatusCreated, do(router, "POST", "/api/v1/notes", "token-bob", noteCreateRequest{Text: "hi"}).Code)
+
+	var account accountResource
+	w := send("GET", "/admin/users/bob", "")
+	assert.Equal(t, nil, json.Unmarshal(w.Body.Bytes(), &account))
+	assert.Equal(t, 2, account.Notes)
+	assert.Equal(t, 1, account.SMSToday)
+	assert.Equal(t, 1, account.SMS30Days)
+
+	w = send("POST", "/admin/users/bob/lock", "")
+	assert.Equal(t, http.StatusSeeOther, w.Code)
+	assert.Equal(t, "/admin/users/bob", w.Header().Get("Location"))
+	assert.Equal(t, true, data.locked["bob"])
+	send("POST", "/admin/users/bob/unlock", "")
+	assert.Equal(t, false, data.locked["bob"])
+
+	assert.Equal(t, http.StatusSeeOther, send("POST", "/admin/users/bob/logout", "").Code)
+	assert.Equal(t, http.StatusUnauthorized, do(router, "GET", "/api/v1/users/me", "token-bob", nil).Code)
+
+	assert.Equal(t, http.StatusNotFound, send("POST", "/admin/users/carol/lock", "").Code)
+
+	// Each action is audited against bob as done by alice.
+	var types []common.AuditType
+	for _, event := range data.audit {
+		if event.Actor != "" {
+			assert.Equal(t, "bob", event.UserID)
+			assert.Equal(t, "bob", event.Username)
+			assert.Equal(t, "alice", event.Actor)
+			types = append(types, event.Type)
+		}
+	}
+	assert.Equal(t, []common.AuditType{common.AuditLocked, common.AuditUnlocked, common.AuditSessionsEnded}, types)
+}
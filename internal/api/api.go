@@ -3,36 +3,58 @@ package api
 import (
 	"context"
 	"fmt"
-	"io/ioutil"
+	"io"
 	"net/http"
 	"net/url"
-	"os"
 	"strconv"
+	"strings"
 	"time"
+	"unicode"
 
 	"github.com/dgrijalva/jwt-go"
 	"github.com/gin-contrib/sessions"
 	"github.com/gin-gonic/gin"
 	"github.com/pkg/errors"
 	"github.com/ttacon/libphonenumber"
+	"smscp.xyz/internal/bus"
+	"smscp.xyz/internal/clientip"
 	"smscp.xyz/internal/common"
+	"smscp.xyz/pkg/e2e"
 )
 
 type App struct {
-	data dataLayer
-	sms  smsLayer
-	csv  csvLayer
-	sec  securityLayer
-	cfg  cfg
+	data   dataLayer
+	sms    smsLayer
+	export exportLayer
+	sec    securityLayer
+	bus    busLayer
+	limit  limitLayer
+	stats  metricsLayer
+	cfg    cfg
 }
 
 type cfg struct {
-	resetPassswordLink string
+	baseURL string /* Where links sent by sms point, without trailing slash. */
+	now     func() time.Time
+	done    context.Context /* Ends event streams; see WithDone. */
 }
 
 const (
 	perPage             = 20
 	sessionKeyUserToken = "USER_TOKEN"
+	resetAudience       = "smscp:reset"
+	resetLinkTTL        = 5 * time.Minute
+	deleteGracePeriod   = 7 * 24 * time.Hour
+	minNoteTTL          = time.Minute
+	maxNoteTTL          = 30 * 24 * time.Hour
+	readyTimeout        = 2 * time.Second
+	auditPageSize       = 20 /* Newest events shown on the settings page. */
+)
+
+// Data and SMS name the layers App needs for callers outside the package.
+type (
+	Data interface{ dataLayer }
+	SMS  interface{ smsLayer }
 )
 
 type dataLayer interface {
@@ -41,232 +63,233 @@ type dataLayer interface {
 	UserGetByNumber(ctx context.Context, number string) (common.User, error)
 	UserGetByUsername(ctx context.Context, username string) (common.User, error)
 	UserLogin(ctx context.Context, username, pass string) (common.User, error)
+	UserSeen(ctx context.Context, user common.User, ip, agent string) (bool, error)
+	UserResetCreate(ctx context.Context, user common.User) (string, error)
+	UserResetRedeem(ctx context.Context, id, nonce string) (common.User, error)
 	UserCreate(ctx context.Context, username, pass, phone string) (common.User, error)
+	UserSMSSent(ctx context.Context, user common.User, now time.Time) error
 	// notes
 	NoteGetList(ctx context.Context, user common.User, page, count int) ([]common.Note, bool, error)
 	NoteGetLatest(ctx context.Context, user common.User) (common.Note, error)
 	NoteGetLatestWithTime(ctx context.Context, user common.User, t time.Duration) (common.Note, error)
-	NoteCreate(ctx context.Context, user common.User, text string) (common.Note, error)
+	NoteGet(ctx context.Context, user common.User, id string) (common.Note, error)
+	NoteCreate(ctx context.Context, user common.User, text string, opts common.NoteOptions) (common.Note, error)
+	NoteImport(ctx context.Context, user common.User, notes []common.ImportedNote) (int, error)
+	NoteGetAllStored(ctx context.Context, user common.User) ([]common.Note, error)
+	NoteDel(ctx context.Context, note common.Note) error
 	// special gdpr
 	UserAll(context.Context, common.User) ([]common.Note, error)
 	UserDel(context.Context, common.User) error
+	UserGetDueForDeletion(ctx context.Context, now time.Time) ([]common.User, error)
+	// admin
+	UserSearch(ctx context.Context, query string, limit int) ([]common.Account, error)
+	UserAccount(ctx context.Context, id string) (common.Account, error)
+	UserSetLocked(ctx context.Context, id string, locked bool) error
+	UserEndSessions(ctx context.Context, id string) error
+	// audit
+	AuditAppend(ctx context.Context, event common.AuditEvent) error
+	AuditList(ctx context.Context, id string, limit int) ([]common.AuditEvent, error)
+	// readiness
+	Ping(ctx context.Context) error
 }
 
-type csvLayer interface {
-	ToFile(common.User, []common.Note) (*os.File, error)
+type exportLayer interface {
+	ContentType(format string) (string, error)
+	Write(w io.Writer, format string, user common.User, notes []common.Note) error
+	AuditContentType(format string) (string, error)
+	WriteAudit(w io.Writer, format string, events []common.AuditEvent) error
 }
 
 type smsLayer interface {
-	Send(number, text string) error
+	Send(ctx context.Context, number, text string) error
 	Hook(c *gin.Context) (number, text string, err error)
+	Check() error /* Whether it's configured to send, without sending. */
 }
 
-type securityLayer interface {
-	TokenCreate(val jwt.Claims) (string, error)
-	TokenFrom(tokenString string) (jwt.MapClaims, error)
+type metricsLayer interface {
+	NoteCreated(channel string, n int)
+	WebhookRejected(reason string)
 }
 
-func AppDefault(data dataLayer, sms smsLayer, csv csvLayer, sec securityLayer) App {
-	return App{
-		data,
-		sms,
-		csv,
-		sec,
-		cfg{"https://smscp.xyz/reset/%s"},
-	}
-}
-
-func (app App) HookSMS(c *gin.Context) {
-	num, text, err := app.sms.Hook(c)
-	if err != nil {
-		app.error(c, err)
-		return
-	}
+type noMetrics struct{}
 
-	user, err := app.data.UserGetByNumber(c, num)
-	if err != nil {
-		app.error(c, err)
-		return
-	}
+func (noMetrics) NoteCreated(string, int) {}
+func (noMetrics) WebhookRejected(string)  {}
 
-	_, err = app.data.NoteCreate(c, user, text)
-	if err != nil {
-		app.error(c, err)
-		return
-	}
-
-	c.String(http.StatusOK, "message received")
+type busLayer interface {
+	Publish(ctx context.Context, event bus.Event) error
+	Subscribe(ctx context.Context, userID string) (<-chan bus.Event, error)
 }
 
-func (app App) NoteCreate(c *gin.Context) {
-	var payload struct {
-		Text string
-	}
-
-	err := c.Bind(&payload)
-	if err != nil {
-		app.error(c, err)
-		return
-	}
-
-	user, err := app.currentUser(c)
-	if err != nil {
-		app.error(c, errors.New("not logged in; or something else terribly wrong"))
-		return
-	}
+type limitLayer interface {
+	Login(ctx context.Context, ip, username string) error
+	Signup(ctx context.Context, ip string) error
+	SMS(ctx context.Context, ip, phone, userID string) error
+}
 
-	note, err := app.data.NoteCreate(c, user, payload.Text)
-	if err != nil {
-		app.error(c, err)
-		return
-	}
+type securityLayer interface {
+	TokenCreate(ctx context.Context, val jwt.Claims) (string, error)
+	TokenFrom(ctx context.Context, tokenString string) (jwt.MapClaims, error)
+}
 
-	if err := app.sms.Send(user.Phone(), note.Text()); err != nil {
-		app.error(c, err)
-		return
-	}
+// forms, bound from x-www-form-urlencoded bodies
 
-	c.Redirect(http.StatusTemporaryRedirect, "/")
+type noteForm struct {
+	Text, TTL string /* TTL like 10m, empty to keep the note. */
+	Burn      bool
+}
+type noteCLIForm struct {
+	Token, Text, TTL string /* TTL like 10m, empty to keep the note. */
+	Burn             bool
+}
+type tokenCLIForm struct{ Token string }
+type loginForm struct{ Username, Password string }
+type userForm struct{ Username, Password, Verify, Phone string }
+type userUpdateForm struct {
+	Username, Password, Verify, Phone string
+	Retention                         string /* forever, 30d, 7d or last; empty to leave as is. */
+	RetentionKeep                     int    /* How many notes "last" keeps. */
 }
+type forgotPasswordForm struct{ Username string }
+type newPasswordForm struct{ Password, Verify string }
+type deleteAccountForm struct{ Password string }
 
-func (app App) NoteCreateCLI(c *gin.Context) {
-	var payload struct {
-		Token, Text string
-	}
+// cli responses
 
-	err := c.Bind(&payload)
-	if err != nil {
-		app.errorCLI(c, err)
-		return
-	}
+type cliTokenResponse struct{ Token string }
+type cliMessageResponse struct{ Message string }
+type cliLatestResponse struct {
+	Message string
+	Note    common.Note
+}
 
-	user, err := app.currentUserFromToken(c, payload.Token)
-	if err != nil {
-		app.errorCLI(c, errors.New("not logged in; or something else terribly wrong"))
-		return
-	}
+type readyResource struct {
+	Storage string `json:"storage"`
+	SMS     string `json:"sms"`
+}
 
-	note, err := app.data.NoteCreate(c, user, payload.Text)
-	if err != nil {
-		app.errorCLI(c, err)
-		return
-	}
+// noteListJSON backs the infinite scroll on the main page. It holds resources
+// rather than the stored user and notes, which carry password hashes, reset
+// nonces and login history.
+type noteListJSON struct {
+	HasUser      bool
+	User         userResource
+	Notes        []noteResource
+	NotesHasMore bool
+}
 
-	if err := app.sms.Send(user.Phone(), note.Text()); err != nil {
-		app.errorCLI(c, err)
-		return
+func AppDefault(data dataLayer, sms smsLayer, export exportLayer, sec securityLayer, bus busLayer, limit limitLayer) App {
+	return App{
+		data,
+		sms,
+		export,
+		sec,
+		bus,
+		limit,
+		noMetrics{},
+		cfg{"https://smscp.xyz", time.Now, context.Background()},
 	}
+}
 
-	c.JSON(http.StatusOK, gin.H{"Message": "complete"})
+// WithBaseURL sets the scheme and host used in links sent by sms.
+func (app App) WithBaseURL(url string) App {
+	app.cfg.baseURL = strings.TrimRight(url, "/")
+	return app
 }
 
-func (app App) NoteLatestCLI(c *gin.Context) {
-	var payload struct {
-		Token string
-	}
+// WithMetrics counts notes and webhook rejects.
+func (app App) WithMetrics(stats metricsLayer) App {
+	app.stats = stats
+	return app
+}
 
-	err := c.Bind(&payload)
-	if err != nil {
-		app.errorCLI(c, err)
-		return
-	}
+// WithDone ends open event streams once ctx is done. They otherwise last as
+// long as the browser tab, so a graceful shutdown would wait them out.
+func (app App) WithDone(ctx context.Context) App {
+	app.cfg.done = ctx
+	return app
+}
 
-	user, err := app.currentUserFromToken(c, payload.Token)
-	if err != nil {
-		app.errorCLI(c, errors.New("not logged in; or something else terribly wrong"))
-		return
-	}
+// WithClock replaces time.Now, i.e. to test grace periods and link expiry.
+func (app App) WithClock(now func() time.Time) App {
+	app.cfg.now = now
+	return app
+}
 
-	note, err := app.data.NoteGetLatest(c, user)
+func (app App) HookSMS(c *gin.Context) {
+	num, text, err := app.sms.Hook(c)
 	if err != nil {
-		app.errorCLI(c, err)
+		app.stats.WebhookRejected("invalid_request")
+		app.error(c, invalid(err))
 		return
 	}
 
-	c.JSON(http.StatusOK, gin.H{"Message": "complete", "Note": note})
-}
-
-func (app App) UserLogin(c *gin.Context) {
-	var payload struct {
-		Username, Password string
-	}
-
-	err := c.Bind(&payload)
+	user, err := app.data.UserGetByNumber(c, num)
 	if err != nil {
+		app.stats.WebhookRejected("unknown_number")
 		app.error(c, err)
 		return
 	}
 
-	user, err := app.data.UserLogin(c, payload.Username, payload.Password)
+	text, opts, err := smsOptions(text)
 	if err != nil {
+		app.stats.WebhookRejected("invalid_options")
 		app.error(c, err)
 		return
 	}
 
-	fsUser, err := app.data.UserLogin(c, payload.Username, payload.Password)
+	note, err := app.data.NoteCreate(c, user, text, opts)
 	if err != nil {
+		app.stats.WebhookRejected("storage")
 		app.error(c, err)
 		return
 	}
-	_ = fsUser
 
-	s := sessions.Default(c)
-	s.Set(sessionKeyUserToken, user.Token())
-	if err := s.Save(); err != nil {
-		app.error(c, err)
-		return
-	}
+	app.stats.NoteCreated("sms", 1)
+	app.publish(c, bus.NoteCreated, user, note.ID())
 
-	c.Redirect(http.StatusTemporaryRedirect, "/")
+	c.String(http.StatusOK, "message received")
 }
 
-func (app App) UserLoginCLI(c *gin.Context) {
-	var payload struct {
-		Username, Password string
-	}
+func (app App) NoteCreate(c *gin.Context) {
+	var payload noteForm
 
-	err := c.Bind(&payload)
+	err := c.ShouldBind(&payload)
 	if err != nil {
-		app.errorCLI(c, err)
+		app.error(c, invalid(err))
 		return
 	}
 
-	user, err := app.data.UserLogin(c, payload.Username, payload.Password)
+	user, err := app.currentUser(c)
 	if err != nil {
-		app.errorCLI(c, err)
+		app.error(c, unauthorized(errors.New("not logged in; or something else terribly wrong")))
 		return
 	}
 
-	c.JSON(http.StatusOK, gin.H{"Token": user.Token()})
-}
-
-func (app App) UserCreate(c *gin.Context) {
-	var payload struct {
-		Username, Password, Verify, Phone string
-	}
-
-	err := c.Bind(&payload)
+	ttl, err := parseTTL(payload.TTL)
 	if err != nil {
 		app.error(c, err)
 		return
 	}
 
-	if payload.Password != payload.Verify || payload.Password == "" {
-		app.error(c, errors.New("invalid password; either not equal or no password entered"))
+	if _, err := app.noteCreate(c, "web", user, payload.Text, common.NoteOptions{TTL: ttl, Burn: payload.Burn}); err != nil {
+		app.error(c, err)
 		return
 	}
 
-	phone, err := libphonenumber.Parse(payload.Phone, "US")
+	c.Redirect(http.StatusTemporaryRedirect, "/")
+}
+
+func (app App) UserLogin(c *gin.Context) {
+	var payload loginForm
+
+	err := c.ShouldBind(&payload)
 	if err != nil {
-		app.error(c, errors.Wrap(err, "must be US number"))
-		return
-	} else if !libphonenumber.IsValidNumber(phone) {
-		app.error(c, errors.New("invalid phone number; try again"))
+		app.error(c, invalid(err))
 		return
 	}
-	full := fmt.Sprintf("%d%d", phone.GetCountryCode(), phone.GetNationalNumber())
 
-	user, err := app.data.UserCreate(c, payload.Username, payload.Password, full)
+	user, err := app.userLogin(c, "web", payload.Username, payload.Password)
 	if err != nil {
 		app.error(c, err)
 		return
@@ -282,61 +305,42 @@ func (app App) UserCreate(c *gin.Context) {
 	c.Redirect(http.StatusTemporaryRedirect, "/")
 }
 
-func (app App) UserCreateCLI(c *gin.Context) {
-	var payload struct {
-		Username, Password, Verify, Phone string
-	}
-
-	err := c.Bind(&payload)
-	if err != nil {
-		app.errorCLI(c, err)
-		return
-	}
-
-	if payload.Password != payload.Verify || payload.Password == "" {
-		app.errorCLI(c, errors.New("invalid password; either not equal or no password entered"))
-		return
-	}
+func (app App) UserCreate(c *gin.Context) {
+	var payload userForm
 
-	phone, err := libphonenumber.Parse(payload.Phone, "US")
+	err := c.ShouldBind(&payload)
 	if err != nil {
-		app.errorCLI(c, err)
-		return
-	} else if !libphonenumber.IsValidNumber(phone) {
-		app.errorCLI(c, errors.New("invalid phone number; try again"))
+		app.error(c, invalid(err))
 		return
 	}
-	full := fmt.Sprintf("%d%d", phone.GetCountryCode(), phone.GetNationalNumber())
 
-	user, err := app.data.UserCreate(c, payload.Username, payload.Password, full)
+	user, err := app.userCreate(c, "web", payload.Username, payload.Password, payload.Verify, payload.Phone)
 	if err != nil {
-		app.errorCLI(c, err)
+		app.error(c, err)
 		return
 	}
 
 	s := sessions.Default(c)
 	s.Set(sessionKeyUserToken, user.Token())
 	if err := s.Save(); err != nil {
-		app.errorCLI(c, err)
+		app.error(c, err)
 		return
 	}
 
-	c.JSON(http.StatusOK, gin.H{"Token": user.Token()})
+	c.Redirect(http.StatusTemporaryRedirect, "/")
 }
 
 func (app App) UserUpdate(c *gin.Context) {
-	var payload struct {
-		Username, Password, Verify, Phone string
-	}
+	var payload userUpdateForm
 
-	err := c.Bind(&payload)
+	err := c.ShouldBind(&payload)
 	if err != nil {
-		app.error(c, err)
+		app.error(c, invalid(err))
 		return
 	}
 
 	if payload.Password != payload.Verify {
-		app.error(c, errors.New("invalid password; not equal"))
+		app.error(c, invalid(errors.New("invalid password; not equal")))
 		return
 	}
 
@@ -350,20 +354,31 @@ func (app App) UserUpdate(c *gin.Context) {
 		user.SetUsername(payload.Username)
 	}
 
+	var changed []common.AuditType
 	if payload.Password != "" {
 		user.SetPass(payload.Password)
+		changed = append(changed, common.AuditPasswordChanged)
 	}
 
 	if payload.Phone != "" {
-		phone, err := libphonenumber.Parse(payload.Phone, "US")
+		phone, err := parsePhone(payload.Phone)
 		if err != nil {
-			app.error(c, errors.Wrap(err, "must be US number"))
+			app.error(c, err)
 			return
-		} else if !libphonenumber.IsValidNumber(phone) {
-			app.error(c, errors.New("invalid phone number; try again"))
+		}
+		if phone != user.Phone() {
+			changed = append(changed, common.AuditPhoneChanged)
+		}
+		user.SetPhone(phone)
+	}
+
+	if payload.Retention != "" {
+		retention, err := parseRetention(payload.Retention, payload.RetentionKeep)
+		if err != nil {
+			app.error(c, err)
 			return
 		}
-		user.SetPhone(fmt.Sprintf("%d%d", phone.GetCountryCode(), phone.GetNationalNumber()))
+		user.SetRetention(retention)
 	}
 
 	err = user.Save(c)
@@ -371,6 +386,9 @@ func (app App) UserUpdate(c *gin.Context) {
 		app.error(c, err)
 		return
 	}
+	for _, typ := range changed {
+		app.audit(c, typ, user, "")
+	}
 
 	s := sessions.Default(c)
 	s.Set(sessionKeyUserToken, user.Token())
@@ -400,6 +418,7 @@ func (app App) Page(c *gin.Context) {
 		// session.
 		c.HTML(http.StatusOK, "main.html", gin.H{
 			"HasUser": false,
+			"CSRF":    csrfToken(c),
 		})
 		return
 	}
@@ -417,12 +436,20 @@ func (app App) Page(c *gin.Context) {
 		return
 	}
 
+	events, err := app.data.AuditList(c, user.ID(), auditPageSize)
+	if err != nil {
+		app.error(c, err)
+		return
+	}
+
 	c.HTML(http.StatusOK, "main.html", gin.H{
 		"HasUser":      true,
 		"User":         user,
 		"Notes":        notes,
 		"NotesHasMore": hasMore,
 		"Latest":       latest,
+		"Audit":        toAuditResources(events),
+		"CSRF":         csrfToken(c),
 	})
 }
 
@@ -431,14 +458,14 @@ func (app App) NoteListJSON(c *gin.Context) {
 	if err != nil {
 		// Not really an error, just we don't currently have a user stored in
 		// session.
-		app.error(c, errors.New("no user"))
+		app.error(c, unauthorized(errors.New("no user")))
 		return
 	}
 
 	strPage := c.Param("page")
 	page, err := strconv.Atoi(strPage)
 	if err != nil {
-		app.error(c, err)
+		app.error(c, invalid(err))
 		return
 	}
 
@@ -448,11 +475,46 @@ func (app App) NoteListJSON(c *gin.Context) {
 		return
 	}
 
-	c.JSON(http.StatusOK, gin.H{
-		"HasUser":      true,
-		"User":         user,
-		"Notes":        notes,
-		"NotesHasMore": hasMore,
+	res := noteListJSON{true, toUserResource(user), []noteResource{}, hasMore}
+	for _, note := range notes {
+		res.Notes = append(res.Notes, toNoteResource(note))
+	}
+
+	c.JSON(http.StatusOK, res)
+}
+
+// NoteEvents streams the user's note events until they go, or the app is done
+// (see WithDone).
+func (app App) NoteEvents(c *gin.Context) {
+	user, err := app.currentUser(c)
+	if err != nil {
+		app.error(c, unauthorized(errors.New("no user")))
+		return
+	}
+
+	ctx, cancel := context.WithCancel(c.Request.Context())
+	defer cancel()
+	go func() {
+		select {
+		case <-app.cfg.done.Done():
+			cancel()
+		case <-ctx.Done():
+		}
+	}()
+
+	events, err := app.bus.Subscribe(ctx, user.ID())
+	if err != nil {
+		app.error(c, errors.Wrap(err, "failed to subscribe to note events"))
+		return
+	}
+
+	c.Stream(func(w io.Writer) bool {
+		event, ok := <-events
+		if !ok {
+			return false
+		}
+		c.SSEvent(string(event.Type), gin.H{"NoteID": event.NoteID})
+		return true
 	})
 }
 
@@ -460,11 +522,34 @@ func (app App) Pong(c *gin.Context) {
 	c.String(http.StatusOK, "pong")
 }
 
+// Healthz is liveness: the process is serving. It checks nothing else, so a
+// storage outage doesn't get every instance restarted.
+func (app App) Healthz(c *gin.Context) {
+	c.String(http.StatusOK, "ok")
+}
+
+// Readyz is readiness: storage answers and sms is configured.
+func (app App) Readyz(c *gin.Context) {
+	ctx, cancel := context.WithTimeout(c, readyTimeout)
+	defer cancel()
+
+	res := readyResource{Storage: "ok", SMS: "ok"}
+	status := http.StatusOK
+	if err := app.data.Ping(ctx); err != nil {
+		res.Storage, status = err.Error(), http.StatusServiceUnavailable
+	}
+	if err := app.sms.Check(); err != nil {
+		res.SMS, status = err.Error(), http.StatusServiceUnavailable
+	}
+
+	c.JSON(status, res)
+}
+
 func (app App) currentUser(c *gin.Context) (common.User, error) {
 	s := sessions.Default(c)
 	token, ok := s.Get(sessionKeyUserToken).(string)
 	if !ok {
-		return nil, errors.New("no session available; no user")
+		return nil, unauthorized(errors.New("no session available; no user"))
 	}
 	return app.currentUserFromToken(c, token)
 }
@@ -474,67 +559,431 @@ func (app App) currentUserFromToken(ctx context.Context, token string) (common.U
 	return user, err
 }
 
-func (app App) error(c *gin.Context, err error) {
-	c.HTML(http.StatusInternalServerError, "error.html", gin.H{
-		"Error": err.Error(),
-	})
+// userCreate registers a user from channel: web, cli or api. Outside the web
+// the token is handed to the client to keep, like an API key, so that is
+// audited.
+func (app App) userCreate(c *gin.Context, channel, username, pass, verify, phone string) (common.User, error) {
+	if err := app.limit.Signup(c, clientip.Get(c)); err != nil {
+		return nil, err
+	}
+
+	if pass != verify || pass == "" {
+		return nil, invalid(errors.New("invalid password; either not equal or no password entered"))
+	}
+
+	full, err := parsePhone(phone)
+	if err != nil {
+		return nil, err
+	}
+
+	user, err := app.data.UserCreate(c, username, pass, full)
+	if err != nil {
+		return nil, err
+	}
+
+	// Remember where the account was made so signing in from the same place
+	// later isn't reported as new.
+	if _, err := app.data.UserSeen(c, user, clientip.Get(c), c.Request.UserAgent()); err != nil {
+		_ = c.Error(err)
+	}
+
+	if channel != "web" {
+		app.audit(c, common.AuditTokenCreated, user, channel)
+	}
+
+	return user, nil
 }
 
-func (app App) errorCLI(c *gin.Context, err error) {
-	c.String(http.StatusInternalServerError, err.Error())
+// userLogin logs in from channel, auditing it as userCreate does.
+func (app App) userLogin(c *gin.Context, channel, username, pass string) (common.User, error) {
+	if err := app.limit.Login(c, clientip.Get(c), username); err != nil {
+		return nil, err
+	}
+
+	user, err := app.data.UserLogin(c, username, pass)
+	if err != nil {
+		app.loginFailed(c, channel, username, err)
+		return nil, err
+	}
+
+	if err := app.restore(c, user); err != nil {
+		return nil, err
+	}
+
+	app.audit(c, common.AuditLogin, user, channel)
+	if channel != "web" {
+		app.audit(c, common.AuditTokenCreated, user, channel)
+	}
+
+	app.alertNewDevice(c, user)
+
+	return user, nil
 }
 
-func (app App) UserExportAllData(c *gin.Context) {
-	user, err := app.currentUser(c)
+// loginFailed audits a wrong password, or any password for a locked account,
+// against the account named if there is one. Other errors aren't attempts.
+func (app App) loginFailed(c *gin.Context, detail, username string, err error) {
+	if _, code := classify(err); code != "unauthorized" && code != "locked" {
+		return
+	}
+
+	event := common.AuditEvent{Type: common.AuditLoginFailed, Username: username, Detail: detail}
+	if user, err := app.data.UserGetByUsername(c, username); err == nil {
+		event.UserID = user.ID()
+	}
+	app.record(c, event)
+}
+
+// alertNewDevice texts the user when they sign in from an IP or user agent not
+// seen before. Like publish it is best effort; the login has already worked.
+func (app App) alertNewDevice(c *gin.Context, user common.User) {
+	seen, err := app.data.UserSeen(c, user, clientip.Get(c), c.Request.UserAgent())
 	if err != nil {
-		app.error(c, errors.New("no user"))
+		_ = c.Error(err)
+		return
+	}
+	if !seen {
 		return
 	}
 
-	notes /* messages, */, err := app.data.UserAll(c, user)
+	if err := app.limit.SMS(c, clientip.Get(c), user.Phone(), user.ID()); err != nil {
+		_ = c.Error(err)
+		return
+	}
+
+	msg := fmt.Sprintf(`New sign in to your smscp account from %s.
+
+If this wasn't you, use "forgot password" at %s to reset your password.`, clientip.Get(c), app.cfg.baseURL)
+
+	if err := app.text(c, user, msg); err != nil {
+		_ = c.Error(errors.Wrap(err, "failed to send new device alert"))
+	}
+}
+
+// noteCreate stores and texts a note, counting it for channel. Encrypted notes
+// must already be sealed by the client (see pkg/e2e); the sms only says one
+// is waiting.
+func (app App) noteCreate(c *gin.Context, channel string, user common.User, text string, opts common.NoteOptions) (common.Note, error) {
+	if opts.Encrypted && !e2e.Valid(text) {
+		return nil, invalid(errors.New("encrypted note must be sealed by the client"))
+	}
+
+	if err := app.limit.SMS(c, clientip.Get(c), user.Phone(), user.ID()); err != nil {
+		return nil, err
+	}
+
+	note, err := app.data.NoteCreate(c, user, text, opts)
 	if err != nil {
-		app.error(c, errors.Wrap(err, "failed to retrieve user data"))
+		return nil, err
+	}
+	app.stats.NoteCreated(channel, 1)
+
+	msg := text
+	if opts.Encrypted {
+		msg = fmt.Sprintf("You have an encrypted note. Run `smscp latest` or open %s to decrypt it.", app.cfg.baseURL)
+	}
+
+	if err := app.text(c, user, msg); err != nil {
+		return nil, err
+	}
+
+	app.publish(c, bus.NoteCreated, user, note.ID())
+
+	return note, nil
+}
+
+// burn deletes a burn after read note once it has been fetched. Callers only
+// hand the note back if this worked; when two fetch it at once only the one
+// whose delete lands gets it, the other is told it's already burned.
+func (app App) burn(c *gin.Context, user common.User, note common.Note) error {
+	if note == nil || !note.Burn() {
+		return nil
+	}
+
+	if err := app.data.NoteDel(c, note); err != nil {
+		if status, _ := classify(err); status == http.StatusNotFound {
+			return notFound(errors.New("note already burned"))
+		}
+		return errors.Wrap(err, "failed to burn note")
+	}
+
+	app.publish(c, bus.NoteDeleted, user, note.ID())
+	return nil
+}
+
+// parseTTL reads a note TTL like 10m or 24h; empty means none.
+func parseTTL(raw string) (time.Duration, error) {
+	if raw == "" {
+		return 0, nil
+	}
+
+	ttl, err := time.ParseDuration(raw)
+	if err != nil {
+		return 0, invalid(errors.New("invalid ttl; try 10m or 24h"))
+	}
+
+	return ttl, checkTTL(ttl)
+}
+
+func checkTTL(ttl time.Duration) error {
+	if ttl < minNoteTTL || ttl > maxNoteTTL {
+		return invalid(fmt.Errorf("invalid ttl; must be between %s and %s", minNoteTTL, maxNoteTTL))
+	}
+	return nil
+}
+
+// smsOptions strips directives from the start of a texted note, so
+// "!ttl 10m !burn 123456" keeps 123456 for ten minutes or until read.
+func smsOptions(text string) (string, common.NoteOptions, error) {
+	var (
+		opts common.NoteOptions
+		rest = text
+	)
+	for {
+		word, after := nextWord(rest)
+		switch strings.ToLower(word) {
+		case "!burn":
+			opts.Burn = true
+		case "!ttl":
+			raw, afterTTL := nextWord(after)
+			ttl, err := parseTTL(raw)
+			if err != nil {
+				return "", opts, err
+			}
+			if ttl == 0 {
+				return "", opts, invalid(errors.New("invalid ttl; try !ttl 10m"))
+			}
+			opts.TTL, after = ttl, afterTTL
+		default:
+			if rest == text {
+				return text, opts, nil /* No directives, keep it verbatim. */
+			}
+			rest = strings.TrimLeftFunc(rest, unicode.IsSpace)
+			if rest == "" {
+				return "", opts, invalid(errors.New("note is empty"))
+			}
+			return rest, opts, nil
+		}
+		rest = after
+	}
+}
+
+// nextWord splits off the first word of text, ignoring leading white space.
+func nextWord(text string) (string, string) {
+	text = strings.TrimLeftFunc(text, unicode.IsSpace)
+	if i := strings.IndexFunc(text, unicode.IsSpace); i >= 0 {
+		return text[:i], text[i:]
+	}
+	return text, ""
+}
+
+// parseRetention reads the retention choice from the account settings form.
+func parseRetention(kind string, keep int) (common.Retention, error) {
+	switch kind {
+	case "forever":
+		return common.Retention{}, nil
+	case "30d":
+		return common.Retention{Days: 30}, nil
+	case "7d":
+		return common.Retention{Days: 7}, nil
+	case "last":
+		if keep < 1 {
+			return common.Retention{}, invalid(errors.New("invalid retention; keep at least 1 note"))
+		}
+		return common.Retention{Keep: keep}, nil
+	}
+	return common.Retention{}, invalid(errors.New("invalid retention; want forever, 30d, 7d or last"))
+}
+
+func parsePhone(raw string) (string, error) {
+	phone, err := libphonenumber.Parse(raw, "US")
+	if err != nil {
+		return "", invalid(errors.Wrap(err, "must be US number"))
+	} else if !libphonenumber.IsValidNumber(phone) {
+		return "", invalid(errors.New("invalid phone number; try again"))
+	}
+	return fmt.Sprintf("%d%d", phone.GetCountryCode(), phone.GetNationalNumber()), nil
+}
+
+// text sends msg to user, counting it towards their sms usage (see /admin).
+// Like publish, counting is best effort; the text has already gone.
+func (app App) text(c *gin.Context, user common.User, msg string) error {
+	if err := app.sms.Send(c, user.Phone(), msg); err != nil {
+		return err
+	}
+	if err := app.data.UserSMSSent(c, user, app.cfg.now()); err != nil {
+		_ = c.Error(errors.Wrap(err, "failed to count sms sent"))
+	}
+	return nil
+}
+
+// audit records typ against user, from the request's IP and user agent. Like
+// publish it is best effort; what is audited has already happened.
+func (app App) audit(c *gin.Context, typ common.AuditType, user common.User, detail string) {
+	app.record(c, common.AuditEvent{Type: typ, UserID: user.ID(), Username: user.Username(), Detail: detail})
+}
+
+func (app App) record(c *gin.Context, event common.AuditEvent) {
+	event.IP, event.UserAgent, event.At = clientip.Get(c), c.Request.UserAgent(), app.cfg.now().UTC()
+	if err := app.data.AuditAppend(c, event); err != nil {
+		_ = c.Error(errors.Wrap(err, "failed to record audit event"))
+	}
+}
+
+// publish is best effort; the note has already been stored and sent by the
+// time we get here, so a bus failure is only recorded against the request.
+func (app App) publish(c *gin.Context, typ bus.EventType, user common.User, noteID string) {
+	err := app.bus.Publish(c, bus.Event{Type: typ, UserID: user.ID(), NoteID: noteID})
+	if err != nil {
+		_ = c.Error(errors.Wrap(err, "failed to publish note event"))
+	}
+}
+
+// UserExportAllData serves everything stored for the user as ?format=zip (the
+// default), json or csv; see internal/export.
+func (app App) UserExportAllData(c *gin.Context) {
+	user, err := app.currentUser(c)
+	if err != nil {
+		app.error(c, unauthorized(errors.New("no user")))
 		return
 	}
 
-	file, err := app.csv.ToFile(user, notes)
+	format := c.DefaultQuery("format", "zip")
+	contentType, err := app.export.ContentType(format)
 	if err != nil {
-		app.error(c, errors.Wrap(err, "failed to generate csv file export"))
+		app.error(c, invalid(err))
 		return
 	}
 
-	byt, err := ioutil.ReadFile(file.Name())
+	notes /* messages, */, err := app.data.UserAll(c, user)
 	if err != nil {
-		app.error(c, errors.Wrap(err, "failed to read csv file export"))
+		app.error(c, errors.Wrap(err, "failed to retrieve user data"))
 		return
 	}
-	defer os.Remove(file.Name()) // cleanup file
 
-	filename := fmt.Sprintf("%s_user_data.csv", url.QueryEscape(user.Username()))
-	c.Header("Content-Type", "text/csv")
+	app.audit(c, common.AuditExported, user, format)
+
+	// Streamed, so once the body starts a failure can only be recorded.
+	filename := fmt.Sprintf("%s_user_data.%s", url.QueryEscape(user.Username()), format)
+	c.Header("Content-Type", contentType)
 	c.Header("Content-Disposition", fmt.Sprintf("attachment; filename=%s", filename))
-	c.String(http.StatusOK, string(byt))
+	c.Status(http.StatusOK)
+	if err := app.export.Write(c.Writer, format, user, notes); err != nil {
+		_ = c.Error(errors.Wrap(err, "failed to write export"))
+	}
 }
 
+// UserDeleteAllData schedules the account for deletion after a grace period,
+// once the password is given again, and logs out. Logging back in before then
+// cancels it; see UserPurgeDeleted for the deletion itself.
 func (app App) UserDeleteAllData(c *gin.Context) {
+	var payload deleteAccountForm
+	if err := c.ShouldBind(&payload); err != nil {
+		app.error(c, invalid(err))
+		return
+	}
+
 	user, err := app.currentUser(c)
 	if err != nil {
-		app.error(c, errors.New("no user"))
+		app.error(c, unauthorized(errors.New("no user")))
 		return
 	}
 
-	if err := app.data.UserDel(c, user); err != nil {
-		app.error(c, errors.Wrap(err, "failed to delete user data"))
+	if err := app.limit.Login(c, clientip.Get(c), user.Username()); err != nil {
+		app.error(c, err)
+		return
+	}
+	if _, err := app.data.UserLogin(c, user.Username(), payload.Password); err != nil {
+		app.loginFailed(c, "delete", user.Username(), err)
+		app.error(c, err)
+		return
+	}
+
+	at := app.cfg.now().UTC().Add(deleteGracePeriod)
+	user.SetDeleteAt(at)
+	if err := user.Save(c); err != nil {
+		app.error(c, errors.Wrap(err, "failed to schedule deletion"))
+		return
+	}
+	app.audit(c, common.AuditDeleteRequested, user, at.Format(time.RFC3339))
+
+	msg := fmt.Sprintf(`Your smscp account and notes will be deleted on %s.
+
+Log in at %s before then to keep them.`, at.Format("Jan 2, 2006"), app.cfg.baseURL)
+	if err := app.text(c, user, msg); err != nil {
+		_ = c.Error(errors.Wrap(err, "failed to send deletion notice"))
+	}
+
+	s := sessions.Default(c)
+	s.Clear()
+	s.Options(sessions.Options{Path: "/", MaxAge: -1})
+	if err := s.Save(); err != nil {
+		app.error(c, err)
 		return
 	}
 
 	c.Redirect(http.StatusTemporaryRedirect, "/")
 }
 
+// UserPurgeDeleted deletes accounts whose grace period has ended by now and
+// texts each one to confirm, returning how many were deleted. It carries on
+// past a failure, which the next run retries.
+func (app App) UserPurgeDeleted(ctx context.Context, now time.Time) (int, error) {
+	users, err := app.data.UserGetDueForDeletion(ctx, now)
+	if err != nil {
+		return 0, err
+	}
+
+	var (
+		deleted = 0
+		failed  error
+	)
+	for _, user := range users {
+		if err := app.data.UserDel(ctx, user); err != nil {
+			if failed == nil {
+				failed = errors.Wrapf(err, "failed to delete user %s", user.ID())
+			}
+			continue
+		}
+		deleted++
+
+		// There's no request; the server did it.
+		event := common.AuditEvent{Type: common.AuditDeleted, UserID: user.ID(), Username: user.Username(), At: now.UTC()}
+		if err := app.data.AuditAppend(ctx, event); err != nil && failed == nil {
+			failed = errors.Wrapf(err, "failed to audit deletion of user %s", user.ID())
+		}
+
+		msg := "Your smscp account and all of its notes have been deleted."
+		if err := app.sms.Send(ctx, user.Phone(), msg); err != nil && failed == nil {
+			failed = errors.Wrapf(err, "failed to confirm deletion of user %s", user.ID())
+		}
+	}
+
+	return deleted, failed
+}
+
+// restore cancels a requested deletion when the user signs in again.
+func (app App) restore(c *gin.Context, user common.User) error {
+	if user.DeleteAt().IsZero() {
+		return nil
+	}
+
+	user.SetDeleteAt(time.Time{})
+	if err := user.Save(c); err != nil {
+		return errors.Wrap(err, "failed to cancel deletion")
+	}
+	app.audit(c, common.AuditDeleteCancelled, user, "")
+
+	if err := app.text(c, user, "Welcome back; your smscp account is no longer going to be deleted."); err != nil {
+		_ = c.Error(errors.Wrap(err, "failed to send deletion cancelled notice"))
+	}
+	return nil
+}
+
 func (app App) UserForgotPassword(c *gin.Context) {
-	var payload struct{ Username string }
-	if err := c.Bind(&payload); err != nil {
-		app.error(c, errors.Wrap(err, "failed to retreive username provided"))
+	var payload forgotPasswordForm
+	if err := c.ShouldBind(&payload); err != nil {
+		app.error(c, invalid(errors.Wrap(err, "failed to retreive username provided")))
 		return
 	}
 
@@ -544,9 +993,24 @@ func (app App) UserForgotPassword(c *gin.Context) {
 		return
 	}
 
-	token, err := app.sec.TokenCreate(jwt.MapClaims{
-		"UserToken": user.Token(),
-		"Time":      time.Now().UTC().Format(time.UnixDate),
+	if err := app.limit.SMS(c, clientip.Get(c), user.Phone(), user.ID()); err != nil {
+		app.error(c, err)
+		return
+	}
+
+	nonce, err := app.data.UserResetCreate(c, user)
+	if err != nil {
+		app.error(c, errors.Wrap(err, "failed to create magic link"))
+		return
+	}
+
+	now := app.cfg.now().UTC()
+	token, err := app.sec.TokenCreate(c, jwt.MapClaims{
+		"sub":   user.ID(),
+		"aud":   resetAudience,
+		"nonce": nonce,
+		"iat":   now.Unix(),
+		"exp":   now.Add(resetLinkTTL).Unix(),
 	})
 	if err != nil {
 		app.error(c, errors.Wrap(err, "failed to create magic link"))
@@ -557,83 +1021,73 @@ func (app App) UserForgotPassword(c *gin.Context) {
 
 `
 
-	err = app.sms.Send(user.Phone(), msg+fmt.Sprintf(app.cfg.resetPassswordLink, token))
+	err = app.text(c, user, msg+app.cfg.baseURL+"/reset/"+token)
 	if err != nil {
 		app.error(c, errors.Wrap(err, "failed to send sms"))
 		return
 	}
+	app.audit(c, common.AuditPasswordResetSent, user, "")
 
 	c.Redirect(http.StatusTemporaryRedirect, "/")
 }
 
 func (app App) PageForgotPassword(c *gin.Context) {
-	c.HTML(http.StatusOK, "forgot-password.html", gin.H{"HasUser": false})
+	c.HTML(http.StatusOK, "forgot-password.html", gin.H{
+		"HasUser": false,
+		"CSRF":    csrfToken(c),
+	})
 }
 
 func (app App) UserForgotPasswordNewPassword(c *gin.Context) {
-	var payload struct{ Password, Verify string }
-	if err := c.Bind(&payload); err != nil {
-		app.error(c, errors.Wrap(err, "failed to retreive form payload"))
+	var payload newPasswordForm
+	if err := c.ShouldBind(&payload); err != nil {
+		app.error(c, invalid(errors.Wrap(err, "failed to retreive form payload")))
 		return
 	}
 
 	if payload.Password != payload.Verify || payload.Password == "" {
-		app.error(c, errors.New("invalid password; either not equal or no password entered"))
+		app.error(c, invalid(errors.New("invalid password; either not equal or no password entered")))
 		return
 	}
 
-	data, err := app.sec.TokenFrom(c.Param("hash"))
+	// TokenFrom rejects the link once past its exp claim.
+	claims, err := app.sec.TokenFrom(c, c.Param("hash"))
 	if err != nil {
-		app.error(c, errors.Wrap(err, "could not read magic link"))
+		app.error(c, invalid(errors.Wrap(err, "could not read magic link")))
 		return
 	}
 
-	timeData, ok := data["Time"]
-	if !ok {
-		app.error(c, errors.New("could not read magic link; no time value"))
+	if !claims.VerifyAudience(resetAudience, true) {
+		app.error(c, invalid(errors.New("could not read magic link; not a reset link")))
 		return
 	}
 
-	timeStr, ok := timeData.(string)
+	id, ok := claims["sub"].(string)
 	if !ok {
-		app.error(c, errors.New("could not read magic link; invalid time type"))
+		app.error(c, invalid(errors.New("could not read magic link; no user")))
 		return
 	}
 
-	t, err := time.Parse(time.UnixDate, timeStr)
-	if err != nil {
-		app.error(c, errors.Wrap(err, "could not read magic link; invalid time value"))
-		return
-	}
-
-	if t.Add(5 * time.Minute).Before(time.Now().UTC()) {
-		app.error(c, errors.New("this link has expired; may not reset password here"))
-		return
-	}
-
-	tokenData, ok := data["UserToken"]
+	nonce, ok := claims["nonce"].(string)
 	if !ok {
-		app.error(c, errors.New("could not read magic link; no user token"))
+		app.error(c, invalid(errors.New("could not read magic link; no nonce")))
 		return
 	}
 
-	tokenStr, ok := tokenData.(string)
-	if !ok {
-		app.error(c, errors.New("could not read magic link; invalid user token"))
-		return
-	}
-
-	user, err := app.currentUserFromToken(c, tokenStr)
+	user, err := app.data.UserResetRedeem(c, id, nonce)
 	if err != nil {
-		app.error(c, errors.Wrap(err, "this token does not represent a user; broken token"))
+		app.error(c, err)
 		return
 	}
 
 	user.SetPass(payload.Password)
+	user.Unlock()
+	user.SetDeleteAt(time.Time{}) /* Proving the phone is as good as logging in. */
 	if err := user.Save(c); err != nil {
 		app.error(c, errors.Wrap(err, "failed to update password"))
 		return
 	}
+	app.audit(c, common.AuditPasswordReset, user, "")
 
 	s := sessions.Default(c)
 	s.Set(sessionKeyUserToken, user.Token())
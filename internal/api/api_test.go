@@ -0,0 +1,261 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"sort"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gin-contrib/sessions"
+	"github.com/gin-contrib/sessions/cookie"
+	"github.com/gin-gonic/gin"
+	"github.com/pkg/errors"
+	"gopkg.in/go-playground/assert.v1"
+	"smscp.xyz/internal/bus/memory"
+	"smscp.xyz/internal/common"
+	"smscp.xyz/internal/ratelimit"
+	ratememory "smscp.xyz/internal/ratelimit/memory"
+	"smscp.xyz/internal/security"
+)
+
+// testWebRouter serves login and the cookie authenticated routes that routes
+// registers, returning a helper that sends requests as alice.
+func testWebRouter(t *testing.T, data *fakeData, sms *fakeSMS, routes func(App, *gin.Engine)) func(method, path, body string) *httptest.ResponseRecorder {
+	app := testApp(data, sms, ratelimit.DefaultLimits())
+
+	router := gin.New()
+	router.Use(sessions.Sessions("test", cookie.NewStore([]byte("secret"))))
+	router.POST("/user/login", app.UserLogin)
+	routes(app, router)
+
+	var cookies []*http.Cookie
+	send := func(method, path, body string) *httptest.ResponseRecorder {
+		req, _ := http.NewRequest(method, path, strings.NewReader(body))
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		req.Header.Set("Accept", "application/json")
+		for _, cookie := range cookies {
+			req.AddCookie(cookie)
+		}
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+		return w
+	}
+
+	w := send("POST", "/user/login", "Username=alice&Password=pass")
+	assert.Equal(t, http.StatusTemporaryRedirect, w.Code)
+	cookies = w.Result().Cookies()
+
+	return send
+}
+
+func (d *fakeData) UserResetCreate(ctx context.Context, user common.User) (string, error) {
+	nonce := "nonce-" + strconv.Itoa(len(d.nonces)) + user.ID()
+	d.nonces[user.ID()] = nonce
+	return nonce, nil
+}
+
+func (d *fakeData) UserResetRedeem(ctx context.Context, id, nonce string) (common.User, error) {
+	if d.nonces[id] == "" || d.nonces[id] != nonce {
+		return nil, invalid(errors.New("link used"))
+	}
+	delete(d.nonces, id)
+	return d.users[id], nil
+}
+
+func TestResetLinkSingleUse(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	data, sms := testData(), &fakeSMS{}
+	limit := ratelimit.Default(ratememory.Default(), ratelimit.DefaultLimits())
+	app := AppDefault(data, sms, nil, security.Default("secret"), memory.Default(), limit).WithBaseURL("http://localhost/")
+
+	router := gin.New()
+	router.Use(sessions.Sessions("test", cookie.NewStore([]byte("secret"))))
+	router.POST("/user/forgot-password", app.UserForgotPassword)
+	router.POST("/reset/:hash", app.UserForgotPasswordNewPassword)
+
+	form := func(path, body string) int {
+		req, _ := http.NewRequest("POST", path, strings.NewReader(body))
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		req.Header.Set("Accept", "application/json")
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+		return w.Code
+	}
+	link := func() string {
+		last := sms.sent[len(sms.sent)-1]
+		return last[strings.Index(last, "http://localhost/reset/")+len("http://localhost"):]
+	}
+
+	assert.Equal(t, http.StatusTemporaryRedirect, form("/user/forgot-password", "Username=alice"))
+	first := link()
+	assert.Equal(t, false, strings.Contains(first, "token-alice"))
+
+	assert.Equal(t, http.StatusTemporaryRedirect, form("/user/forgot-password", "Username=alice"))
+	second := link()
+
+	// Superseded by the second link.
+	assert.Equal(t, http.StatusBadRequest, form(first, "Password=new&Verify=new"))
+	assert.Equal(t, http.StatusTemporaryRedirect, form(second, "Password=new&Verify=new"))
+	// Already used.
+	assert.Equal(t, http.StatusBadRequest, form(second, "Password=new&Verify=new"))
+}
+
+func TestBurnOnce(t *testing.T) {
+	data, sms := testData(), &fakeSMS{}
+	alice := data.users["alice"]
+	data.notes = []fakeNote{{id: "1", text: "123456", userID: "alice", opts: common.NoteOptions{Burn: true}}}
+	app := AppDefault(data, sms, nil, nil, memory.Default(), nil)
+
+	// Two requests read the note before either burns it.
+	first, _ := data.NoteGet(context.Background(), alice, "1")
+	second, _ := data.NoteGet(context.Background(), alice, "1")
+
+	c, _ := gin.CreateTestContext(httptest.NewRecorder())
+	assert.Equal(t, nil, app.burn(c, alice, first))
+	status, _ := classify(app.burn(c, alice, second))
+	assert.Equal(t, http.StatusNotFound, status)
+}
+
+// An open event stream ends when the app is done, so shutdown needn't wait it out.
+func TestNoteEventsDone(t *testing.T) {
+	data, sms := testData(), &fakeSMS{}
+	ctx, done := context.WithCancel(context.Background())
+	app := AppDefault(data, sms, nil, nil, memory.Default(), nil).WithDone(ctx)
+
+	router := gin.New()
+	router.Use(sessions.Sessions("test", cookie.NewStore([]byte("secret"))))
+	router.GET("/note/events", func(c *gin.Context) {
+		sessions.Default(c).Set(sessionKeyUserToken, "token-alice")
+		app.NoteEvents(c)
+	})
+
+	srv := httptest.NewServer(router)
+	defer srv.Close()
+
+	// Headers only go out with the first event, so the get blocks too.
+	ended := make(chan struct{})
+	go func() {
+		if res, err := http.Get(srv.URL + "/note/events"); err == nil {
+			_, _ = io.Copy(ioutil.Discard, res.Body)
+			res.Body.Close()
+		}
+		close(ended)
+	}()
+
+	done()
+	select {
+	case <-ended:
+	case <-time.After(time.Second):
+		t.Fatal("event stream still open after the app was done")
+	}
+}
+
+func TestSMSOptions(t *testing.T) {
+	for _, tt := range []struct {
+		in, text string
+		opts     common.NoteOptions
+		ok       bool
+	}{
+		{"hello", "hello", common.NoteOptions{}, true},
+		{"  !not a directive", "  !not a directive", common.NoteOptions{}, true},
+		{"!burn 123456", "123456", common.NoteOptions{Burn: true}, true},
+		{"!TTL 10m\n!burn\n123 456", "123 456", common.NoteOptions{TTL: 10 * time.Minute, Burn: true}, true},
+		{"!ttl 10m", "", common.NoteOptions{}, false},
+		{"!ttl soon 123", "", common.NoteOptions{}, false},
+		{"!ttl 1s 123", "", common.NoteOptions{}, false},
+	} {
+		text, opts, err := smsOptions(tt.in)
+		assert.Equal(t, tt.ok, err == nil)
+		if tt.ok {
+			assert.Equal(t, tt.text, text)
+			assert.Equal(t, tt.opts, opts)
+		}
+	}
+}
+
+// The infinite scroll gets resources, never the stored user with its hashes,
+// nonces and login history.
+func TestNoteListJSON(t *testing.T) {
+	data := testData()
+	data.notes = []fakeNote{{id: "1", text: "hello", userID: "alice"}}
+	send := testWebRouter(t, data, &fakeSMS{}, func(app App, router *gin.Engine) {
+		router.GET("/note/list/:page", app.NoteListJSON)
+	})
+
+	w := send("GET", "/note/list/0", "")
+	assert.Equal(t, http.StatusOK, w.Code)
+
+	var res map[string]json.RawMessage
+	assert.Equal(t, nil, json.Unmarshal(w.Body.Bytes(), &res))
+	assert.Equal(t, []string{"HasUser", "Notes", "NotesHasMore", "User"}, keys(res))
+
+	var user map[string]json.RawMessage
+	assert.Equal(t, nil, json.Unmarshal(res["User"], &user))
+	assert.Equal(t, []string{"created_at", "id", "phone", "username"}, keys(user))
+
+	var notes []map[string]json.RawMessage
+	assert.Equal(t, nil, json.Unmarshal(res["Notes"], &notes))
+	assert.Equal(t, 1, len(notes))
+	assert.Equal(t, []string{"burn", "created_at", "encrypted", "id", "short", "text"}, keys(notes[0]))
+}
+
+func keys(m map[string]json.RawMessage) []string {
+	var out []string
+	for key := range m {
+		out = append(out, key)
+	}
+	sort.Strings(out)
+	return out
+}
+
+func TestUserUpdateRetention(t *testing.T) {
+	data := testData()
+	send := testWebRouter(t, data, &fakeSMS{}, func(app App, router *gin.Engine) {
+		router.POST("/user/update", app.UserUpdate)
+	})
+	form := func(path, body string) *httptest.ResponseRecorder { return send("POST", path, body) }
+	alice := data.users["alice"]
+
+	assert.Equal(t, http.StatusTemporaryRedirect, form("/user/update", "Retention=last&RetentionKeep=10").Code)
+	assert.Equal(t, common.Retention{Keep: 10}, alice.retention)
+
+	// Left alone when the form doesn't say.
+	assert.Equal(t, http.StatusTemporaryRedirect, form("/user/update", "Username=alice").Code)
+	assert.Equal(t, common.Retention{Keep: 10}, alice.retention)
+
+	assert.Equal(t, http.StatusTemporaryRedirect, form("/user/update", "Retention=7d&RetentionKeep=10").Code)
+	assert.Equal(t, common.Retention{Days: 7}, alice.retention)
+
+	assert.Equal(t, http.StatusBadRequest, form("/user/update", "Retention=last&RetentionKeep=0").Code)
+	assert.Equal(t, http.StatusBadRequest, form("/user/update", "Retention=1y").Code)
+	assert.Equal(t, common.Retention{Days: 7}, alice.retention)
+}
+
+func TestReadyz(t *testing.T) {
+	data, sms := testData(), &fakeSMS{}
+	app := testApp(data, sms, ratelimit.DefaultLimits())
+
+	router := gin.New()
+	router.GET("/healthz", app.Healthz)
+	router.GET("/readyz", app.Readyz)
+
+	w := do(router, "GET", "/readyz", "", nil)
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, `{"storage":"ok","sms":"ok"}`, w.Body.String())
+
+	data.down = errors.New("failed to reach firestore")
+	sms.unconfigured = errors.New("twilio not configured; missing secret")
+	w = do(router, "GET", "/readyz", "", nil)
+	assert.Equal(t, http.StatusServiceUnavailable, w.Code)
+	assert.Equal(t, `{"storage":"failed to reach firestore","sms":"twilio not configured; missing secret"}`, w.Body.String())
+
+	// Liveness doesn't depend on either.
+	assert.Equal(t, http.StatusOK, do(router, "GET", "/healthz", "", nil).Code)
+}
@@ -0,0 +1,87 @@
+package api
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/url"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/pkg/errors"
+	"smscp.xyz/internal/common"
+)
+
+// auditLabels is how the settings page describes each event.
+var auditLabels = map[common.AuditType]string{
+	common.AuditLogin:             "Logged in",
+	common.AuditLoginFailed:       "Failed login",
+	common.AuditTokenCreated:      "New cli or api token",
+	common.AuditPasswordChanged:   "Password changed",
+	common.AuditPasswordResetSent: "Password reset link sent",
+	common.AuditPasswordReset:     "Password reset",
+	common.AuditPhoneChanged:      "Phone number changed",
+	common.AuditExported:          "Data exported",
+	common.AuditDeleteRequested:   "Deletion requested",
+	common.AuditDeleteCancelled:   "Deletion cancelled",
+	common.AuditDeleted:           "Account deleted",
+	common.AuditLocked:            "Locked by an operator",
+	common.AuditUnlocked:          "Unlocked by an operator",
+	common.AuditSessionsEnded:     "Logged out everywhere by an operator",
+}
+
+type auditResource struct {
+	At        time.Time `json:"at"`
+	Type      string    `json:"type"`
+	Label     string    `json:"label"`
+	IP        string    `json:"ip"`
+	UserAgent string    `json:"user_agent"`
+	Detail    string    `json:"detail,omitempty"`
+}
+
+func toAuditResources(events []common.AuditEvent) []auditResource {
+	res := make([]auditResource, 0, len(events))
+	for _, event := range events {
+		label, ok := auditLabels[event.Type]
+		if !ok {
+			label = string(event.Type)
+		}
+		res = append(res, auditResource{event.At, string(event.Type), label, event.IP, event.UserAgent, event.Detail})
+	}
+	return res
+}
+
+// AdminAudit returns every event about the account with id, or about every
+// account when id is empty, newest first.
+func (app App) AdminAudit(ctx context.Context, id string) ([]common.AuditEvent, error) {
+	return app.data.AuditList(ctx, id, 0)
+}
+
+// AdminAuditExport downloads the audit log of ?user=, or of everyone, as
+// ?format=csv (the default) or json.
+func (app App) AdminAuditExport(c *gin.Context) {
+	id := c.Query("user")
+	format := c.DefaultQuery("format", "csv")
+	contentType, err := app.export.AuditContentType(format)
+	if err != nil {
+		app.error(c, invalid(err))
+		return
+	}
+
+	events, err := app.AdminAudit(c, id)
+	if err != nil {
+		app.error(c, errors.Wrap(err, "failed to retrieve audit log"))
+		return
+	}
+
+	name := "all"
+	if id != "" {
+		name = url.QueryEscape(id)
+	}
+	c.Header("Content-Type", contentType)
+	c.Header("Content-Disposition", fmt.Sprintf("attachment; filename=%s_audit.%s", name, format))
+	c.Status(http.StatusOK)
+	if err := app.export.WriteAudit(c.Writer, format, events); err != nil {
+		_ = c.Error(errors.Wrap(err, "failed to write audit export"))
+	}
+}
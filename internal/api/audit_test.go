@@ -0,0 +1,105 @@
+package api
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"gopkg.in/go-playground/assert.v1"
+	"smscp.xyz/internal/common"
+)
+
+func (d *fakeData) AuditList(ctx context.Context, id string, limit int) ([]common.AuditEvent, error) {
+	var events []common.AuditEvent
+	for i := len(d.audit) - 1; i >= 0 && (limit == 0 || len(events) < limit); i-- {
+		if id == "" || d.audit[i].UserID == id {
+			events = append(events, d.audit[i])
+		}
+	}
+	return events, nil
+}
+
+func TestAudit(t *testing.T) {
+	router, data, _ := testRouter()
+	send := testWebRouter(t, data, &fakeSMS{}, func(app App, router *gin.Engine) {
+		router.POST("/user/update", app.UserUpdate)
+		router.GET("/gdpr", app.UserExportAllData)
+		router.POST("/gdpr", app.UserDeleteAllData)
+		router.GET("/admin/audit", app.Admin, app.AdminAuditExport)
+	})
+	types := func() []common.AuditType {
+		var types []common.AuditType
+		for _, event := range data.audit {
+			types = append(types, event.Type)
+		}
+		data.audit = nil
+		return types
+	}
+
+	login := data.audit[0]
+	assert.Equal(t, common.AuditLogin, login.Type)
+	assert.Equal(t, "alice", login.UserID)
+	assert.Equal(t, "web", login.Detail)
+	assert.Equal(t, false, login.At.IsZero())
+	types()
+
+	// Failed logins are kept against the account named, when there is one.
+	do(router, "POST", "/api/v1/sessions", "", sessionCreateRequest{Username: "alice", Password: "wrong"})
+	req, _ := http.NewRequest("POST", "/api/v1/sessions", strings.NewReader(`{"username":"mallory","password":"pass"}`))
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("User-Agent", "test")
+	req.Header.Set("X-Forwarded-For", "198.51.100.9") /* Spoofed, so ignored. */
+	req.RemoteAddr = "203.0.113.7:5555"
+	router.ServeHTTP(httptest.NewRecorder(), req)
+	assert.Equal(t, "alice", data.audit[0].UserID)
+	assert.Equal(t, "", data.audit[1].UserID)
+	assert.Equal(t, "mallory", data.audit[1].Username)
+	assert.Equal(t, "203.0.113.7", data.audit[1].IP)
+	assert.Equal(t, "test", data.audit[1].UserAgent)
+	assert.Equal(t, []common.AuditType{common.AuditLoginFailed, common.AuditLoginFailed}, types())
+
+	do(router, "POST", "/api/v1/sessions", "", sessionCreateRequest{Username: "alice", Password: "pass"})
+	assert.Equal(t, []common.AuditType{common.AuditLogin, common.AuditTokenCreated}, types())
+
+	// Only a different number is a change.
+	send("POST", "/user/update", "Phone=2085550100")
+	assert.Equal(t, 0, len(types()))
+	send("POST", "/user/update", "Phone=2085550101&Password=new&Verify=new")
+	assert.Equal(t, []common.AuditType{common.AuditPasswordChanged, common.AuditPhoneChanged}, types())
+
+	send("GET", "/gdpr?format=json", "")
+	assert.Equal(t, "json", data.audit[0].Detail)
+	assert.Equal(t, []common.AuditType{common.AuditExported}, types())
+
+	send("POST", "/gdpr", "Password=pass")
+	assert.Equal(t, []common.AuditType{common.AuditDeleteRequested}, types())
+
+	// Admins export it.
+	data.audit = []common.AuditEvent{
+		{Type: common.AuditLogin, UserID: "alice", Username: "alice", At: time.Unix(0, 0)},
+		{Type: common.AuditLogin, UserID: "bob", Username: "bob", At: time.Unix(0, 0)},
+	}
+	assert.Equal(t, http.StatusForbidden, send("GET", "/admin/audit", "").Code)
+	data.users["alice"].admin = true
+	w := send("GET", "/admin/audit?user=alice", "")
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, "attachment; filename=alice_audit.csv", w.Header().Get("Content-Disposition"))
+	assert.Equal(t, "at,type,user_id,username,ip,user_agent,detail,actor\n1970-01-01T00:00:00Z,login,alice,alice,,,,\n", w.Body.String())
+	assert.Equal(t, http.StatusBadRequest, send("GET", "/admin/audit?format=zip", "").Code)
+}
+
+func TestAuditPurge(t *testing.T) {
+	data, sms := testData(), &fakeSMS{}
+	data.users["alice"].deleteAt = time.Now().Add(-time.Hour)
+	app := AppDefault(data, sms, nil, nil, nil, nil)
+
+	_, err := app.UserPurgeDeleted(context.Background(), time.Now())
+	assert.Equal(t, nil, err)
+	assert.Equal(t, 1, len(data.audit))
+	assert.Equal(t, common.AuditDeleted, data.audit[0].Type)
+	assert.Equal(t, "", data.audit[0].IP)
+}
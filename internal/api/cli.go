@@ -0,0 +1,87 @@
+package api
+
+import (
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// The deprecated /cli endpoints, kept for old clients. Each translates its
+// form into the /api/v1 request and shares the v1 path, answering in the old
+// shapes with errors as plain text.
+
+func (app App) UserLoginCLI(c *gin.Context) {
+	var payload loginForm
+	app.cli(c, &payload, func() (interface{}, error) {
+		user, err := app.sessionCreateV1(c, "cli", sessionCreateRequest{payload.Username, payload.Password})
+		if err != nil {
+			return nil, err
+		}
+		return cliTokenResponse{user.Token()}, nil
+	})
+}
+
+func (app App) UserCreateCLI(c *gin.Context) {
+	var payload userForm
+	app.cli(c, &payload, func() (interface{}, error) {
+		user, err := app.userCreate(c, "cli", payload.Username, payload.Password, payload.Verify, payload.Phone)
+		if err != nil {
+			return nil, err
+		}
+		return cliTokenResponse{user.Token()}, nil
+	})
+}
+
+func (app App) NoteCreateCLI(c *gin.Context) {
+	var payload noteCLIForm
+	app.cli(c, &payload, func() (interface{}, error) {
+		user, err := app.authV1(c, payload.Token)
+		if err != nil {
+			return nil, err
+		}
+
+		ttl, err := parseTTL(payload.TTL)
+		if err != nil {
+			return nil, err
+		}
+
+		req := noteCreateRequest{Text: payload.Text, TTLSeconds: int(ttl / time.Second), Burn: payload.Burn}
+		if _, err := app.noteCreateV1(c, "cli", user, req); err != nil {
+			return nil, err
+		}
+		return cliMessageResponse{"complete"}, nil
+	})
+}
+
+func (app App) NoteLatestCLI(c *gin.Context) {
+	var payload tokenCLIForm
+	app.cli(c, &payload, func() (interface{}, error) {
+		user, err := app.authV1(c, payload.Token)
+		if err != nil {
+			return nil, err
+		}
+
+		note, err := app.noteGetV1(c, user, "latest")
+		if err != nil {
+			return nil, err
+		}
+		return cliLatestResponse{"complete", note}, nil
+	})
+}
+
+// cli binds the form into payload, then answers with what do returns.
+func (app App) cli(c *gin.Context, payload interface{}, do func() (interface{}, error)) {
+	if err := c.ShouldBind(payload); err != nil {
+		app.errorCLI(c, invalid(err))
+		return
+	}
+
+	res, err := do()
+	if err != nil {
+		app.errorCLI(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, res)
+}
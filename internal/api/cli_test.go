@@ -0,0 +1,88 @@
+package api
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"gopkg.in/go-playground/assert.v1"
+	"smscp.xyz/internal/common"
+	"smscp.xyz/internal/ratelimit"
+)
+
+func testCLIRouter() (*gin.Engine, *fakeData, *fakeSMS) {
+	data, sms := testData(), &fakeSMS{}
+	app := testApp(data, sms, ratelimit.DefaultLimits())
+
+	router := gin.New()
+	router.POST("/cli/user/login", app.UserLoginCLI)
+	router.POST("/cli/note/create", app.NoteCreateCLI)
+	router.POST("/cli/note/latest", app.NoteLatestCLI)
+	return router, data, sms
+}
+
+func postForm(router http.Handler, path string, form url.Values) *httptest.ResponseRecorder {
+	req, _ := http.NewRequest("POST", path, strings.NewReader(form.Encode()))
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+	return w
+}
+
+func TestCLILogin(t *testing.T) {
+	router, _, _ := testCLIRouter()
+
+	w := postForm(router, "/cli/user/login", url.Values{"Username": {"alice"}, "Password": {"pass"}})
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, `{"Token":"token-alice"}`, w.Body.String())
+
+	w = postForm(router, "/cli/user/login", url.Values{"Username": {"alice"}, "Password": {"wrong"}})
+	assert.Equal(t, http.StatusUnauthorized, w.Code)
+	assert.Equal(t, "invalid username or password", w.Body.String())
+}
+
+func TestCLINoteCreate(t *testing.T) {
+	router, data, sms := testCLIRouter()
+
+	w := postForm(router, "/cli/note/create", url.Values{"Token": {"token-alice"}, "Text": {"hello"}, "TTL": {"10m"}, "Burn": {"true"}})
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, `{"Message":"complete"}`, w.Body.String())
+	assert.Equal(t, 1, len(data.notes))
+	assert.Equal(t, common.NoteOptions{TTL: 10 * time.Minute, Burn: true}, data.notes[0].opts)
+	assert.Equal(t, 1, len(sms.sent))
+
+	for _, c := range []struct {
+		form   url.Values
+		status int
+	}{
+		{url.Values{"Token": {"token-alice"}, "Text": {" "}}, http.StatusBadRequest},
+		{url.Values{"Token": {"token-alice"}, "Text": {"hello"}, "TTL": {"1s"}}, http.StatusBadRequest},
+		{url.Values{"Token": {"token-mallory"}, "Text": {"hello"}}, http.StatusUnauthorized},
+	} {
+		assert.Equal(t, c.status, postForm(router, "/cli/note/create", c.form).Code)
+	}
+	assert.Equal(t, 1, len(data.notes))
+
+	data.locked["alice"] = true
+	assert.Equal(t, http.StatusForbidden, postForm(router, "/cli/note/create", url.Values{"Token": {"token-alice"}, "Text": {"hello"}}).Code)
+}
+
+func TestCLINoteLatest(t *testing.T) {
+	router, data, _ := testCLIRouter()
+	data.notes = []fakeNote{{id: "0", text: "read me once", userID: "alice", opts: common.NoteOptions{Burn: true}}}
+
+	w := postForm(router, "/cli/note/latest", url.Values{"Token": {"token-alice"}})
+	assert.Equal(t, http.StatusOK, w.Code)
+
+	var res struct{ Message string }
+	assert.Equal(t, nil, json.Unmarshal(w.Body.Bytes(), &res))
+	assert.Equal(t, "complete", res.Message)
+	assert.Equal(t, 0, len(data.notes))
+
+	assert.Equal(t, http.StatusNotFound, postForm(router, "/cli/note/latest", url.Values{"Token": {"token-alice"}}).Code)
+}
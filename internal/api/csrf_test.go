@@ -0,0 +1,52 @@
+package api
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/gin-contrib/sessions"
+	"github.com/gin-contrib/sessions/cookie"
+	"github.com/gin-gonic/gin"
+	"gopkg.in/go-playground/assert.v1"
+)
+
+func TestCSRF(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	app := AppDefault(nil, nil, nil, nil, nil, nil)
+
+	router := gin.New()
+	router.Use(sessions.Sessions("test", cookie.NewStore([]byte("secret"))))
+	router.GET("/", app.CSRF, func(c *gin.Context) { c.String(http.StatusOK, csrfToken(c)) })
+	router.POST("/", app.CSRF, func(c *gin.Context) { c.Status(http.StatusNoContent) })
+
+	post := func(cookies []*http.Cookie, form, header string) int {
+		req, _ := http.NewRequest("POST", "/", strings.NewReader(form))
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		req.Header.Set("Accept", "application/json")
+		if header != "" {
+			req.Header.Set("X-CSRF-Token", header)
+		}
+		for _, cookie := range cookies {
+			req.AddCookie(cookie)
+		}
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+		return w.Code
+	}
+
+	// No session yet, so nothing can match.
+	assert.Equal(t, http.StatusForbidden, post(nil, "_csrf=anything", ""))
+
+	w := httptest.NewRecorder()
+	req, _ := http.NewRequest("GET", "/", nil)
+	router.ServeHTTP(w, req)
+	token, cookies := w.Body.String(), w.Result().Cookies()
+	assert.NotEqual(t, "", token)
+
+	assert.Equal(t, http.StatusForbidden, post(cookies, "", ""))
+	assert.Equal(t, http.StatusForbidden, post(cookies, "_csrf=wrong", ""))
+	assert.Equal(t, http.StatusNoContent, post(cookies, "_csrf="+token, ""))
+	assert.Equal(t, http.StatusNoContent, post(cookies, "", token))
+}
@@ -0,0 +1,119 @@
+package api
+
+import (
+	"math"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/pkg/errors"
+)
+
+// Domain errors are recognised by behaviour (see classify) so the data layer
+// can report the same kinds without importing this package.
+
+type invalidError struct{ error }
+
+func (invalidError) Invalid() bool { return true }
+
+type unauthorizedError struct{ error }
+
+func (unauthorizedError) Unauthorized() bool { return true }
+
+type forbiddenError struct{ error }
+
+func (forbiddenError) Forbidden() bool { return true }
+
+type notFoundError struct{ error }
+
+func (notFoundError) NotFound() bool { return true }
+
+type conflictError struct{ error }
+
+func (conflictError) Conflict() bool { return true }
+
+func invalid(err error) error      { return invalidError{err} }
+func unauthorized(err error) error { return unauthorizedError{err} }
+func forbidden(err error) error    { return forbiddenError{err} }
+func notFound(err error) error     { return notFoundError{err} }
+func conflict(err error) error     { return conflictError{err} }
+
+// classify maps err, or what it wraps, to a status and a stable error code.
+func classify(err error) (int, string) {
+	switch cause := errors.Cause(err).(type) {
+	case interface{ Invalid() bool }:
+		if cause.Invalid() {
+			return http.StatusBadRequest, "invalid_request"
+		}
+	case interface{ Unauthorized() bool }:
+		if cause.Unauthorized() {
+			return http.StatusUnauthorized, "unauthorized"
+		}
+	case interface{ Forbidden() bool }:
+		if cause.Forbidden() {
+			return http.StatusForbidden, "forbidden"
+		}
+	case interface{ NotFound() bool }:
+		if cause.NotFound() {
+			return http.StatusNotFound, "not_found"
+		}
+	case interface{ Conflict() bool }:
+		if cause.Conflict() {
+			return http.StatusConflict, "conflict"
+		}
+	case interface{ Locked() bool }:
+		if cause.Locked() {
+			return http.StatusForbidden, "locked"
+		}
+	case interface{ RateLimited() bool }:
+		if cause.RateLimited() {
+			return http.StatusTooManyRequests, "rate_limited"
+		}
+	}
+	return http.StatusInternalServerError, "internal"
+}
+
+type errorResource struct {
+	Error errorBody `json:"error"`
+}
+
+type errorBody struct {
+	Code    string `json:"code"`
+	Message string `json:"message"`
+}
+
+// respond classifies err and sets any headers its status calls for. It records
+// err on c for the request log.
+func respond(c *gin.Context, err error) (int, string) {
+	_ = c.Error(err)
+	if cause, ok := errors.Cause(err).(interface{ RetryAfter() time.Duration }); ok {
+		c.Header("Retry-After", strconv.Itoa(int(math.Ceil(cause.RetryAfter().Seconds()))))
+	}
+	return classify(err)
+}
+
+// error renders err as the error page or, when the client asks for it (i.e.
+// the infinite scroll's fetch), as JSON.
+func (app App) error(c *gin.Context, err error) {
+	status, code := respond(c, err)
+	if c.NegotiateFormat(gin.MIMEHTML, gin.MIMEJSON) == gin.MIMEJSON {
+		c.AbortWithStatusJSON(status, errorResource{errorBody{code, err.Error()}})
+		return
+	}
+	c.HTML(status, "error.html", gin.H{
+		"Error": err.Error(),
+	})
+	c.Abort()
+}
+
+func (app App) errorCLI(c *gin.Context, err error) {
+	status, _ := respond(c, err)
+	c.String(status, err.Error())
+	c.Abort()
+}
+
+func (app App) errorV1(c *gin.Context, err error) {
+	status, code := respond(c, err)
+	c.AbortWithStatusJSON(status, errorResource{errorBody{code, err.Error()}})
+}
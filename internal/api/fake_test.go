@@ -0,0 +1,224 @@
+package api
+
+import (
+	"context"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/pkg/errors"
+	"smscp.xyz/internal/bus/memory"
+	"smscp.xyz/internal/common"
+	"smscp.xyz/internal/export"
+	"smscp.xyz/internal/ratelimit"
+	ratememory "smscp.xyz/internal/ratelimit/memory"
+)
+
+// fakes; embedding the interfaces means anything not overridden panics. The
+// methods only one area uses are in its test file, i.e. admin_test.go.
+
+type fakeUser struct {
+	id, username, phone string
+	retention           common.Retention
+	deleteAt            time.Time
+	admin               bool
+}
+
+func (u *fakeUser) ID() string                      { return u.id }
+func (u *fakeUser) Username() string                { return u.username }
+func (u *fakeUser) Phone() string                   { return u.phone }
+func (u *fakeUser) Token() string                   { return "token-" + u.id }
+func (u *fakeUser) CreatedAt() time.Time            { return time.Unix(0, 0).UTC() }
+func (u *fakeUser) Admin() bool                     { return u.admin }
+func (u *fakeUser) SetUsername(v string)            { u.username = v }
+func (u *fakeUser) SetPass(string)                  {}
+func (u *fakeUser) SetPhone(v string)               { u.phone = v }
+func (u *fakeUser) Unlock()                         {}
+func (u *fakeUser) Retention() common.Retention     { return u.retention }
+func (u *fakeUser) SetRetention(v common.Retention) { u.retention = v }
+func (u *fakeUser) DeleteAt() time.Time             { return u.deleteAt }
+func (u *fakeUser) SetDeleteAt(v time.Time)         { u.deleteAt = v }
+func (u *fakeUser) Save(context.Context) error      { return nil }
+
+type fakeNote struct {
+	id, text, userID string
+	opts             common.NoteOptions
+}
+
+func (n fakeNote) ID() string           { return n.id }
+func (n fakeNote) Short() string        { return n.text }
+func (n fakeNote) Text() string         { return n.text }
+func (n fakeNote) Token() string        { return "token-" + n.id }
+func (n fakeNote) CreatedAt() time.Time { return time.Unix(0, 0).UTC() }
+func (n fakeNote) Encrypted() bool      { return n.opts.Encrypted }
+func (n fakeNote) Burn() bool           { return n.opts.Burn }
+
+func (n fakeNote) ExpiresAt() time.Time {
+	if n.opts.TTL == 0 {
+		return time.Time{}
+	}
+	return n.CreatedAt().Add(n.opts.TTL)
+}
+
+type lockedError struct{ error }
+
+func (lockedError) Locked() bool { return true }
+
+type fakeData struct {
+	dataLayer
+	users  map[string]*fakeUser
+	notes  []fakeNote
+	locked map[string]bool
+	seen   map[string]bool
+	nonces map[string]string
+	ended  map[string]bool /* Users whose sessions were ended. */
+	sms    map[string]int  /* Texts sent per user. */
+	audit  []common.AuditEvent
+	down   error /* Returned by Ping. */
+}
+
+func (d *fakeData) Ping(ctx context.Context) error { return d.down }
+
+func (d *fakeData) UserGet(ctx context.Context, token string) (common.User, error) {
+	if d.down != nil {
+		return nil, d.down
+	}
+	for _, user := range d.users {
+		if user.Token() == token {
+			if d.ended[user.id] {
+				return nil, unauthorized(errors.New("session ended"))
+			}
+			if d.locked[user.username] {
+				return nil, lockedError{errors.New("account locked")}
+			}
+			return user, nil
+		}
+	}
+	return nil, unauthorized(errors.New("invalid token"))
+}
+
+func (d *fakeData) UserLogin(ctx context.Context, username, pass string) (common.User, error) {
+	if d.locked[username] {
+		return nil, lockedError{errors.New("account locked")}
+	}
+	if user, ok := d.users[username]; ok && pass == "pass" {
+		return user, nil
+	}
+	return nil, unauthorized(errors.New("failed to login user; password hash not matched"))
+}
+
+func (d *fakeData) UserSeen(ctx context.Context, user common.User, ip, agent string) (bool, error) {
+	key := user.ID() + ip + agent
+	first := len(d.seen) == 0
+	known := d.seen[key]
+	d.seen[key] = true
+	return !first && !known, nil
+}
+
+func (d *fakeData) UserGetByUsername(ctx context.Context, username string) (common.User, error) {
+	if user, ok := d.users[username]; ok {
+		return user, nil
+	}
+	return nil, notFound(errors.New("no user"))
+}
+
+func (d *fakeData) UserCreate(ctx context.Context, username, pass, phone string) (common.User, error) {
+	user := &fakeUser{id: strconv.Itoa(len(d.users)), username: username, phone: phone}
+	d.users[username] = user
+	return user, nil
+}
+
+func (d *fakeData) UserSMSSent(ctx context.Context, user common.User, now time.Time) error {
+	d.sms[user.ID()]++
+	return nil
+}
+
+func (d *fakeData) NoteCreate(ctx context.Context, user common.User, text string, opts common.NoteOptions) (common.Note, error) {
+	note := fakeNote{strconv.Itoa(len(d.notes)), text, user.ID(), opts}
+	d.notes = append(d.notes, note)
+	return note, nil
+}
+
+func (d *fakeData) NoteGet(ctx context.Context, user common.User, id string) (common.Note, error) {
+	for _, note := range d.notes {
+		if note.id == id && note.userID == user.ID() {
+			return note, nil
+		}
+	}
+	return nil, nil
+}
+
+func (d *fakeData) NoteGetLatest(ctx context.Context, user common.User) (common.Note, error) {
+	for i := len(d.notes) - 1; i >= 0; i-- {
+		if d.notes[i].userID == user.ID() {
+			return d.notes[i], nil
+		}
+	}
+	return nil, nil
+}
+
+func (d *fakeData) NoteGetList(ctx context.Context, user common.User, page, limit int) ([]common.Note, bool, error) {
+	var notes []common.Note
+	for i := len(d.notes) - 1; i >= 0; i-- {
+		if d.notes[i].userID == user.ID() {
+			notes = append(notes, d.notes[i])
+		}
+	}
+	if page*limit >= len(notes) {
+		return nil, false, nil
+	}
+	notes = notes[page*limit:]
+	if len(notes) > limit {
+		return notes[:limit], true, nil
+	}
+	return notes, false, nil
+}
+
+func (d *fakeData) NoteDel(ctx context.Context, note common.Note) error {
+	for i := range d.notes {
+		if d.notes[i].id == note.ID() {
+			d.notes = append(d.notes[:i], d.notes[i+1:]...)
+			return nil
+		}
+	}
+	return notFound(errors.New("note already deleted"))
+}
+
+func (d *fakeData) AuditAppend(ctx context.Context, event common.AuditEvent) error {
+	d.audit = append(d.audit, event)
+	return nil
+}
+
+type fakeSMS struct {
+	smsLayer
+	sent         []string
+	unconfigured error /* Returned by Check. */
+}
+
+func (s *fakeSMS) Check() error { return s.unconfigured }
+
+func (s *fakeSMS) Send(ctx context.Context, number, text string) error {
+	s.sent = append(s.sent, text)
+	return nil
+}
+
+// fixtures; each test file registers the routes it covers on top of these.
+
+// testData holds alice, who logs in with "pass" and whose token is
+// token-alice.
+func testData() *fakeData {
+	return &fakeData{
+		users:  map[string]*fakeUser{"alice": {id: "alice", username: "alice", phone: "12085550100"}},
+		locked: map[string]bool{},
+		seen:   map[string]bool{},
+		nonces: map[string]string{},
+		ended:  map[string]bool{},
+		sms:    map[string]int{},
+	}
+}
+
+func testApp(data *fakeData, sms *fakeSMS, limits ratelimit.Limits) App {
+	gin.SetMode(gin.TestMode)
+	limit := ratelimit.Default(ratememory.Default(), limits)
+	return AppDefault(data, sms, export.Default(), nil, memory.Default(), limit)
+}
@@ -0,0 +1,106 @@
+package api
+
+import (
+	"context"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"gopkg.in/go-playground/assert.v1"
+	"smscp.xyz/internal/common"
+)
+
+func (d *fakeData) UserAll(ctx context.Context, user common.User) ([]common.Note, error) {
+	notes, _ := d.NoteGetAllStored(ctx, user)
+	for i, note := range notes {
+		if note.Burn() {
+			unread := note.(fakeNote)
+			unread.text = ""
+			notes[i] = unread
+		}
+	}
+	return notes, nil
+}
+
+func (d *fakeData) UserDel(ctx context.Context, user common.User) error {
+	var notes []fakeNote
+	for _, note := range d.notes {
+		if note.userID != user.ID() {
+			notes = append(notes, note)
+		}
+	}
+	d.notes = notes
+	delete(d.users, user.Username())
+	return nil
+}
+
+func (d *fakeData) UserGetDueForDeletion(ctx context.Context, now time.Time) ([]common.User, error) {
+	var users []common.User
+	for _, user := range d.users {
+		if !user.deleteAt.IsZero() && !user.deleteAt.After(now) {
+			users = append(users, user)
+		}
+	}
+	return users, nil
+}
+
+func gdprRoutes(app App, router *gin.Engine) {
+	router.GET("/gdpr", app.UserExportAllData)
+	router.POST("/gdpr", app.UserDeleteAllData)
+}
+
+func TestUserExport(t *testing.T) {
+	data := testData()
+	data.notes = []fakeNote{{id: "1", text: "hello", userID: "alice"}}
+	send := testWebRouter(t, data, &fakeSMS{}, gdprRoutes)
+
+	w := send("GET", "/gdpr", "")
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, "application/zip", w.Header().Get("Content-Type"))
+	assert.Equal(t, "attachment; filename=alice_user_data.zip", w.Header().Get("Content-Disposition"))
+
+	w = send("GET", "/gdpr?format=csv", "")
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, true, strings.HasPrefix(w.Body.String(), "id,created_at,"))
+	assert.Equal(t, true, strings.Contains(w.Body.String(), "1,1970-01-01T00:00:00Z,,false,false,hello"))
+
+	assert.Equal(t, http.StatusBadRequest, send("GET", "/gdpr?format=xml", "").Code)
+}
+
+func TestUserDeleteAllData(t *testing.T) {
+	data, sms := testData(), &fakeSMS{}
+	data.notes = []fakeNote{{id: "1", text: "hello", userID: "alice"}}
+	send := testWebRouter(t, data, sms, gdprRoutes)
+	alice := data.users["alice"]
+
+	assert.Equal(t, http.StatusUnauthorized, send("POST", "/gdpr", "Password=wrong").Code)
+	assert.Equal(t, true, alice.deleteAt.IsZero())
+
+	w := send("POST", "/gdpr", "Password=pass")
+	assert.Equal(t, http.StatusTemporaryRedirect, w.Code)
+	assert.Equal(t, true, alice.deleteAt.After(time.Now().Add(deleteGracePeriod-time.Minute)))
+	assert.Equal(t, 1, len(sms.sent))
+	assert.Equal(t, true, strings.Contains(w.Header().Get("Set-Cookie"), "Max-Age=0"))
+
+	// Logging in again keeps the account.
+	assert.Equal(t, http.StatusTemporaryRedirect, send("POST", "/user/login", "Username=alice&Password=pass").Code)
+	assert.Equal(t, true, alice.deleteAt.IsZero())
+	assert.Equal(t, 2, len(sms.sent))
+}
+
+func TestUserPurgeDeleted(t *testing.T) {
+	data, sms := testData(), &fakeSMS{}
+	data.users["bob"] = &fakeUser{id: "bob", username: "bob", deleteAt: time.Now().Add(time.Hour)}
+	data.notes = []fakeNote{{id: "1", text: "hello", userID: "alice"}, {id: "2", text: "bye", userID: "bob"}}
+	data.users["alice"].deleteAt = time.Now().Add(-time.Hour)
+	app := AppDefault(data, sms, nil, nil, nil, nil)
+
+	n, err := app.UserPurgeDeleted(context.Background(), time.Now())
+	assert.Equal(t, nil, err)
+	assert.Equal(t, 1, n)
+	assert.Equal(t, []fakeNote{{id: "2", text: "bye", userID: "bob"}}, data.notes)
+	assert.Equal(t, 1, len(data.users))
+	assert.Equal(t, []string{"Your smscp account and all of its notes have been deleted."}, sms.sent)
+}
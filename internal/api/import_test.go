@@ -0,0 +1,87 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"gopkg.in/go-playground/assert.v1"
+	"smscp.xyz/internal/common"
+	"smscp.xyz/internal/ratelimit"
+)
+
+func (d *fakeData) NoteGetAllStored(ctx context.Context, user common.User) ([]common.Note, error) {
+	var notes []common.Note
+	for _, note := range d.notes {
+		if note.userID == user.ID() {
+			notes = append(notes, note)
+		}
+	}
+	return notes, nil
+}
+
+func (d *fakeData) NoteImport(ctx context.Context, user common.User, notes []common.ImportedNote) (int, error) {
+	for _, note := range notes {
+		d.notes = append(d.notes, fakeNote{strconv.Itoa(len(d.notes)), note.Text, user.ID(), common.NoteOptions{}})
+	}
+	return len(notes), nil
+}
+
+func testImportRouter() (*gin.Engine, *fakeData, *fakeSMS) {
+	data, sms := testData(), &fakeSMS{}
+	app := testApp(data, sms, ratelimit.DefaultLimits())
+
+	router := gin.New()
+	router.POST("/api/v1/notes/import", app.AuthV1, app.NoteImportV1)
+	return router, data, sms
+}
+
+func TestV1NotesImport(t *testing.T) {
+	router, data, sms := testImportRouter()
+	data.notes = []fakeNote{{id: "0", text: "already here", userID: "alice"}}
+
+	file := "text,created_at\n" +
+		"already here,\n" + /* same text, no time */
+		"already here,1970-01-01T00:00:00Z\n" + /* same text and time */
+		"new,100\n" +
+		"new,100\n" + /* repeated in the file */
+		",\n" +
+		"later,2999-01-01T00:00:00Z\n"
+
+	req, _ := http.NewRequest("POST", "/api/v1/notes/import", strings.NewReader(file))
+	req.Header.Set("Authorization", "Bearer token-alice")
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusOK, w.Code)
+
+	var res importResource
+	assert.Equal(t, nil, json.Unmarshal(w.Body.Bytes(), &res))
+	assert.Equal(t, 1, res.Imported)
+	assert.Equal(t, 3, res.Duplicates)
+	assert.Equal(t, []importErrorResource{{6, "text is required"}, {7, "created_at is in the future"}}, res.Errors)
+
+	assert.Equal(t, 2, len(data.notes))
+	assert.Equal(t, 0, len(sms.sent))
+}
+
+func TestV1NotesImportBurn(t *testing.T) {
+	router, data, _ := testImportRouter()
+	data.notes = []fakeNote{{id: "0", text: "read me once", userID: "alice", opts: common.NoteOptions{Burn: true}}}
+
+	req, _ := http.NewRequest("POST", "/api/v1/notes/import", strings.NewReader("text,created_at,burn\nread me once,1970-01-01T00:00:00Z,true\n"))
+	req.Header.Set("Authorization", "Bearer token-alice")
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusOK, w.Code)
+
+	var res importResource
+	assert.Equal(t, nil, json.Unmarshal(w.Body.Bytes(), &res))
+	assert.Equal(t, 0, res.Imported)
+	assert.Equal(t, 1, res.Duplicates)
+	assert.Equal(t, 1, len(data.notes))
+}
@@ -0,0 +1,210 @@
+package api
+
+import (
+	"net/http"
+	"reflect"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// operation documents one route. Request and response schemas are generated
+// from the same payload structs the handlers bind and render, so the document
+// can't drift from the code.
+type operation struct {
+	method, path, summary string
+	bearer                bool
+	query                 []string
+	form, json            interface{} /* Request body, at most one. */
+	consumes              string      /* Or a raw request body of this type. */
+	status                int
+	produces              string /* Defaults to application/json. */
+	response              interface{}
+}
+
+var operations = []operation{
+	// pages
+	{method: "GET", path: "/", summary: "Main page", status: http.StatusOK, produces: "text/html"},
+	{method: "POST", path: "/", summary: "Main page (target of form redirects)", status: http.StatusOK, produces: "text/html"},
+	{method: "GET", path: "/ping", summary: "Liveness check", status: http.StatusOK, produces: "text/plain"},
+	{method: "GET", path: "/healthz", summary: "Liveness check", status: http.StatusOK, produces: "text/plain"},
+	{method: "GET", path: "/metrics", summary: "Prometheus metrics; the bearer token is METRICS_TOKEN, not a user's", bearer: true, status: http.StatusOK, produces: "text/plain"},
+	{method: "GET", path: "/readyz", summary: "Readiness check of storage and sms configuration; 503 when not ready", status: http.StatusOK, response: readyResource{}},
+	{method: "GET", path: "/api/openapi.json", summary: "This document", status: http.StatusOK},
+
+	// session authenticated forms
+	{method: "POST", path: "/user/login", summary: "Log in", form: loginForm{}, status: http.StatusTemporaryRedirect},
+	{method: "POST", path: "/user/create", summary: "Register", form: userForm{}, status: http.StatusTemporaryRedirect},
+	{method: "POST", path: "/user/update", summary: "Update account and retention", form: userUpdateForm{}, status: http.StatusTemporaryRedirect},
+	{method: "POST", path: "/user/logout", summary: "Log out", status: http.StatusTemporaryRedirect},
+	{method: "POST", path: "/user/forgot-password", summary: "Text a password reset link", form: forgotPasswordForm{}, status: http.StatusTemporaryRedirect},
+	{method: "GET", path: "/reset/:hash", summary: "Password reset page", status: http.StatusOK, produces: "text/html"},
+	{method: "POST", path: "/reset/:hash", summary: "Reset password", form: newPasswordForm{}, status: http.StatusTemporaryRedirect},
+	{method: "POST", path: "/note/create", summary: "Create a note and text it", form: noteForm{}, status: http.StatusTemporaryRedirect},
+	{method: "GET", path: "/note/list/:page", summary: "Page of notes", status: http.StatusOK, response: noteListJSON{}},
+	{method: "GET", path: "/note/events", summary: "Server-sent note events", status: http.StatusOK, produces: "text/event-stream"},
+	{method: "GET", path: "/gdpr", summary: "Export all user data as ?format=zip (default), json or csv", query: []string{"format"}, status: http.StatusOK, produces: "application/zip"},
+	{method: "POST", path: "/gdpr", summary: "Delete all user data after a 7 day grace period; logging in cancels", form: deleteAccountForm{}, status: http.StatusTemporaryRedirect},
+
+	// admin, for users with the admin role; pages are HTML unless JSON is asked for
+	{method: "GET", path: "/admin", summary: "Search accounts by username prefix or phone", query: []string{"q"}, status: http.StatusOK, response: accountListResource{}},
+	{method: "GET", path: "/admin/users/:id", summary: "Account with note count and sms usage", status: http.StatusOK, response: accountResource{}},
+	{method: "POST", path: "/admin/users/:id/lock", summary: "Lock an account and end its sessions until unlocked", status: http.StatusSeeOther},
+	{method: "POST", path: "/admin/users/:id/unlock", summary: "Unlock an account however it was locked", status: http.StatusSeeOther},
+	{method: "POST", path: "/admin/users/:id/logout", summary: "End every session and token of an account", status: http.StatusSeeOther},
+	{method: "GET", path: "/admin/audit", summary: "Export the audit log of ?user=, or of every account, as ?format=csv (default) or json", query: []string{"user", "format"}, status: http.StatusOK, produces: "text/csv"},
+
+	// webhooks
+	{method: "POST", path: "/hook/sms/receive", summary: "Twilio inbound sms", form: struct{ Body, From, FromCountry string }{}, status: http.StatusOK, produces: "text/plain"},
+
+	// v1
+	{method: "POST", path: "/api/v1/users", summary: "Register", json: userCreateRequest{}, status: http.StatusCreated, response: sessionResource{}},
+	{method: "POST", path: "/api/v1/sessions", summary: "Log in", json: sessionCreateRequest{}, status: http.StatusCreated, response: sessionResource{}},
+	{method: "GET", path: "/api/v1/users/me", summary: "Current user", bearer: true, status: http.StatusOK, response: userResource{}},
+	{method: "GET", path: "/api/v1/notes", summary: "Page of notes; burn after read notes come without their text", bearer: true, query: []string{"page"}, status: http.StatusOK, response: noteListResource{}},
+	{method: "POST", path: "/api/v1/notes", summary: "Create a note and text it", bearer: true, json: noteCreateRequest{}, status: http.StatusCreated, response: noteResource{}},
+	{method: "GET", path: "/api/v1/notes/:id", summary: "Get a note; the id `latest` is the most recent note. Burn after read notes are deleted once returned", bearer: true, status: http.StatusOK, response: noteResource{}},
+	{method: "DELETE", path: "/api/v1/notes/:id", summary: "Delete a note", bearer: true, status: http.StatusNoContent},
+	{method: "POST", path: "/api/v1/notes/import", summary: "Import notes from an export or a CSV with a text column, without texting them", bearer: true, consumes: "application/octet-stream", status: http.StatusOK, response: importResource{}},
+
+	// deprecated cli
+	{method: "POST", path: "/cli/user/login", summary: "Deprecated; use /api/v1/sessions", form: loginForm{}, status: http.StatusOK, response: cliTokenResponse{}},
+	{method: "POST", path: "/cli/user/create", summary: "Deprecated; use /api/v1/users", form: userForm{}, status: http.StatusOK, response: cliTokenResponse{}},
+	{method: "POST", path: "/cli/note/create", summary: "Deprecated; use /api/v1/notes", form: noteCLIForm{}, status: http.StatusOK, response: cliMessageResponse{}},
+	{method: "POST", path: "/cli/note/latest", summary: "Deprecated; use /api/v1/notes/latest", form: tokenCLIForm{}, status: http.StatusOK, response: cliLatestResponse{}},
+}
+
+var openapi = openapiDocument()
+
+func (app App) OpenAPI(c *gin.Context) {
+	c.JSON(http.StatusOK, openapi)
+}
+
+var pathParam = regexp.MustCompile(`[:*]([^/]+)`)
+
+func openapiDocument() gin.H {
+	paths := gin.H{}
+
+	for _, op := range operations {
+		path := pathParam.ReplaceAllString(op.path, "{$1}")
+		item, ok := paths[path].(gin.H)
+		if !ok {
+			item = gin.H{}
+			paths[path] = item
+		}
+
+		var params []gin.H
+		for _, match := range pathParam.FindAllStringSubmatch(op.path, -1) {
+			params = append(params, gin.H{"name": match[1], "in": "path", "required": true, "schema": gin.H{"type": "string"}})
+		}
+		for _, name := range op.query {
+			params = append(params, gin.H{"name": name, "in": "query", "schema": gin.H{"type": "string"}})
+		}
+
+		produces := op.produces
+		if produces == "" {
+			produces = "application/json"
+		}
+		response := gin.H{"description": http.StatusText(op.status)}
+		if op.response != nil {
+			response["content"] = gin.H{produces: gin.H{"schema": schema(reflect.TypeOf(op.response), "json")}}
+		} else if op.status != http.StatusNoContent && op.status != http.StatusTemporaryRedirect && op.status != http.StatusSeeOther {
+			response["content"] = gin.H{produces: gin.H{}}
+		}
+
+		doc := gin.H{
+			"summary":   op.summary,
+			"responses": gin.H{strconv.Itoa(op.status): response},
+		}
+		if strings.HasPrefix(op.path, "/api/v1/") {
+			doc["responses"].(gin.H)["default"] = gin.H{
+				"description": "Error",
+				"content":     gin.H{"application/json": gin.H{"schema": schema(reflect.TypeOf(errorResource{}), "json")}},
+			}
+		}
+		if params != nil {
+			doc["parameters"] = params
+		}
+		if op.bearer {
+			doc["security"] = []gin.H{{"bearer": []string{}}}
+		}
+		if op.form != nil {
+			doc["requestBody"] = gin.H{"required": true, "content": gin.H{
+				"application/x-www-form-urlencoded": gin.H{"schema": schema(reflect.TypeOf(op.form), "form")},
+			}}
+		}
+		if op.json != nil {
+			doc["requestBody"] = gin.H{"required": true, "content": gin.H{
+				"application/json": gin.H{"schema": schema(reflect.TypeOf(op.json), "json")},
+			}}
+		}
+
+		if op.consumes != "" {
+			doc["requestBody"] = gin.H{"required": true, "content": gin.H{
+				op.consumes: gin.H{"schema": gin.H{"type": "string", "format": "binary"}},
+			}}
+		}
+
+		item[strings.ToLower(op.method)] = doc
+	}
+
+	return gin.H{
+		"openapi": "3.0.3",
+		"info":    gin.H{"title": "smscp", "version": "1.0.0"},
+		"paths":   paths,
+		"components": gin.H{"securitySchemes": gin.H{
+			"bearer": gin.H{"type": "http", "scheme": "bearer"},
+		}},
+	}
+}
+
+var timeType = reflect.TypeOf(time.Time{})
+
+// schema describes t the way encoding/json (tag "json") or gin's form binding
+// (tag "form") would see it.
+func schema(t reflect.Type, tag string) gin.H {
+	for t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+
+	if t == timeType {
+		return gin.H{"type": "string", "format": "date-time"}
+	}
+
+	switch t.Kind() {
+	case reflect.String:
+		return gin.H{"type": "string"}
+	case reflect.Bool:
+		return gin.H{"type": "boolean"}
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return gin.H{"type": "integer"}
+	case reflect.Float32, reflect.Float64:
+		return gin.H{"type": "number"}
+	case reflect.Slice, reflect.Array:
+		return gin.H{"type": "array", "items": schema(t.Elem(), tag)}
+	case reflect.Struct:
+		props := gin.H{}
+		for i := 0; i < t.NumField(); i++ {
+			field := t.Field(i)
+			if field.PkgPath != "" {
+				continue // unexported
+			}
+			name := strings.Split(field.Tag.Get(tag), ",")[0]
+			if name == "-" {
+				continue
+			}
+			if name == "" {
+				name = field.Name
+			}
+			props[name] = schema(field.Type, tag)
+		}
+		return gin.H{"type": "object", "properties": props}
+	}
+
+	// interfaces (i.e. common.Note) and maps
+	return gin.H{"type": "object"}
+}
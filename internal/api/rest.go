@@ -0,0 +1,418 @@
+package api
+
+import (
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/pkg/errors"
+	"smscp.xyz/internal/bus"
+	"smscp.xyz/internal/common"
+	"smscp.xyz/internal/export"
+	"smscp.xyz/pkg/e2e"
+)
+
+const (
+	contextKeyUser = "USER"
+	maxImportBytes = 10 << 20
+	maxImportNotes = 10000
+)
+
+// resources
+
+type userResource struct {
+	ID        string    `json:"id"`
+	Username  string    `json:"username"`
+	Phone     string    `json:"phone"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+type noteResource struct {
+	ID        string     `json:"id"`
+	Text      string     `json:"text"` /* Empty for burn after read notes in lists. */
+	Short     string     `json:"short"`
+	Encrypted bool       `json:"encrypted"`
+	Burn      bool       `json:"burn"` /* Deleted by the get that returned it. */
+	ExpiresAt *time.Time `json:"expires_at,omitempty"`
+	CreatedAt time.Time  `json:"created_at"`
+}
+
+type noteListResource struct {
+	Notes   []noteResource `json:"notes"`
+	HasMore bool           `json:"has_more"`
+}
+
+type importResource struct {
+	Imported   int                   `json:"imported"`
+	Duplicates int                   `json:"duplicates"` /* Already stored, so skipped. */
+	Errors     []importErrorResource `json:"errors"`
+}
+
+type importErrorResource struct {
+	Line    int    `json:"line"` /* CSV record counting the header, or JSON list position. */
+	Message string `json:"message"`
+}
+
+type sessionResource struct {
+	Token string       `json:"token"`
+	User  userResource `json:"user"`
+}
+
+func toUserResource(user common.User) userResource {
+	return userResource{user.ID(), user.Username(), user.Phone(), user.CreatedAt()}
+}
+
+func toNoteResource(note common.Note) noteResource {
+	res := noteResource{note.ID(), note.Text(), note.Short(), note.Encrypted(), note.Burn(), nil, note.CreatedAt()}
+	if at := note.ExpiresAt(); !at.IsZero() {
+		res.ExpiresAt = &at
+	}
+	return res
+}
+
+// requests
+
+type userCreateRequest struct {
+	Username string `json:"username"`
+	Password string `json:"password"`
+	Verify   string `json:"verify"`
+	Phone    string `json:"phone"`
+}
+
+type sessionCreateRequest struct {
+	Username string `json:"username"`
+	Password string `json:"password"`
+}
+
+type noteCreateRequest struct {
+	Text       string `json:"text"`
+	Encrypted  bool   `json:"encrypted"`   /* Text sealed with pkg/e2e. */
+	TTLSeconds int    `json:"ttl_seconds"` /* Zero keeps the note until deleted. */
+	Burn       bool   `json:"burn"`        /* Delete once fetched. */
+	Client     string `json:"client"`      /* "cli" from the smscp cli; only labels metrics, so it's taken on trust. */
+}
+
+// middleware
+
+// AuthV1 resolves the `Authorization: Bearer <token>` header into a user for
+// the handlers that follow.
+func (app App) AuthV1(c *gin.Context) {
+	header := c.GetHeader("Authorization")
+	if !strings.HasPrefix(header, "Bearer ") {
+		app.errorV1(c, unauthorized(errors.New("missing bearer token")))
+		return
+	}
+
+	user, err := app.authV1(c, strings.TrimPrefix(header, "Bearer "))
+	if err != nil {
+		app.errorV1(c, err)
+		return
+	}
+
+	c.Set(contextKeyUser, user)
+	c.Next()
+}
+
+// authV1 resolves a token into its user. Only a bad token is unauthorized;
+// clients drop their token on a 401, so a locked account or a storage failure
+// must not look like one.
+func (app App) authV1(c *gin.Context, token string) (common.User, error) {
+	user, err := app.currentUserFromToken(c, token)
+	if status, _ := classify(err); status == http.StatusUnauthorized {
+		return nil, errors.Wrap(err, "invalid bearer token")
+	}
+	return user, err
+}
+
+func (app App) userV1(c *gin.Context) common.User {
+	return c.MustGet(contextKeyUser).(common.User)
+}
+
+// handlers
+
+func (app App) UserCreateV1(c *gin.Context) {
+	var payload userCreateRequest
+	if err := c.ShouldBindJSON(&payload); err != nil {
+		app.errorV1(c, invalid(err))
+		return
+	}
+
+	user, err := app.userCreate(c, "api", payload.Username, payload.Password, payload.Verify, payload.Phone)
+	if err != nil {
+		app.errorV1(c, err)
+		return
+	}
+
+	c.JSON(http.StatusCreated, sessionResource{user.Token(), toUserResource(user)})
+}
+
+func (app App) SessionCreateV1(c *gin.Context) {
+	var payload sessionCreateRequest
+	if err := c.ShouldBindJSON(&payload); err != nil {
+		app.errorV1(c, invalid(err))
+		return
+	}
+
+	user, err := app.sessionCreateV1(c, "api", payload)
+	if err != nil {
+		app.errorV1(c, err)
+		return
+	}
+
+	c.JSON(http.StatusCreated, sessionResource{user.Token(), toUserResource(user)})
+}
+
+func (app App) UserGetV1(c *gin.Context) {
+	c.JSON(http.StatusOK, toUserResource(app.userV1(c)))
+}
+
+func (app App) NoteListV1(c *gin.Context) {
+	page, err := strconv.Atoi(c.DefaultQuery("page", "0"))
+	if err != nil || page < 0 {
+		app.errorV1(c, invalid(errors.New("page must be a positive number")))
+		return
+	}
+
+	notes, hasMore, err := app.data.NoteGetList(c, app.userV1(c), page, perPage)
+	if err != nil {
+		app.errorV1(c, err)
+		return
+	}
+
+	res := noteListResource{Notes: []noteResource{}, HasMore: hasMore}
+	for _, note := range notes {
+		res.Notes = append(res.Notes, toNoteResource(note))
+	}
+
+	c.JSON(http.StatusOK, res)
+}
+
+func (app App) NoteCreateV1(c *gin.Context) {
+	var payload noteCreateRequest
+	if err := c.ShouldBindJSON(&payload); err != nil {
+		app.errorV1(c, invalid(err))
+		return
+	}
+
+	// The client says which it is; anything but the cli counts as the api, so
+	// the metric keeps to known labels.
+	channel := "api"
+	if payload.Client == "cli" {
+		channel = "cli"
+	}
+
+	note, err := app.noteCreateV1(c, channel, app.userV1(c), payload)
+	if err != nil {
+		app.errorV1(c, err)
+		return
+	}
+
+	c.JSON(http.StatusCreated, toNoteResource(note))
+}
+
+// NoteGetV1 also answers for the id "latest", the most recently created note.
+// Burn after read notes are deleted before they are returned.
+func (app App) NoteGetV1(c *gin.Context) {
+	note, err := app.noteGetV1(c, app.userV1(c), c.Param("id"))
+	if err != nil {
+		app.errorV1(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, toNoteResource(note))
+}
+
+func (app App) NoteDeleteV1(c *gin.Context) {
+	note, err := app.noteV1(c, app.userV1(c), c.Param("id"))
+	if err != nil {
+		app.errorV1(c, err)
+		return
+	}
+
+	if err := app.data.NoteDel(c, note); err != nil {
+		app.errorV1(c, err)
+		return
+	}
+
+	app.publish(c, bus.NoteDeleted, app.userV1(c), note.ID())
+
+	c.Status(http.StatusNoContent)
+}
+
+// NoteImportV1 loads notes from the request body: an export from /gdpr in any
+// format, or a CSV with a text column. Nothing is texted.
+func (app App) NoteImportV1(c *gin.Context) {
+	user := app.userV1(c)
+
+	byt, err := ioutil.ReadAll(http.MaxBytesReader(c.Writer, c.Request.Body, maxImportBytes))
+	if err != nil {
+		app.errorV1(c, invalid(fmt.Errorf("failed to read import; at most %d bytes", maxImportBytes)))
+		return
+	}
+
+	rows, err := export.Parse(byt)
+	if err != nil {
+		app.errorV1(c, invalid(err))
+		return
+	}
+	if len(rows) > maxImportNotes {
+		app.errorV1(c, invalid(fmt.Errorf("too many notes; import at most %d at a time", maxImportNotes)))
+		return
+	}
+
+	existing, err := app.data.NoteGetAllStored(c, user)
+	if err != nil {
+		app.errorV1(c, err)
+		return
+	}
+
+	res, notes := planImport(rows, existing, app.cfg.now())
+	if res.Imported, err = app.data.NoteImport(c, user, notes); err != nil {
+		app.errorV1(c, err)
+		return
+	}
+
+	if res.Imported > 0 {
+		app.stats.NoteCreated("import", res.Imported)
+		app.publish(c, bus.NoteCreated, user, "")
+	}
+
+	c.JSON(http.StatusOK, res)
+}
+
+// planImport picks the rows to import, skipping those already stored: the
+// same text created at the same second, or just the same text when the row
+// has no time.
+func planImport(rows []export.Row, existing []common.Note, now time.Time) (importResource, []common.ImportedNote) {
+	var (
+		res   = importResource{Errors: []importErrorResource{}}
+		notes []common.ImportedNote
+		texts = map[string]bool{}
+		seen  = map[string]bool{}
+	)
+	key := func(text string, at time.Time) string { return strconv.FormatInt(at.Unix(), 10) + ":" + text }
+	for _, note := range existing {
+		texts[note.Text()] = true
+		seen[key(note.Text(), note.CreatedAt())] = true
+	}
+
+	for _, row := range rows {
+		fail := func(err error) {
+			res.Errors = append(res.Errors, importErrorResource{row.Line, err.Error()})
+		}
+
+		note := row.Note
+		switch {
+		case row.Err != nil:
+			fail(row.Err)
+			continue
+		case strings.TrimSpace(note.Text) == "":
+			fail(errors.New("text is required"))
+			continue
+		case note.Encrypted && !e2e.Valid(note.Text):
+			fail(errors.New("encrypted note must be sealed by the client"))
+			continue
+		case note.CreatedAt.After(now):
+			fail(errors.New("created_at is in the future"))
+			continue
+		case note.ExpiresAt != nil && !note.ExpiresAt.After(now):
+			fail(errors.New("already expired"))
+			continue
+		}
+
+		duplicate := texts[note.Text]
+		if !note.CreatedAt.IsZero() {
+			duplicate = seen[key(note.Text, note.CreatedAt)]
+			seen[key(note.Text, note.CreatedAt)] = true
+		}
+		texts[note.Text] = true
+		if duplicate {
+			res.Duplicates++
+			continue
+		}
+
+		imported := common.ImportedNote{
+			Text:      note.Text,
+			CreatedAt: note.CreatedAt,
+			Encrypted: note.Encrypted,
+			Burn:      note.Burn,
+		}
+		if note.ExpiresAt != nil {
+			imported.ExpiresAt = *note.ExpiresAt
+		}
+		notes = append(notes, imported)
+	}
+
+	return res, notes
+}
+
+// v1, after binding; the deprecated /cli handlers share these
+
+func (app App) sessionCreateV1(c *gin.Context, channel string, payload sessionCreateRequest) (common.User, error) {
+	user, err := app.userLogin(c, channel, payload.Username, payload.Password)
+	if status, _ := classify(err); status == http.StatusUnauthorized {
+		return nil, unauthorized(errors.New("invalid username or password"))
+	}
+	return user, err
+}
+
+func (app App) noteCreateV1(c *gin.Context, channel string, user common.User, payload noteCreateRequest) (common.Note, error) {
+	if strings.TrimSpace(payload.Text) == "" {
+		return nil, invalid(errors.New("text is required"))
+	}
+
+	opts := common.NoteOptions{
+		Encrypted: payload.Encrypted,
+		TTL:       time.Duration(payload.TTLSeconds) * time.Second,
+		Burn:      payload.Burn,
+	}
+	if payload.TTLSeconds != 0 {
+		if err := checkTTL(opts.TTL); err != nil {
+			return nil, err
+		}
+	}
+
+	return app.noteCreate(c, channel, user, payload.Text, opts)
+}
+
+// noteGetV1 gets a note like noteV1, deleting it first when it burns after
+// read.
+func (app App) noteGetV1(c *gin.Context, user common.User, id string) (common.Note, error) {
+	note, err := app.noteV1(c, user, id)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := app.burn(c, user, note); err != nil {
+		return nil, err
+	}
+
+	return note, nil
+}
+
+// noteV1 gets a note by id, or the most recent for the id "latest".
+func (app App) noteV1(c *gin.Context, user common.User, id string) (common.Note, error) {
+	var (
+		note common.Note
+		err  error
+	)
+
+	if id == "latest" {
+		note, err = app.data.NoteGetLatest(c, user)
+	} else {
+		note, err = app.data.NoteGet(c, user, id)
+	}
+
+	if err != nil {
+		return nil, err
+	}
+	if note == nil {
+		return nil, notFound(errors.New("note not found"))
+	}
+
+	return note, nil
+}
@@ -0,0 +1,238 @@
+package api
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/pkg/errors"
+	"gopkg.in/go-playground/assert.v1"
+	"smscp.xyz/internal/ratelimit"
+	"smscp.xyz/pkg/client"
+	"smscp.xyz/pkg/e2e"
+)
+
+func testRouter() (*gin.Engine, *fakeData, *fakeSMS) {
+	return testRouterWithLimits(ratelimit.DefaultLimits())
+}
+
+// testRouterWithLimits serves /api/v1, but for imports (see import_test.go).
+func testRouterWithLimits(limits ratelimit.Limits) (*gin.Engine, *fakeData, *fakeSMS) {
+	data, sms := testData(), &fakeSMS{}
+	app := testApp(data, sms, limits)
+
+	router := gin.New()
+	v1 := router.Group("/api/v1")
+	v1.POST("/users", app.UserCreateV1)
+	v1.POST("/sessions", app.SessionCreateV1)
+	v1auth := v1.Group("", app.AuthV1)
+	v1auth.GET("/users/me", app.UserGetV1)
+	v1auth.GET("/notes", app.NoteListV1)
+	v1auth.POST("/notes", app.NoteCreateV1)
+	v1auth.GET("/notes/:id", app.NoteGetV1)
+	v1auth.DELETE("/notes/:id", app.NoteDeleteV1)
+
+	return router, data, sms
+}
+
+func do(router *gin.Engine, method, path, token string, body interface{}) *httptest.ResponseRecorder {
+	byt, _ := json.Marshal(body)
+	req, _ := http.NewRequest(method, path, bytes.NewReader(byt))
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("User-Agent", "test")
+	if token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+	return w
+}
+
+func TestV1Session(t *testing.T) {
+	router, _, _ := testRouter()
+
+	w := do(router, "POST", "/api/v1/sessions", "", sessionCreateRequest{"alice", "pass"})
+	assert.Equal(t, http.StatusCreated, w.Code)
+
+	var session sessionResource
+	assert.Equal(t, nil, json.Unmarshal(w.Body.Bytes(), &session))
+	assert.Equal(t, "token-alice", session.Token)
+	assert.Equal(t, "alice", session.User.Username)
+
+	w = do(router, "POST", "/api/v1/sessions", "", sessionCreateRequest{"alice", "wrong"})
+	assert.Equal(t, http.StatusUnauthorized, w.Code)
+
+	var res errorResource
+	assert.Equal(t, nil, json.Unmarshal(w.Body.Bytes(), &res))
+	assert.Equal(t, "unauthorized", res.Error.Code)
+}
+
+func TestV1UserCreateValidation(t *testing.T) {
+	router, _, _ := testRouter()
+
+	w := do(router, "POST", "/api/v1/users", "", userCreateRequest{"bob", "a", "b", "(208) 555-0100"})
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+
+	w = do(router, "POST", "/api/v1/users", "", userCreateRequest{"bob", "a", "a", "not a phone"})
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+}
+
+func TestV1Auth(t *testing.T) {
+	router, data, _ := testRouter()
+
+	assert.Equal(t, http.StatusUnauthorized, do(router, "GET", "/api/v1/users/me", "", nil).Code)
+	assert.Equal(t, http.StatusUnauthorized, do(router, "GET", "/api/v1/users/me", "bogus", nil).Code)
+	assert.Equal(t, http.StatusOK, do(router, "GET", "/api/v1/users/me", "token-alice", nil).Code)
+
+	// Only bad tokens are unauthorized, so clients don't throw away good ones.
+	data.locked["alice"] = true
+	w := do(router, "GET", "/api/v1/users/me", "token-alice", nil)
+	assert.Equal(t, http.StatusForbidden, w.Code)
+	assert.Equal(t, true, strings.Contains(w.Body.String(), `"code":"locked"`))
+
+	data.locked["alice"] = false
+	data.down = errors.New("failed to reach firestore")
+	assert.Equal(t, http.StatusInternalServerError, do(router, "GET", "/api/v1/users/me", "token-alice", nil).Code)
+}
+
+func TestV1Notes(t *testing.T) {
+	router, data, sms := testRouter()
+
+	w := do(router, "POST", "/api/v1/notes", "token-alice", noteCreateRequest{Text: "hello"})
+	assert.Equal(t, http.StatusCreated, w.Code)
+	assert.Equal(t, []string{"hello"}, sms.sent)
+
+	var note noteResource
+	assert.Equal(t, nil, json.Unmarshal(w.Body.Bytes(), &note))
+	assert.Equal(t, "hello", note.Text)
+
+	w = do(router, "GET", "/api/v1/notes/latest", "token-alice", nil)
+	assert.Equal(t, http.StatusOK, w.Code)
+
+	assert.Equal(t, http.StatusNoContent, do(router, "DELETE", "/api/v1/notes/"+note.ID, "token-alice", nil).Code)
+	assert.Equal(t, 0, len(data.notes))
+	assert.Equal(t, http.StatusNotFound, do(router, "GET", "/api/v1/notes/"+note.ID, "token-alice", nil).Code)
+
+	assert.Equal(t, http.StatusBadRequest, do(router, "POST", "/api/v1/notes", "token-alice", noteCreateRequest{Text: " "}).Code)
+}
+
+func TestV1RateLimited(t *testing.T) {
+	limits := ratelimit.DefaultLimits()
+	limits.LoginUsername = ratelimit.Limit{Burst: 1, Per: time.Hour}
+	limits.SMSDaily = ratelimit.Limit{Burst: 1, Per: 24 * time.Hour}
+	router, _, sms := testRouterWithLimits(limits)
+
+	assert.Equal(t, http.StatusUnauthorized, do(router, "POST", "/api/v1/sessions", "", sessionCreateRequest{"alice", "wrong"}).Code)
+
+	w := do(router, "POST", "/api/v1/sessions", "", sessionCreateRequest{"alice", "pass"})
+	assert.Equal(t, http.StatusTooManyRequests, w.Code)
+	assert.Equal(t, "3600", w.Header().Get("Retry-After"))
+
+	assert.Equal(t, http.StatusCreated, do(router, "POST", "/api/v1/notes", "token-alice", noteCreateRequest{Text: "one"}).Code)
+	assert.Equal(t, http.StatusTooManyRequests, do(router, "POST", "/api/v1/notes", "token-alice", noteCreateRequest{Text: "two"}).Code)
+	assert.Equal(t, 1, len(sms.sent))
+}
+
+func TestV1SessionLocked(t *testing.T) {
+	router, data, _ := testRouter()
+	data.locked["alice"] = true
+
+	w := do(router, "POST", "/api/v1/sessions", "", sessionCreateRequest{"alice", "pass"})
+	assert.Equal(t, http.StatusForbidden, w.Code)
+
+	var res errorResource
+	assert.Equal(t, nil, json.Unmarshal(w.Body.Bytes(), &res))
+	assert.Equal(t, "locked", res.Error.Code)
+}
+
+func TestV1SessionNewDevice(t *testing.T) {
+	router, _, sms := testRouter()
+
+	assert.Equal(t, http.StatusCreated, do(router, "POST", "/api/v1/sessions", "", sessionCreateRequest{"alice", "pass"}).Code)
+	assert.Equal(t, 0, len(sms.sent))
+
+	req, _ := http.NewRequest("POST", "/api/v1/sessions", bytes.NewReader([]byte(`{"username":"alice","password":"pass"}`)))
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("User-Agent", "elsewhere")
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+
+	assert.Equal(t, http.StatusCreated, w.Code)
+	assert.Equal(t, 1, len(sms.sent))
+}
+
+func TestV1NotesEncrypted(t *testing.T) {
+	router, data, sms := testRouter()
+
+	assert.Equal(t, http.StatusBadRequest, do(router, "POST", "/api/v1/notes", "token-alice", noteCreateRequest{Text: "plain text", Encrypted: true}).Code)
+	assert.Equal(t, 0, len(data.notes))
+
+	sealed, err := e2e.SealWith(e2e.Params{Time: 1, Memory: 64, Threads: 1}, "pass", "secret")
+	assert.Equal(t, nil, err)
+
+	w := do(router, "POST", "/api/v1/notes", "token-alice", noteCreateRequest{Text: sealed, Encrypted: true})
+	assert.Equal(t, http.StatusCreated, w.Code)
+	assert.Equal(t, false, strings.Contains(sms.sent[0], sealed))
+
+	var note noteResource
+	assert.Equal(t, nil, json.Unmarshal(w.Body.Bytes(), &note))
+	assert.Equal(t, true, note.Encrypted)
+	assert.Equal(t, sealed, note.Text)
+}
+
+func TestV1NotesBurn(t *testing.T) {
+	router, data, _ := testRouter()
+
+	w := do(router, "POST", "/api/v1/notes", "token-alice", noteCreateRequest{Text: "123456", Burn: true, TTLSeconds: 600})
+	assert.Equal(t, http.StatusCreated, w.Code)
+
+	var note noteResource
+	assert.Equal(t, nil, json.Unmarshal(w.Body.Bytes(), &note))
+	assert.Equal(t, true, note.Burn)
+	assert.Equal(t, time.Unix(600, 0).UTC(), *note.ExpiresAt)
+
+	w = do(router, "GET", "/api/v1/notes/latest", "token-alice", nil)
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, 0, len(data.notes))
+	assert.Equal(t, http.StatusNotFound, do(router, "GET", "/api/v1/notes/"+note.ID, "token-alice", nil).Code)
+
+	for _, ttl := range []int{-1, 1, 60 * 60 * 24 * 365} {
+		assert.Equal(t, http.StatusBadRequest, do(router, "POST", "/api/v1/notes", "token-alice", noteCreateRequest{Text: "a", TTLSeconds: ttl}).Code)
+	}
+}
+
+type fakeStats struct {
+	notes   map[string]int
+	rejects []string
+}
+
+func (s *fakeStats) NoteCreated(channel string, n int) { s.notes[channel] += n }
+func (s *fakeStats) WebhookRejected(reason string)     { s.rejects = append(s.rejects, reason) }
+
+func TestNoteChannels(t *testing.T) {
+	stats := &fakeStats{notes: map[string]int{}}
+	app := testApp(testData(), &fakeSMS{}, ratelimit.DefaultLimits()).WithMetrics(stats)
+
+	router := gin.New()
+	router.POST("/api/v1/notes", app.AuthV1, app.NoteCreateV1)
+
+	assert.Equal(t, http.StatusCreated, do(router, "POST", "/api/v1/notes", "token-alice", noteCreateRequest{Text: "from a script"}).Code)
+
+	assert.Equal(t, http.StatusCreated, do(router, "POST", "/api/v1/notes", "token-alice", noteCreateRequest{Text: "from the cli", Client: "cli"}).Code)
+	assert.Equal(t, http.StatusCreated, do(router, "POST", "/api/v1/notes", "token-alice", noteCreateRequest{Text: "made up", Client: "toaster"}).Code)
+
+	// The user agent is no say.
+	req, _ := http.NewRequest("POST", "/api/v1/notes", strings.NewReader(`{"text":"from the cli?"}`))
+	req.Header.Set("Authorization", "Bearer token-alice")
+	req.Header.Set("User-Agent", client.UserAgent)
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusCreated, w.Code)
+
+	assert.Equal(t, map[string]int{"api": 3, "cli": 1}, stats.notes)
+}
@@ -0,0 +1,26 @@
+package bus
+
+import "context"
+
+type EventType string
+
+const (
+	NoteCreated EventType = "note.created"
+	NoteDeleted EventType = "note.deleted"
+)
+
+// Event is published whenever a note changes. NoteID is empty when every note
+// belonging to the user was removed at once (i.e. account deletion).
+type Event struct {
+	Type   EventType
+	UserID string
+	NoteID string
+}
+
+// Bus fans events out to every subscriber of a user, regardless of which
+// server instance published them.
+type Bus interface {
+	Publish(ctx context.Context, event Event) error
+	Subscribe(ctx context.Context, userID string) (<-chan Event, error) /* Closed once ctx is done. */
+	Close() error
+}
@@ -0,0 +1,59 @@
+package memory
+
+import (
+	"context"
+	"sync"
+
+	"smscp.xyz/internal/bus"
+)
+
+// Bus only reaches subscribers within the same process. Use it for local
+// development and tests; anything running more than one instance needs redis.
+type Bus struct {
+	mu   *sync.RWMutex
+	subs map[string]map[chan bus.Event]struct{}
+}
+
+func Default() Bus {
+	return Bus{&sync.RWMutex{}, map[string]map[chan bus.Event]struct{}{}}
+}
+
+func (b Bus) Publish(ctx context.Context, event bus.Event) error {
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+
+	for sub := range b.subs[event.UserID] {
+		select {
+		case sub <- event:
+		default: // slow subscriber; drop rather than block the publisher
+		}
+	}
+
+	return nil
+}
+
+func (b Bus) Subscribe(ctx context.Context, userID string) (<-chan bus.Event, error) {
+	sub := make(chan bus.Event, 16)
+
+	b.mu.Lock()
+	if b.subs[userID] == nil {
+		b.subs[userID] = map[chan bus.Event]struct{}{}
+	}
+	b.subs[userID][sub] = struct{}{}
+	b.mu.Unlock()
+
+	go func() {
+		<-ctx.Done()
+		b.mu.Lock()
+		delete(b.subs[userID], sub)
+		if len(b.subs[userID]) == 0 {
+			delete(b.subs, userID)
+		}
+		b.mu.Unlock()
+		close(sub)
+	}()
+
+	return sub, nil
+}
+
+func (b Bus) Close() error { return nil }
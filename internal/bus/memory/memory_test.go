@@ -0,0 +1,48 @@
+package memory_test
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"gopkg.in/go-playground/assert.v1"
+	"smscp.xyz/internal/bus"
+	"smscp.xyz/internal/bus/memory"
+)
+
+func TestFanOut(t *testing.T) {
+	b := memory.Default()
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	first, err := b.Subscribe(ctx, "user")
+	assert.Equal(t, nil, err)
+	second, err := b.Subscribe(ctx, "user")
+	assert.Equal(t, nil, err)
+	other, err := b.Subscribe(ctx, "other")
+	assert.Equal(t, nil, err)
+
+	event := bus.Event{Type: bus.NoteCreated, UserID: "user", NoteID: "note"}
+	assert.Equal(t, nil, b.Publish(ctx, event))
+
+	assert.Equal(t, event, <-first)
+	assert.Equal(t, event, <-second)
+
+	select {
+	case <-other:
+		t.Fatal("event delivered to another user")
+	case <-time.After(50 * time.Millisecond):
+	}
+}
+
+func TestUnsubscribe(t *testing.T) {
+	b := memory.Default()
+	ctx, cancel := context.WithCancel(context.Background())
+
+	sub, err := b.Subscribe(ctx, "user")
+	assert.Equal(t, nil, err)
+
+	cancel()
+	_, ok := <-sub
+	assert.Equal(t, false, ok)
+}
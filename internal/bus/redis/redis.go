@@ -0,0 +1,85 @@
+package redis
+
+import (
+	"context"
+	"encoding/json"
+
+	goredis "github.com/go-redis/redis/v7"
+	"github.com/pkg/errors"
+	"smscp.xyz/internal/bus"
+)
+
+const channelPrefix = "smscp:notes:"
+
+// Bus relays events through redis pub/sub so every server instance (i.e.
+// every serverless invocation of pkg/handler) sees every event.
+type Bus struct {
+	client *goredis.Client
+}
+
+func Default(url string) (Bus, error) {
+	opts, err := goredis.ParseURL(url)
+	if err != nil {
+		return Bus{}, errors.Wrap(err, "invalid redis url")
+	}
+	return Bus{goredis.NewClient(opts)}, nil
+}
+
+func (b Bus) Publish(ctx context.Context, event bus.Event) error {
+	byt, err := json.Marshal(event)
+	if err != nil {
+		return errors.Wrap(err, "failed to encode event")
+	}
+
+	if err := b.client.WithContext(ctx).Publish(channelPrefix+event.UserID, byt).Err(); err != nil {
+		return errors.Wrap(err, "failed to publish event")
+	}
+
+	return nil
+}
+
+func (b Bus) Subscribe(ctx context.Context, userID string) (<-chan bus.Event, error) {
+	pubsub := b.client.WithContext(ctx).Subscribe(channelPrefix + userID)
+
+	// Wait for the subscription to be confirmed so that no event published
+	// after Subscribe returns is missed.
+	if _, err := pubsub.Receive(); err != nil {
+		pubsub.Close()
+		return nil, errors.Wrap(err, "failed to subscribe to events")
+	}
+
+	sub := make(chan bus.Event, 16)
+	msgs := pubsub.Channel()
+
+	go func() {
+		defer close(sub)
+		defer pubsub.Close()
+
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case msg, ok := <-msgs:
+				if !ok {
+					return
+				}
+
+				var event bus.Event
+				if err := json.Unmarshal([]byte(msg.Payload), &event); err != nil {
+					continue // not ours; ignore
+				}
+
+				select {
+				case sub <- event:
+				default: // slow subscriber; drop rather than block redis
+				}
+			}
+		}
+	}()
+
+	return sub, nil
+}
+
+func (b Bus) Close() error {
+	return b.client.Close()
+}
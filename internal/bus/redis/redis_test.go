@@ -0,0 +1,58 @@
+package redis_test
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	"gopkg.in/go-playground/assert.v1"
+	"smscp.xyz/internal/bus"
+	"smscp.xyz/internal/bus/redis"
+)
+
+// Two buses sharing one redis stand in for two server instances.
+func TestFanOutAcrossInstances(t *testing.T) {
+	server, err := miniredis.Run()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer server.Close()
+
+	publisher, err := redis.Default("redis://" + server.Addr())
+	assert.Equal(t, nil, err)
+	defer publisher.Close()
+
+	subscriber, err := redis.Default("redis://" + server.Addr())
+	assert.Equal(t, nil, err)
+	defer subscriber.Close()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	sub, err := subscriber.Subscribe(ctx, "user")
+	assert.Equal(t, nil, err)
+	other, err := subscriber.Subscribe(ctx, "other")
+	assert.Equal(t, nil, err)
+
+	event := bus.Event{Type: bus.NoteDeleted, UserID: "user", NoteID: "note"}
+	assert.Equal(t, nil, publisher.Publish(ctx, event))
+
+	select {
+	case got := <-sub:
+		assert.Equal(t, event, got)
+	case <-time.After(time.Second):
+		t.Fatal("event never delivered")
+	}
+
+	select {
+	case <-other:
+		t.Fatal("event delivered to another user")
+	case <-time.After(50 * time.Millisecond):
+	}
+}
+
+func TestBadURL(t *testing.T) {
+	_, err := redis.Default("not a url")
+	assert.NotEqual(t, nil, err)
+}
@@ -0,0 +1,51 @@
+// Package clientip finds the address of the client behind a request. gin's
+// ClientIP believes X-Forwarded-For and X-Real-Ip from anyone, which would let
+// a client pick a fresh address for every attempt past the rate limits.
+package clientip
+
+import (
+	"net"
+	"net/http"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+)
+
+const contextKey = "smscp.clientip"
+
+// Middleware resolves the client's address once per request for Get. header
+// names the one a trusted proxy in front of us sets, i.e. X-Forwarded-For; it
+// is ignored when empty, as anyone can send it.
+func Middleware(header string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		c.Set(contextKey, FromRequest(c.Request, header))
+		c.Next()
+	}
+}
+
+// Get returns the address Middleware resolved, or the connection's address
+// when it didn't run.
+func Get(c *gin.Context) string {
+	if ip, ok := c.Get(contextKey); ok {
+		return ip.(string)
+	}
+	return FromRequest(c.Request, "")
+}
+
+// FromRequest takes the last address in header, the one our proxy appended,
+// falling back to the connection's address when header is empty, missing or
+// not an address.
+func FromRequest(r *http.Request, header string) string {
+	if header != "" {
+		values := strings.Split(r.Header.Get(header), ",")
+		if ip := net.ParseIP(strings.TrimSpace(values[len(values)-1])); ip != nil {
+			return ip.String()
+		}
+	}
+
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
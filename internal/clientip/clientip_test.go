@@ -0,0 +1,28 @@
+package clientip
+
+import (
+	"net/http"
+	"testing"
+
+	"gopkg.in/go-playground/assert.v1"
+)
+
+func TestFromRequest(t *testing.T) {
+	req, _ := http.NewRequest("GET", "/", nil)
+	req.RemoteAddr = "10.0.0.1:5555"
+	req.Header.Set("X-Forwarded-For", "203.0.113.7, 198.51.100.2")
+	req.Header.Set("X-Real-Ip", "203.0.113.8")
+
+	// Untrusted headers are ignored.
+	assert.Equal(t, "10.0.0.1", FromRequest(req, ""))
+	// Only the proxy's own entry counts; the client wrote the ones before it.
+	assert.Equal(t, "198.51.100.2", FromRequest(req, "X-Forwarded-For"))
+	assert.Equal(t, "203.0.113.8", FromRequest(req, "X-Real-Ip"))
+	assert.Equal(t, "10.0.0.1", FromRequest(req, "Fly-Client-IP"))
+
+	req.Header.Set("X-Forwarded-For", "not an ip")
+	assert.Equal(t, "10.0.0.1", FromRequest(req, "X-Forwarded-For"))
+
+	req.RemoteAddr = "[2001:db8::1]:5555"
+	assert.Equal(t, "2001:db8::1", FromRequest(req, ""))
+}
@@ -1,15 +1,25 @@
 package common
 
-import "context"
+import (
+	"context"
+	"time"
+)
 
 type User interface {
 	ID() string
 	Username() string
 	Phone() string
 	Token() string /* Stored in session, secret, unique per session. */
+	CreatedAt() time.Time
+	Admin() bool /* May use /admin. */
 	SetUsername(string)
 	SetPass(string)
 	SetPhone(string)
+	Unlock() /* Clears failed logins; only after proving phone ownership. */
+	Retention() Retention
+	SetRetention(Retention)
+	DeleteAt() time.Time /* Zero unless the account is due to be purged. */
+	SetDeleteAt(time.Time)
 	Save(context.Context) error
 }
 
@@ -18,4 +28,92 @@ type Note interface {
 	Short() string
 	Text() string
 	Token() string /* Unique per note (i.e. like an ID), only let author see. */
+	CreatedAt() time.Time
+	Encrypted() bool      /* Text is ciphertext only the author's client can open. */
+	ExpiresAt() time.Time /* Zero when the note is kept until deleted. */
+	Burn() bool           /* Deleted once read by latest or get. */
 }
+
+// Retention is how much of a user's history is kept; notes beyond it are
+// purged on a schedule. The zero value keeps everything.
+type Retention struct {
+	Days int /* Delete notes older than this many days; zero for any age. */
+	Keep int /* Delete all but the newest this many notes; zero for any number. */
+}
+
+// NoteOptions are chosen when a note is created.
+type NoteOptions struct {
+	Encrypted bool          /* Text is ciphertext sealed with pkg/e2e. */
+	TTL       time.Duration /* Zero keeps the note until deleted. */
+	Burn      bool
+}
+
+// ImportedNote is a note loaded from a file, e.g. a restored export, rather
+// than sent.
+type ImportedNote struct {
+	Text      string
+	CreatedAt time.Time /* Zero means now. */
+	ExpiresAt time.Time /* Zero keeps the note until deleted. */
+	Encrypted bool
+	Burn      bool
+}
+
+// Account is a user as operators see them in /admin and smscp-admin.
+type Account struct {
+	ID, Username, Phone string
+	CreatedAt           time.Time
+	Admin               bool
+	FailedLogins        int
+	LockedAt            time.Time /* Zero unless locked, by failed logins or an operator. */
+	LockedByAdmin       bool      /* Only an operator unlocks it; a password reset doesn't. */
+	DeleteAt            time.Time
+	Notes               int            /* Including expired notes not yet swept. */
+	SMSSent             map[string]int /* Texts sent per UTC day, keyed 2006-01-02; see SMSDays. */
+}
+
+// SMSDays is how many days of texts Account.SMSSent keeps.
+const SMSDays = 30
+
+// SMSSince counts the texts sent on or after the UTC day of t.
+func (a Account) SMSSince(t time.Time) int {
+	from := t.UTC().Format("2006-01-02")
+	n := 0
+	for day, sent := range a.SMSSent {
+		if day >= from {
+			n += sent
+		}
+	}
+	return n
+}
+
+// AuditEvent records a security relevant thing done on an account, and where
+// it was done from. Events are only ever appended.
+type AuditEvent struct {
+	Type      AuditType
+	UserID    string /* Empty for a failed login naming no account. */
+	Username  string /* At the time, or as typed for a failed login. */
+	IP        string /* Empty when done by the server itself. */
+	UserAgent string
+	Detail    string /* I.e. the export format or where a token went. */
+	Actor     string /* The operator who acted on the account; empty when the user did. */
+	At        time.Time
+}
+
+type AuditType string
+
+const (
+	AuditLogin             AuditType = "login"
+	AuditLoginFailed       AuditType = "login_failed"
+	AuditTokenCreated      AuditType = "token_created" /* An api or cli token, the closest thing to an API key. */
+	AuditPasswordChanged   AuditType = "password_changed"
+	AuditPasswordResetSent AuditType = "password_reset_sent"
+	AuditPasswordReset     AuditType = "password_reset"
+	AuditPhoneChanged      AuditType = "phone_changed"
+	AuditExported          AuditType = "gdpr_export"
+	AuditDeleteRequested   AuditType = "deletion_requested"
+	AuditDeleteCancelled   AuditType = "deletion_cancelled"
+	AuditDeleted           AuditType = "deleted"
+	AuditLocked            AuditType = "account_locked" /* By an operator; see Actor. */
+	AuditUnlocked          AuditType = "account_unlocked"
+	AuditSessionsEnded     AuditType = "sessions_ended"
+)
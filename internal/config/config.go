@@ -0,0 +1,301 @@
+// Package config loads the server's settings. Each setting is named by its
+// environment variable, i.e. JWT_SECRET, and can also be given lower cased in
+// a YAML or TOML file (jwt_secret) or as a flag (-jwt-secret). Flags override
+// the environment, which overrides the file, which overrides the defaults.
+package config
+
+import (
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+	"smscp.xyz/internal/envelope"
+	"smscp.xyz/internal/ratelimit"
+	"smscp.xyz/pkg/mode"
+)
+
+// FileEnv names the variable holding the path of a config file; the -config
+// flag overrides it.
+const FileEnv = "CONFIG_FILE"
+
+type Config struct {
+	Port             string
+	ProjectID        string
+	SessionSecret    string
+	SessionName      string
+	JWTSecret        string
+	MasterKeys       string
+	LoginMaxFailures int
+	TwilioID         string
+	TwilioSecret     string
+	TwilioFrom       string
+	RateLimits       string
+	RedisURL         string
+	BaseURL          string
+	ClientIPHeader   string
+	PurgeDryRun      bool
+	ShutdownTimeout  time.Duration
+	MetricsToken     string
+	TraceExporter    string
+	OTLPEndpoint     string
+}
+
+func Default() Config {
+	return Config{
+		Port:             "8080",
+		SessionName:      "smscp",
+		LoginMaxFailures: 5,
+		ShutdownTimeout:  30 * time.Second,
+		TraceExporter:    "none",
+	}
+}
+
+// setting describes one field of Config; value points at it and is a *string,
+// *int, *bool or *time.Duration.
+type setting struct {
+	env    string
+	usage  string
+	secret bool
+	value  interface{}
+}
+
+func (cfg *Config) settings() []setting {
+	return []setting{
+		{"PORT", "port the server listens on", false, &cfg.Port},
+		{"GOOGLE_PROJECT_ID", "firestore project", false, &cfg.ProjectID},
+		{"SESSION_SECRET", "key signing session cookies", true, &cfg.SessionSecret},
+		{"SESSION_NAME", "session cookie name", false, &cfg.SessionName},
+		{"JWT_SECRET", "key signing user and note tokens", true, &cfg.JWTSecret},
+		{"MASTER_KEYS", "id:base64key,... master keys encrypting notes at rest; the first is current", true, &cfg.MasterKeys},
+		{"LOGIN_MAX_FAILURES", "wrong passwords in a row that lock an account; 0 never locks", false, &cfg.LoginMaxFailures},
+		{"TWILIO_ID", "twilio account sid", false, &cfg.TwilioID},
+		{"TWILIO_SECRET", "twilio auth token", true, &cfg.TwilioSecret},
+		{"TWILIO_FROM", "number texts are sent from", false, &cfg.TwilioFrom},
+		{"RATE_LIMITS", "overrides of the default rate limits, i.e. login_ip=10/1m,sms_daily=50/24h", false, &cfg.RateLimits},
+		{"REDIS_URL", "redis shared by instances for live updates and rate limits; memory when empty", true, &cfg.RedisURL},
+		{"BASE_URL", "public url used in texts and links", false, &cfg.BaseURL},
+		{"CLIENT_IP_HEADER", "header our proxy sets to the client ip, i.e. X-Forwarded-For; unset uses the connection's address", false, &cfg.ClientIPHeader},
+		{"PURGE_DRY_RUN", "only log what retention would purge", false, &cfg.PurgeDryRun},
+		{"METRICS_TOKEN", "bearer token for /metrics; unset serves none", true, &cfg.MetricsToken},
+		{"TRACE_EXPORTER", "where spans go: none, stdout or otlp", false, &cfg.TraceExporter},
+		{"OTLP_ENDPOINT", "collector receiving spans over OTLP/HTTP, i.e. http://localhost:4318", false, &cfg.OTLPEndpoint},
+		{"SHUTDOWN_TIMEOUT", "how long to drain requests and jobs on SIGTERM, i.e. 30s", false, &cfg.ShutdownTimeout},
+	}
+}
+
+func (s setting) set(raw string) error {
+	switch v := s.value.(type) {
+	case *string:
+		*v = raw
+	case *int:
+		n, err := strconv.Atoi(strings.TrimSpace(raw))
+		if err != nil {
+			return fmt.Errorf("invalid %s %q; want a number", s.env, raw)
+		}
+		*v = n
+	case *bool:
+		if strings.TrimSpace(raw) == "" {
+			*v = false
+			return nil
+		}
+		b, err := strconv.ParseBool(strings.TrimSpace(raw))
+		if err != nil {
+			return fmt.Errorf("invalid %s %q; want true or false", s.env, raw)
+		}
+		*v = b
+	case *time.Duration:
+		d, err := time.ParseDuration(strings.TrimSpace(raw))
+		if err != nil {
+			return fmt.Errorf("invalid %s %q; want a duration like 30s", s.env, raw)
+		}
+		*v = d
+	}
+	return nil
+}
+
+func (s setting) flag() string { return strings.Replace(strings.ToLower(s.env), "_", "-", -1) }
+
+func (s setting) key() string { return strings.ToLower(s.env) }
+
+// rawFlag remembers the text of a flag so flags can be applied last.
+type rawFlag struct {
+	set   bool
+	value string
+}
+
+func (f *rawFlag) String() string { return f.value }
+
+func (f *rawFlag) Set(v string) error {
+	f.set, f.value = true, v
+	return nil
+}
+
+// Load reads the settings from the file, environment and args, and validates
+// them for m.
+func Load(m mode.Mode, args []string) (Config, error) {
+	cfg := Default()
+	settings := cfg.settings()
+
+	flags := flag.NewFlagSet("smscp", flag.ContinueOnError)
+	file := flags.String("config", os.Getenv(FileEnv), "YAML or TOML config file")
+	raw := make([]rawFlag, len(settings))
+	for i, s := range settings {
+		flags.Var(&raw[i], s.flag(), s.usage)
+	}
+	if err := flags.Parse(args); err != nil {
+		return Config{}, err
+	}
+
+	if *file != "" {
+		values, err := readFile(*file)
+		if err != nil {
+			return Config{}, err
+		}
+
+		known := map[string]setting{}
+		for _, s := range settings {
+			known[s.key()] = s
+		}
+		for key, value := range values {
+			s, ok := known[key]
+			if !ok {
+				return Config{}, fmt.Errorf("unknown setting %q in %s", key, *file)
+			}
+			if err := s.set(value); err != nil {
+				return Config{}, err
+			}
+		}
+	}
+
+	// Empty variables count as unset, as deploy tooling often defines them all.
+	for _, s := range settings {
+		if value := os.Getenv(s.env); value != "" {
+			if err := s.set(value); err != nil {
+				return Config{}, err
+			}
+		}
+	}
+
+	for i, s := range settings {
+		if raw[i].set {
+			if err := s.set(raw[i].value); err != nil {
+				return Config{}, err
+			}
+		}
+	}
+
+	return cfg, cfg.Validate(m)
+}
+
+// Validate checks the settings parse, and that those m needs are set. Only
+// prod requires secrets; dev and test run against local fakes and emulators.
+func (cfg Config) Validate(m mode.Mode) error {
+	var missing []string
+	require := func(env, value string) {
+		if value == "" {
+			missing = append(missing, env)
+		}
+	}
+
+	if m != mode.Test {
+		require("GOOGLE_PROJECT_ID", cfg.ProjectID)
+	}
+	if m == mode.Prod {
+		require("SESSION_SECRET", cfg.SessionSecret)
+		require("JWT_SECRET", cfg.JWTSecret)
+		require("MASTER_KEYS", cfg.MasterKeys)
+		require("TWILIO_ID", cfg.TwilioID)
+		require("TWILIO_SECRET", cfg.TwilioSecret)
+		require("TWILIO_FROM", cfg.TwilioFrom)
+	}
+	require("PORT", cfg.Port)
+	require("SESSION_NAME", cfg.SessionName)
+	if len(missing) > 0 {
+		return fmt.Errorf("missing %s; required in %s", strings.Join(missing, ", "), m)
+	}
+
+	if cfg.MasterKeys != "" {
+		if _, err := envelope.Parse(cfg.MasterKeys); err != nil {
+			return errors.Wrap(err, "invalid MASTER_KEYS")
+		}
+	}
+	if cfg.ShutdownTimeout <= 0 {
+		return errors.New("invalid SHUTDOWN_TIMEOUT; must be positive")
+	}
+	if cfg.LoginMaxFailures < 0 {
+		return errors.New("invalid LOGIN_MAX_FAILURES; must not be negative")
+	}
+	if _, err := ratelimit.ParseLimits(cfg.RateLimits); err != nil {
+		return errors.Wrap(err, "invalid RATE_LIMITS")
+	}
+	switch cfg.TraceExporter {
+	case "none", "stdout":
+	case "otlp":
+		if u, err := url.Parse(cfg.OTLPEndpoint); err != nil || u.Scheme == "" || u.Host == "" {
+			return fmt.Errorf("invalid OTLP_ENDPOINT %q; want an absolute url", cfg.OTLPEndpoint)
+		}
+	default:
+		return fmt.Errorf("invalid TRACE_EXPORTER %q; want none, stdout or otlp", cfg.TraceExporter)
+	}
+	if cfg.BaseURL != "" {
+		if u, err := url.Parse(cfg.BaseURL); err != nil || u.Scheme == "" || u.Host == "" {
+			return fmt.Errorf("invalid BASE_URL %q; want an absolute url", cfg.BaseURL)
+		}
+	}
+
+	return nil
+}
+
+// String lists every setting, one per line, with secrets redacted.
+func (cfg Config) String() string {
+	var b strings.Builder
+	for _, s := range cfg.settings() {
+		value := s.String()
+		if s.secret && value != "" {
+			value = "[redacted]"
+		}
+		fmt.Fprintf(&b, "%s=%s\n", s.env, value)
+	}
+	return b.String()
+}
+
+func (s setting) String() string {
+	switch v := s.value.(type) {
+	case *string:
+		return *v
+	case *int:
+		return strconv.Itoa(*v)
+	case *bool:
+		return strconv.FormatBool(*v)
+	case *time.Duration:
+		return v.String()
+	}
+	return ""
+}
+
+// readFile returns the settings in a config file, choosing the format by its
+// extension.
+func readFile(path string) (map[string]string, error) {
+	byt, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to read config file")
+	}
+
+	switch ext := strings.ToLower(filepath.Ext(path)); ext {
+	case ".yaml", ".yml":
+		values, err := parseYAML(byt)
+		return values, errors.Wrapf(err, "invalid config file %s", path)
+	case ".toml":
+		values, err := parseTOML(byt)
+		return values, errors.Wrapf(err, "invalid config file %s", path)
+	default:
+		return nil, fmt.Errorf("unknown config file type %q; want .yaml, .yml or .toml", ext)
+	}
+}
@@ -0,0 +1,128 @@
+package config
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"gopkg.in/go-playground/assert.v1"
+	"smscp.xyz/pkg/mode"
+)
+
+// setenv returns a func restoring the variable.
+func setenv(key, value string) func() {
+	old, ok := os.LookupEnv(key)
+	os.Setenv(key, value)
+	return func() {
+		if ok {
+			os.Setenv(key, old)
+		} else {
+			os.Unsetenv(key)
+		}
+	}
+}
+
+func tempDir(t *testing.T) string {
+	dir, err := ioutil.TempDir("", "config")
+	if err != nil {
+		t.Fatal(err)
+	}
+	return dir
+}
+
+func writeFile(t *testing.T, dir, name, body string) string {
+	path := filepath.Join(dir, name)
+	if err := ioutil.WriteFile(path, []byte(body), 0600); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestLoadPrecedence(t *testing.T) {
+	dir := tempDir(t)
+	defer os.RemoveAll(dir)
+	defer setenv("BASE_URL", "https://env.example")()
+	defer setenv("SESSION_NAME", "")()
+
+	path := writeFile(t, dir, "smscp.yaml", "session_name: file\nbase_url: https://file.example\nlogin_max_failures: 3\n")
+
+	cfg, err := Load(mode.Test, []string{"-config", path, "-login-max-failures", "7", "-purge-dry-run", "true", "-shutdown-timeout", "5s"})
+	assert.Equal(t, nil, err)
+	assert.Equal(t, "file", cfg.SessionName)
+	assert.Equal(t, "https://env.example", cfg.BaseURL)
+	assert.Equal(t, 7, cfg.LoginMaxFailures)
+	assert.Equal(t, true, cfg.PurgeDryRun)
+	assert.Equal(t, 5*time.Second, cfg.ShutdownTimeout)
+}
+
+func TestLoadTOML(t *testing.T) {
+	dir := tempDir(t)
+	defer os.RemoveAll(dir)
+
+	path := writeFile(t, dir, "smscp.toml", `# comment
+jwt_secret = "a \"quoted\" secret" # trailing
+twilio_from = '+12085550100'
+login_max_failures = 10
+purge_dry_run = true
+twilio_id = """
+AC123"""
+rate_limits = 'login_ip=10/1m'
+`)
+
+	cfg, err := Load(mode.Test, []string{"-config", path})
+	assert.Equal(t, nil, err)
+	assert.Equal(t, `a "quoted" secret`, cfg.JWTSecret)
+	assert.Equal(t, "+12085550100", cfg.TwilioFrom)
+	assert.Equal(t, 10, cfg.LoginMaxFailures)
+	assert.Equal(t, true, cfg.PurgeDryRun)
+	assert.Equal(t, "AC123", cfg.TwilioID)
+	assert.Equal(t, "login_ip=10/1m", cfg.RateLimits)
+
+	for _, body := range []string{"[server]\n", "jwt_secret = \"open\n", "jwt_secret = [1]\n", "unknown = 1\n", "a = 1\na = 2\n", "[server]\nport = \"80\"\n"} {
+		_, err := Load(mode.Test, []string{"-config", writeFile(t, dir, "bad.toml", body)})
+		assert.NotEqual(t, nil, err)
+	}
+}
+
+func TestValidate(t *testing.T) {
+	cfg := Default()
+	assert.Equal(t, nil, cfg.Validate(mode.Test))
+	assert.NotEqual(t, nil, cfg.Validate(mode.Dev))
+
+	err := cfg.Validate(mode.Prod)
+	assert.NotEqual(t, nil, err)
+	assert.Equal(t, true, strings.Contains(err.Error(), "JWT_SECRET"))
+	assert.Equal(t, true, strings.Contains(err.Error(), "required in prod"))
+
+	cfg.ProjectID = "project"
+	assert.Equal(t, nil, cfg.Validate(mode.Dev))
+
+	for _, bad := range []func(*Config){
+		func(cfg *Config) { cfg.MasterKeys = "nokey" },
+		func(cfg *Config) { cfg.LoginMaxFailures = -1 },
+		func(cfg *Config) { cfg.RateLimits = "login_ip=fast" },
+		func(cfg *Config) { cfg.BaseURL = "smscp.xyz" },
+		func(cfg *Config) { cfg.ShutdownTimeout = 0 },
+		func(cfg *Config) { cfg.TraceExporter = "jaeger" },
+		func(cfg *Config) { cfg.TraceExporter = "otlp" },
+	} {
+		invalid := cfg
+		bad(&invalid)
+		assert.NotEqual(t, nil, invalid.Validate(mode.Dev))
+	}
+}
+
+func TestStringRedactsSecrets(t *testing.T) {
+	cfg := Default()
+	cfg.JWTSecret = "hunter2"
+	cfg.TwilioID = "AC123"
+
+	out := cfg.String()
+	assert.Equal(t, false, strings.Contains(out, "hunter2"))
+	assert.Equal(t, true, strings.Contains(out, "JWT_SECRET=[redacted]\n"))
+	assert.Equal(t, true, strings.Contains(out, "TWILIO_ID=AC123\n"))
+	assert.Equal(t, true, strings.Contains(out, "SESSION_SECRET=\n"))
+}
@@ -0,0 +1,45 @@
+package config
+
+import (
+	"fmt"
+
+	"github.com/BurntSushi/toml"
+	"github.com/pkg/errors"
+	"gopkg.in/yaml.v2"
+)
+
+// parseYAML reads a mapping of settings to scalars.
+func parseYAML(byt []byte) (map[string]string, error) {
+	var doc map[string]interface{}
+	if err := yaml.Unmarshal(byt, &doc); err != nil {
+		return nil, errors.Wrap(err, "failed to parse yaml")
+	}
+	return scalars(doc)
+}
+
+// parseTOML reads a document of settings to scalars. Settings are flat, so
+// tables and arrays are rejected.
+func parseTOML(byt []byte) (map[string]string, error) {
+	var doc map[string]interface{}
+	if err := toml.Unmarshal(byt, &doc); err != nil {
+		return nil, errors.Wrap(err, "failed to parse toml")
+	}
+	return scalars(doc)
+}
+
+// scalars turns a decoded file into text for setting.set, which parses it
+// the same way as the environment.
+func scalars(doc map[string]interface{}) (map[string]string, error) {
+	values := map[string]string{}
+	for key, value := range doc {
+		switch v := value.(type) {
+		case nil:
+			values[key] = ""
+		case string, int, int64, bool, float64:
+			values[key] = fmt.Sprint(v)
+		default:
+			return nil, fmt.Errorf("%s must be a string, number or boolean", key)
+		}
+	}
+	return values, nil
+}
@@ -0,0 +1,181 @@
+// Package envelope encrypts values at rest with per record data keys. Each
+// data key is itself encrypted ("wrapped") by a master key from configuration,
+// so changing the master key only means re-wrapping data keys, not
+// re-encrypting every value.
+package envelope
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/base64"
+	"fmt"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+const keySize = 32 /* AES-256 */
+
+// Keyring holds the master keys by ID. New data keys are always wrapped with
+// the current key; the others are kept only to unwrap what they wrapped until
+// rotated.
+type Keyring struct {
+	current string
+	keys    map[string][]byte
+}
+
+// Parse reads master keys written as `id:base64key,id:base64key`. The first key
+// listed is current.
+func Parse(raw string) (*Keyring, error) {
+	ring := &Keyring{keys: map[string][]byte{}}
+	for _, item := range strings.Split(raw, ",") {
+		item = strings.TrimSpace(item)
+		if item == "" {
+			continue
+		}
+
+		parts := strings.SplitN(item, ":", 2)
+		if len(parts) != 2 || parts[0] == "" {
+			return nil, errors.New("invalid master key; want id:base64key")
+		}
+		id := parts[0]
+
+		key, err := base64.StdEncoding.DecodeString(parts[1])
+		if err != nil {
+			return nil, errors.Wrapf(err, "invalid master key %q; not base64", id)
+		}
+		if len(key) != keySize {
+			return nil, fmt.Errorf("invalid master key %q; want %d bytes, got %d", id, keySize, len(key))
+		}
+		if _, ok := ring.keys[id]; ok {
+			return nil, fmt.Errorf("invalid master keys; %q listed twice", id)
+		}
+
+		if ring.current == "" {
+			ring.current = id
+		}
+		ring.keys[id] = key
+	}
+
+	if ring.current == "" {
+		return nil, errors.New("no master keys")
+	}
+
+	return ring, nil
+}
+
+// Current is the ID of the master key new data keys are wrapped with.
+func (ring *Keyring) Current() string { return ring.current }
+
+// DataKey encrypts the values of one record.
+type DataKey struct {
+	key     []byte
+	Wrapped string /* The key encrypted by master key KeyID, safe to store. */
+	KeyID   string
+}
+
+// NewDataKey creates a data key for the record named by aad, which must be
+// given again to unwrap it.
+func (ring *Keyring) NewDataKey(aad string) (DataKey, error) {
+	key := make([]byte, keySize)
+	if _, err := rand.Read(key); err != nil {
+		return DataKey{}, errors.Wrap(err, "failed to create data key")
+	}
+
+	wrapped, err := seal(ring.keys[ring.current], key, ring.current+"/"+aad)
+	if err != nil {
+		return DataKey{}, errors.Wrap(err, "failed to wrap data key")
+	}
+
+	return DataKey{key, wrapped, ring.current}, nil
+}
+
+// Unwrap recovers a stored data key.
+func (ring *Keyring) Unwrap(keyID, wrapped, aad string) (DataKey, error) {
+	master, ok := ring.keys[keyID]
+	if !ok {
+		return DataKey{}, fmt.Errorf("failed to unwrap data key; no master key %q", keyID)
+	}
+
+	key, err := open(master, wrapped, keyID+"/"+aad)
+	if err != nil {
+		return DataKey{}, errors.Wrap(err, "failed to unwrap data key")
+	}
+
+	return DataKey{key, wrapped, keyID}, nil
+}
+
+// Rewrap wraps a stored data key with the current master key. Values the data
+// key encrypted are untouched.
+func (ring *Keyring) Rewrap(keyID, wrapped, aad string) (DataKey, error) {
+	dk, err := ring.Unwrap(keyID, wrapped, aad)
+	if err != nil {
+		return DataKey{}, err
+	}
+	if keyID == ring.current {
+		return dk, nil
+	}
+
+	wrapped, err = seal(ring.keys[ring.current], dk.key, ring.current+"/"+aad)
+	if err != nil {
+		return DataKey{}, errors.Wrap(err, "failed to wrap data key")
+	}
+
+	return DataKey{dk.key, wrapped, ring.current}, nil
+}
+
+// Seal encrypts a value; aad names the field so values can't be swapped.
+func (dk DataKey) Seal(plaintext, aad string) (string, error) {
+	return seal(dk.key, []byte(plaintext), aad)
+}
+
+func (dk DataKey) Open(ciphertext, aad string) (string, error) {
+	byt, err := open(dk.key, ciphertext, aad)
+	return string(byt), err
+}
+
+// seal is AES-256-GCM, returning base64 of nonce then ciphertext.
+func seal(key, plaintext []byte, aad string) (string, error) {
+	aead, err := gcm(key)
+	if err != nil {
+		return "", err
+	}
+
+	nonce := make([]byte, aead.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return "", errors.Wrap(err, "failed to create nonce")
+	}
+
+	return base64.StdEncoding.EncodeToString(aead.Seal(nonce, nonce, plaintext, []byte(aad))), nil
+}
+
+func open(key []byte, ciphertext, aad string) ([]byte, error) {
+	aead, err := gcm(key)
+	if err != nil {
+		return nil, err
+	}
+
+	byt, err := base64.StdEncoding.DecodeString(ciphertext)
+	if err != nil {
+		return nil, errors.Wrap(err, "ciphertext corrupted")
+	}
+	if len(byt) < aead.NonceSize() {
+		return nil, errors.New("ciphertext corrupted; too short")
+	}
+
+	plaintext, err := aead.Open(nil, byt[:aead.NonceSize()], byt[aead.NonceSize():], []byte(aad))
+	if err != nil {
+		return nil, errors.New("failed to decrypt; wrong key or ciphertext corrupted")
+	}
+
+	return plaintext, nil
+}
+
+func gcm(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to create cipher")
+	}
+	return cipher.NewGCM(block)
+}
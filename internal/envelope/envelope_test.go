@@ -0,0 +1,70 @@
+package envelope
+
+import (
+	"encoding/base64"
+	"strings"
+	"testing"
+
+	"gopkg.in/go-playground/assert.v1"
+)
+
+func key(b byte) string {
+	return base64.StdEncoding.EncodeToString([]byte(strings.Repeat(string(b), keySize)))
+}
+
+func TestParse(t *testing.T) {
+	ring, err := Parse("2:" + key('b') + ", 1:" + key('a'))
+	assert.Equal(t, nil, err)
+	assert.Equal(t, "2", ring.Current())
+
+	for _, raw := range []string{"", "nokey", "1:not base64!", "1:" + base64.StdEncoding.EncodeToString([]byte("short")), "1:" + key('a') + ",1:" + key('b')} {
+		_, err := Parse(raw)
+		assert.NotEqual(t, nil, err)
+	}
+}
+
+func TestSealOpen(t *testing.T) {
+	ring, _ := Parse("1:" + key('a'))
+
+	dk, err := ring.NewDataKey("note-1")
+	assert.Equal(t, nil, err)
+	assert.Equal(t, "1", dk.KeyID)
+
+	ciphertext, err := dk.Seal("secret", "text")
+	assert.Equal(t, nil, err)
+	assert.Equal(t, false, strings.Contains(ciphertext, "secret"))
+
+	stored, err := ring.Unwrap(dk.KeyID, dk.Wrapped, "note-1")
+	assert.Equal(t, nil, err)
+	text, err := stored.Open(ciphertext, "text")
+	assert.Equal(t, nil, err)
+	assert.Equal(t, "secret", text)
+
+	// Bound to the record and field they were made for.
+	_, err = ring.Unwrap(dk.KeyID, dk.Wrapped, "note-2")
+	assert.NotEqual(t, nil, err)
+	_, err = stored.Open(ciphertext, "short")
+	assert.NotEqual(t, nil, err)
+}
+
+func TestRewrap(t *testing.T) {
+	old, _ := Parse("1:" + key('a'))
+	dk, _ := old.NewDataKey("note-1")
+	ciphertext, _ := dk.Seal("secret", "text")
+
+	ring, _ := Parse("2:" + key('b') + ",1:" + key('a'))
+	rewrapped, err := ring.Rewrap(dk.KeyID, dk.Wrapped, "note-1")
+	assert.Equal(t, nil, err)
+	assert.Equal(t, "2", rewrapped.KeyID)
+
+	// The old master key can now be dropped.
+	rotated, _ := Parse("2:" + key('b'))
+	stored, err := rotated.Unwrap(rewrapped.KeyID, rewrapped.Wrapped, "note-1")
+	assert.Equal(t, nil, err)
+	text, err := stored.Open(ciphertext, "text")
+	assert.Equal(t, nil, err)
+	assert.Equal(t, "secret", text)
+
+	_, err = rotated.Unwrap(dk.KeyID, dk.Wrapped, "note-1")
+	assert.NotEqual(t, nil, err)
+}
@@ -0,0 +1,89 @@
+package export
+
+import (
+	stdcsv "encoding/csv"
+	"fmt"
+	"io"
+	"time"
+
+	"github.com/pkg/errors"
+	"smscp.xyz/internal/common"
+)
+
+// AuditEvent is one row of an audit export; see common.AuditEvent.
+type AuditEvent struct {
+	At        time.Time `json:"at"`
+	Type      string    `json:"type"`
+	UserID    string    `json:"user_id"`
+	Username  string    `json:"username"`
+	IP        string    `json:"ip"`
+	UserAgent string    `json:"user_agent"`
+	Detail    string    `json:"detail,omitempty"`
+	Actor     string    `json:"actor,omitempty"`
+}
+
+var auditCSVHeader = []string{"at", "type", "user_id", "username", "ip", "user_agent", "detail", "actor"}
+
+// AuditContentType is ContentType for WriteAudit, which writes JSON or CSV.
+func (e Export) AuditContentType(format string) (string, error) {
+	if format != JSON && format != CSV {
+		return "", unknownAuditFormat(format)
+	}
+	return e.ContentType(format)
+}
+
+// WriteAudit writes events to w in format, in the order given.
+func (e Export) WriteAudit(w io.Writer, format string, events []common.AuditEvent) error {
+	rows := make([]AuditEvent, 0, len(events))
+	for _, event := range events {
+		rows = append(rows, AuditEvent{
+			At:        event.At.UTC(),
+			Type:      string(event.Type),
+			UserID:    event.UserID,
+			Username:  event.Username,
+			IP:        event.IP,
+			UserAgent: event.UserAgent,
+			Detail:    event.Detail,
+			Actor:     event.Actor,
+		})
+	}
+
+	switch format {
+	case JSON:
+		return writeJSON(w, rows)
+	case CSV:
+		return writeAuditCSV(w, rows)
+	}
+	return unknownAuditFormat(format)
+}
+
+func unknownAuditFormat(format string) error {
+	return fmt.Errorf("unknown audit export format %q; want %s or %s", format, JSON, CSV)
+}
+
+func writeAuditCSV(w io.Writer, rows []AuditEvent) error {
+	writer := stdcsv.NewWriter(w)
+
+	if err := writer.Write(auditCSVHeader); err != nil {
+		return errors.Wrap(err, "failed to write header to csv")
+	}
+
+	for _, row := range rows {
+		record := []string{
+			row.At.Format(time.RFC3339),
+			row.Type,
+			row.UserID,
+			row.Username,
+			row.IP,
+			row.UserAgent,
+			row.Detail,
+			row.Actor,
+		}
+		if err := writer.Write(record); err != nil {
+			return errors.Wrap(err, "failed to write audit events to csv")
+		}
+	}
+
+	writer.Flush()
+	return errors.Wrap(writer.Error(), "failed to write audit events to csv")
+}
@@ -0,0 +1,51 @@
+package export
+
+import (
+	"bytes"
+	stdcsv "encoding/csv"
+	"encoding/json"
+	"testing"
+	"time"
+
+	"gopkg.in/go-playground/assert.v1"
+	"smscp.xyz/internal/common"
+)
+
+var events = []common.AuditEvent{
+	{Type: common.AuditLogin, UserID: "user-1", Username: "alice", IP: "10.0.0.1", UserAgent: "curl/7.64.1", Detail: "web", At: time.Unix(200, 0)},
+	{Type: common.AuditLoginFailed, Username: "mallory", IP: "10.0.0.2", UserAgent: "Mozilla/5.0 (X11, Linux)", At: time.Unix(100, 0)},
+	{Type: common.AuditLocked, UserID: "user-1", Username: "alice", Actor: "root", At: time.Unix(50, 0)},
+}
+
+func TestAuditCSV(t *testing.T) {
+	var buf bytes.Buffer
+	assert.Equal(t, nil, testExport().WriteAudit(&buf, CSV, events))
+
+	rows, err := stdcsv.NewReader(&buf).ReadAll()
+	assert.Equal(t, nil, err)
+	assert.Equal(t, 4, len(rows))
+	assert.Equal(t, auditCSVHeader, rows[0])
+	assert.Equal(t, []string{"1970-01-01T00:03:20Z", "login", "user-1", "alice", "10.0.0.1", "curl/7.64.1", "web", ""}, rows[1])
+	assert.Equal(t, "Mozilla/5.0 (X11, Linux)", rows[2][5])
+	assert.Equal(t, "root", rows[3][7])
+}
+
+func TestAuditJSON(t *testing.T) {
+	var buf bytes.Buffer
+	assert.Equal(t, nil, testExport().WriteAudit(&buf, JSON, events))
+
+	var rows []AuditEvent
+	assert.Equal(t, nil, json.Unmarshal(buf.Bytes(), &rows))
+	assert.Equal(t, 3, len(rows))
+	assert.Equal(t, "login_failed", rows[1].Type)
+	assert.Equal(t, "", rows[1].UserID)
+	assert.Equal(t, "root", rows[2].Actor)
+}
+
+func TestAuditUnknownFormat(t *testing.T) {
+	_, err := testExport().AuditContentType(ZIP)
+	assert.NotEqual(t, nil, err)
+	contentType, err := testExport().AuditContentType(CSV)
+	assert.Equal(t, nil, err)
+	assert.Equal(t, "text/csv", contentType)
+}
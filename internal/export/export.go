@@ -0,0 +1,219 @@
+// Package export writes everything stored about a user for them to take away.
+// The layout is versioned by SchemaVersion so archives can be read back (and
+// imported) after it changes.
+package export
+
+import (
+	"archive/zip"
+	stdcsv "encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"strconv"
+	"time"
+
+	"github.com/pkg/errors"
+	"smscp.xyz/internal/common"
+)
+
+// SchemaVersion changes whenever a file or field below changes meaning.
+const SchemaVersion = 1
+
+// Formats, as chosen with ?format= on /gdpr.
+const (
+	ZIP  = "zip"  /* manifest.json, user.json, notes.json and notes.csv */
+	JSON = "json" /* The same as one document. */
+	CSV  = "csv"  /* notes.csv alone. */
+)
+
+// Files in a ZIP archive.
+const (
+	ManifestFile = "manifest.json"
+	UserFile     = "user.json"
+	NotesJSON    = "notes.json"
+	NotesCSV     = "notes.csv"
+)
+
+type Manifest struct {
+	SchemaVersion int       `json:"schema_version"`
+	ExportedAt    time.Time `json:"exported_at"`
+	UserID        string    `json:"user_id"`
+	NoteCount     int       `json:"note_count"`
+	Files         []string  `json:"files,omitempty"`
+}
+
+type User struct {
+	ID        string    `json:"id"`
+	Username  string    `json:"username"`
+	Phone     string    `json:"phone"`
+	CreatedAt time.Time `json:"created_at"`
+	Retention Retention `json:"retention"`
+}
+
+type Retention struct {
+	Days int `json:"days,omitempty"`
+	Keep int `json:"keep,omitempty"`
+}
+
+type Note struct {
+	ID        string     `json:"id"`
+	Text      string     `json:"text"`
+	Encrypted bool       `json:"encrypted"` /* Text is ciphertext from pkg/e2e. */
+	Burn      bool       `json:"burn"`
+	ExpiresAt *time.Time `json:"expires_at,omitempty"`
+	CreatedAt time.Time  `json:"created_at"`
+}
+
+// Document is the JSON format, and what the ZIP format splits into files.
+type Document struct {
+	Manifest
+	User  User   `json:"user"`
+	Notes []Note `json:"notes"`
+}
+
+// csvHeader is the first row of notes.csv; times are RFC 3339, empty when
+// unset.
+var csvHeader = []string{"id", "created_at", "expires_at", "encrypted", "burn", "text"}
+
+type Export struct {
+	now func() time.Time
+}
+
+func Default() Export { return Export{time.Now} }
+
+// ContentType returns the media type of format, or an error for an unknown one.
+func (e Export) ContentType(format string) (string, error) {
+	switch format {
+	case ZIP:
+		return "application/zip", nil
+	case JSON:
+		return "application/json", nil
+	case CSV:
+		return "text/csv", nil
+	}
+	return "", unknownFormat(format)
+}
+
+// Write streams user and their notes to w in format. Nothing is buffered
+// beyond what the format needs, so a failure can leave w partly written.
+func (e Export) Write(w io.Writer, format string, user common.User, notes []common.Note) error {
+	doc := e.document(user, notes)
+
+	switch format {
+	case ZIP:
+		return writeZIP(w, doc)
+	case JSON:
+		return writeJSON(w, doc)
+	case CSV:
+		return writeCSV(w, doc.Notes)
+	}
+	return unknownFormat(format)
+}
+
+func unknownFormat(format string) error {
+	return fmt.Errorf("unknown export format %q; want %s, %s or %s", format, ZIP, JSON, CSV)
+}
+
+func (e Export) document(user common.User, notes []common.Note) Document {
+	retention := user.Retention()
+	doc := Document{
+		Manifest: Manifest{
+			SchemaVersion: SchemaVersion,
+			ExportedAt:    e.now().UTC(),
+			UserID:        user.ID(),
+			NoteCount:     len(notes),
+		},
+		User: User{
+			ID:        user.ID(),
+			Username:  user.Username(),
+			Phone:     user.Phone(),
+			CreatedAt: user.CreatedAt(),
+			Retention: Retention{retention.Days, retention.Keep},
+		},
+		Notes: make([]Note, 0, len(notes)),
+	}
+
+	for _, note := range notes {
+		record := Note{
+			ID:        note.ID(),
+			Text:      note.Text(),
+			Encrypted: note.Encrypted(),
+			Burn:      note.Burn(),
+			CreatedAt: note.CreatedAt(),
+		}
+		if at := note.ExpiresAt(); !at.IsZero() {
+			record.ExpiresAt = &at
+		}
+		doc.Notes = append(doc.Notes, record)
+	}
+
+	return doc
+}
+
+func writeZIP(w io.Writer, doc Document) error {
+	archive := zip.NewWriter(w)
+
+	manifest := doc.Manifest
+	manifest.Files = []string{UserFile, NotesJSON, NotesCSV}
+
+	files := []struct {
+		name  string
+		write func(io.Writer) error
+	}{
+		{ManifestFile, func(w io.Writer) error { return writeJSON(w, manifest) }},
+		{UserFile, func(w io.Writer) error { return writeJSON(w, doc.User) }},
+		{NotesJSON, func(w io.Writer) error { return writeJSON(w, doc.Notes) }},
+		{NotesCSV, func(w io.Writer) error { return writeCSV(w, doc.Notes) }},
+	}
+	for _, file := range files {
+		fw, err := archive.CreateHeader(&zip.FileHeader{
+			Name:     file.name,
+			Method:   zip.Deflate,
+			Modified: doc.ExportedAt,
+		})
+		if err != nil {
+			return errors.Wrapf(err, "failed to add %s to export", file.name)
+		}
+		if err := file.write(fw); err != nil {
+			return errors.Wrapf(err, "failed to write %s to export", file.name)
+		}
+	}
+
+	return errors.Wrap(archive.Close(), "failed to finish export")
+}
+
+func writeJSON(w io.Writer, value interface{}) error {
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(value)
+}
+
+func writeCSV(w io.Writer, notes []Note) error {
+	writer := stdcsv.NewWriter(w)
+
+	if err := writer.Write(csvHeader); err != nil {
+		return errors.Wrap(err, "failed to write header to csv")
+	}
+
+	for _, note := range notes {
+		expiresAt := ""
+		if note.ExpiresAt != nil {
+			expiresAt = note.ExpiresAt.Format(time.RFC3339)
+		}
+
+		row := []string{
+			note.ID,
+			note.CreatedAt.Format(time.RFC3339),
+			expiresAt,
+			strconv.FormatBool(note.Encrypted),
+			strconv.FormatBool(note.Burn),
+			note.Text,
+		}
+		if err := writer.Write(row); err != nil {
+			return errors.Wrap(err, "failed to write notes to csv")
+		}
+	}
+
+	writer.Flush()
+	return errors.Wrap(writer.Error(), "failed to write notes to csv")
+}
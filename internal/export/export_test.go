@@ -0,0 +1,115 @@
+package export
+
+import (
+	"archive/zip"
+	"bytes"
+	"context"
+	stdcsv "encoding/csv"
+	"encoding/json"
+	"io/ioutil"
+	"testing"
+	"time"
+
+	"gopkg.in/go-playground/assert.v1"
+	"smscp.xyz/internal/common"
+)
+
+type fakeUser struct{ common.User }
+
+func (fakeUser) ID() string                     { return "user-1" }
+func (fakeUser) Username() string               { return "alice" }
+func (fakeUser) Phone() string                  { return "12085550100" }
+func (fakeUser) Token() string                  { return "secret-jwt" }
+func (fakeUser) CreatedAt() time.Time           { return time.Unix(100, 0).UTC() }
+func (fakeUser) Retention() common.Retention    { return common.Retention{Days: 30} }
+func (fakeUser) Save(ctx context.Context) error { return nil }
+
+type fakeNote struct {
+	id, text string
+	expires  time.Time
+}
+
+func (n fakeNote) ID() string           { return n.id }
+func (n fakeNote) Short() string        { return n.text }
+func (n fakeNote) Text() string         { return n.text }
+func (n fakeNote) Token() string        { return "secret-jwt-" + n.id }
+func (n fakeNote) CreatedAt() time.Time { return time.Unix(200, 0).UTC() }
+func (n fakeNote) Encrypted() bool      { return false }
+func (n fakeNote) ExpiresAt() time.Time { return n.expires }
+func (n fakeNote) Burn() bool           { return false }
+
+var notes = []common.Note{
+	fakeNote{"note-1", "hello, \"world\"", time.Time{}},
+	fakeNote{"note-2", "line\nbreak", time.Unix(300, 0).UTC()},
+}
+
+func testExport() Export {
+	return Export{func() time.Time { return time.Unix(1000, 0) }}
+}
+
+func TestZIP(t *testing.T) {
+	var buf bytes.Buffer
+	assert.Equal(t, nil, testExport().Write(&buf, ZIP, fakeUser{}, notes))
+
+	archive, err := zip.NewReader(bytes.NewReader(buf.Bytes()), int64(buf.Len()))
+	assert.Equal(t, nil, err)
+
+	files := map[string][]byte{}
+	for _, file := range archive.File {
+		r, err := file.Open()
+		assert.Equal(t, nil, err)
+		files[file.Name], _ = ioutil.ReadAll(r)
+		r.Close()
+	}
+	assert.Equal(t, 4, len(files))
+
+	var manifest Manifest
+	assert.Equal(t, nil, json.Unmarshal(files[ManifestFile], &manifest))
+	assert.Equal(t, SchemaVersion, manifest.SchemaVersion)
+	assert.Equal(t, 2, manifest.NoteCount)
+	assert.Equal(t, []string{UserFile, NotesJSON, NotesCSV}, manifest.Files)
+
+	var user User
+	assert.Equal(t, nil, json.Unmarshal(files[UserFile], &user))
+	assert.Equal(t, "user-1", user.ID)
+	assert.Equal(t, 30, user.Retention.Days)
+
+	var got []Note
+	assert.Equal(t, nil, json.Unmarshal(files[NotesJSON], &got))
+	assert.Equal(t, "note-1", got[0].ID)
+	assert.Equal(t, (*time.Time)(nil), got[0].ExpiresAt)
+	assert.Equal(t, time.Unix(300, 0).UTC(), *got[1].ExpiresAt)
+
+	assert.Equal(t, false, bytes.Contains(buf.Bytes(), []byte("secret-jwt")))
+}
+
+func TestCSV(t *testing.T) {
+	var buf bytes.Buffer
+	assert.Equal(t, nil, testExport().Write(&buf, CSV, fakeUser{}, notes))
+
+	rows, err := stdcsv.NewReader(&buf).ReadAll()
+	assert.Equal(t, nil, err)
+	assert.Equal(t, 3, len(rows))
+	for _, row := range rows {
+		assert.Equal(t, len(csvHeader), len(row))
+	}
+	assert.Equal(t, []string{"note-1", "1970-01-01T00:03:20Z", "", "false", "false", "hello, \"world\""}, rows[1])
+	assert.Equal(t, "line\nbreak", rows[2][5])
+}
+
+func TestJSON(t *testing.T) {
+	var buf bytes.Buffer
+	assert.Equal(t, nil, testExport().Write(&buf, JSON, fakeUser{}, nil))
+
+	var doc Document
+	assert.Equal(t, nil, json.Unmarshal(buf.Bytes(), &doc))
+	assert.Equal(t, SchemaVersion, doc.SchemaVersion)
+	assert.Equal(t, "alice", doc.User.Username)
+	assert.Equal(t, []Note{}, doc.Notes)
+}
+
+func TestUnknownFormat(t *testing.T) {
+	_, err := testExport().ContentType("xml")
+	assert.NotEqual(t, nil, err)
+	assert.NotEqual(t, nil, testExport().Write(ioutil.Discard, "xml", fakeUser{}, nil))
+}
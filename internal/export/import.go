@@ -0,0 +1,209 @@
+package export
+
+import (
+	"archive/zip"
+	"bytes"
+	stdcsv "encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// Row is one note read back by Parse. Line counts from 1: the record of a CSV
+// file, counting the header, or the position in a JSON list. Err is set when
+// the row couldn't be read; the rest of the file still is.
+type Row struct {
+	Line int
+	Note Note /* CreatedAt is zero when the file didn't say. */
+	Err  error
+}
+
+// Parse reads notes from any format Write produces, or from a simple CSV whose
+// header names a text column and optionally created_at. Times in a CSV are RFC
+// 3339 or unix seconds. The error is for the file as a whole.
+func Parse(byt []byte) ([]Row, error) {
+	trimmed := bytes.TrimLeft(byt, " \t\r\n\ufeff")
+	switch {
+	case bytes.HasPrefix(byt, []byte("PK\x03\x04")):
+		return parseZIP(byt)
+	case bytes.HasPrefix(trimmed, []byte("{")):
+		var doc struct {
+			Manifest
+			Notes []json.RawMessage `json:"notes"`
+		}
+		if err := json.Unmarshal(trimmed, &doc); err != nil {
+			return nil, errors.Wrap(err, "invalid json export")
+		}
+		if err := checkVersion(doc.Manifest); err != nil {
+			return nil, err
+		}
+		return parseJSONNotes(doc.Notes), nil
+	case bytes.HasPrefix(trimmed, []byte("[")):
+		var notes []json.RawMessage
+		if err := json.Unmarshal(trimmed, &notes); err != nil {
+			return nil, errors.Wrap(err, "invalid json notes")
+		}
+		return parseJSONNotes(notes), nil
+	}
+	return parseCSV(bytes.NewReader(byt))
+}
+
+func parseZIP(byt []byte) ([]Row, error) {
+	archive, err := zip.NewReader(bytes.NewReader(byt), int64(len(byt)))
+	if err != nil {
+		return nil, errors.Wrap(err, "invalid zip export")
+	}
+
+	files := map[string]*zip.File{}
+	for _, file := range archive.File {
+		files[file.Name] = file
+	}
+	if files[ManifestFile] == nil || files[NotesJSON] == nil {
+		return nil, fmt.Errorf("invalid zip export; want %s and %s", ManifestFile, NotesJSON)
+	}
+
+	var manifest Manifest
+	if err := readJSON(files[ManifestFile], &manifest); err != nil {
+		return nil, err
+	}
+	if err := checkVersion(manifest); err != nil {
+		return nil, err
+	}
+
+	var notes []json.RawMessage
+	if err := readJSON(files[NotesJSON], &notes); err != nil {
+		return nil, err
+	}
+
+	return parseJSONNotes(notes), nil
+}
+
+func readJSON(file *zip.File, value interface{}) error {
+	r, err := file.Open()
+	if err != nil {
+		return errors.Wrapf(err, "failed to read %s", file.Name)
+	}
+	defer r.Close()
+
+	if err := json.NewDecoder(r).Decode(value); err != nil {
+		return errors.Wrapf(err, "invalid %s", file.Name)
+	}
+	return nil
+}
+
+func checkVersion(manifest Manifest) error {
+	if manifest.SchemaVersion < 1 || manifest.SchemaVersion > SchemaVersion {
+		return fmt.Errorf("unsupported export schema version %d; want 1 to %d", manifest.SchemaVersion, SchemaVersion)
+	}
+	return nil
+}
+
+func parseJSONNotes(raw []json.RawMessage) []Row {
+	rows := make([]Row, 0, len(raw))
+	for i, item := range raw {
+		row := Row{Line: i + 1}
+		if err := json.Unmarshal(item, &row.Note); err != nil {
+			row.Err = errors.Wrap(err, "invalid note")
+		}
+		rows = append(rows, row)
+	}
+	return rows
+}
+
+func parseCSV(r io.Reader) ([]Row, error) {
+	reader := stdcsv.NewReader(r)
+	reader.FieldsPerRecord = -1 /* Checked per row so one bad row isn't fatal. */
+
+	header, err := reader.Read()
+	if err == io.EOF {
+		return nil, errors.New("invalid csv; empty")
+	}
+	if err != nil {
+		return nil, errors.Wrap(err, "invalid csv header")
+	}
+
+	columns := map[string]int{}
+	for i, name := range header {
+		columns[strings.ToLower(strings.TrimSpace(strings.TrimPrefix(name, "\ufeff")))] = i
+	}
+	if _, ok := columns["text"]; !ok {
+		return nil, errors.New("invalid csv; header has no text column")
+	}
+
+	var rows []Row
+	for line := 2; ; line++ {
+		record, err := reader.Read()
+		if err == io.EOF {
+			break
+		}
+
+		row := Row{Line: line}
+		if err != nil {
+			if _, ok := err.(*stdcsv.ParseError); !ok {
+				return nil, errors.Wrap(err, "failed to read csv")
+			}
+			row.Err = err
+		} else if len(record) != len(header) {
+			row.Err = fmt.Errorf("want %d fields, got %d", len(header), len(record))
+		} else {
+			row.Note, row.Err = csvNote(columns, record)
+		}
+
+		rows = append(rows, row)
+	}
+
+	return rows, nil
+}
+
+func csvNote(columns map[string]int, record []string) (Note, error) {
+	field := func(name string) string {
+		if i, ok := columns[name]; ok {
+			return strings.TrimSpace(record[i])
+		}
+		return ""
+	}
+
+	note := Note{ID: field("id"), Text: record[columns["text"]]}
+
+	var err error
+	if note.CreatedAt, err = parseTime(field("created_at")); err != nil {
+		return Note{}, errors.Wrap(err, "invalid created_at")
+	}
+	if raw := field("expires_at"); raw != "" {
+		at, err := parseTime(raw)
+		if err != nil {
+			return Note{}, errors.Wrap(err, "invalid expires_at")
+		}
+		note.ExpiresAt = &at
+	}
+	if note.Encrypted, err = parseBool(field("encrypted")); err != nil {
+		return Note{}, errors.Wrap(err, "invalid encrypted")
+	}
+	if note.Burn, err = parseBool(field("burn")); err != nil {
+		return Note{}, errors.Wrap(err, "invalid burn")
+	}
+
+	return note, nil
+}
+
+func parseTime(raw string) (time.Time, error) {
+	if raw == "" {
+		return time.Time{}, nil
+	}
+	if unix, err := strconv.ParseInt(raw, 10, 64); err == nil {
+		return time.Unix(unix, 0).UTC(), nil
+	}
+	return time.Parse(time.RFC3339, raw)
+}
+
+func parseBool(raw string) (bool, error) {
+	if raw == "" {
+		return false, nil
+	}
+	return strconv.ParseBool(raw)
+}
@@ -0,0 +1,61 @@
+package export
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+	"time"
+
+	"gopkg.in/go-playground/assert.v1"
+)
+
+func TestParseRoundTrip(t *testing.T) {
+	for _, format := range []string{ZIP, JSON, CSV} {
+		var buf bytes.Buffer
+		assert.Equal(t, nil, testExport().Write(&buf, format, fakeUser{}, notes))
+
+		rows, err := Parse(buf.Bytes())
+		assert.Equal(t, nil, err)
+		assert.Equal(t, 2, len(rows))
+		for i, row := range rows {
+			assert.Equal(t, nil, row.Err)
+			assert.Equal(t, notes[i].ID(), row.Note.ID)
+			assert.Equal(t, notes[i].Text(), row.Note.Text)
+			assert.Equal(t, notes[i].CreatedAt(), row.Note.CreatedAt)
+		}
+		assert.Equal(t, time.Unix(300, 0).UTC(), *rows[1].Note.ExpiresAt)
+	}
+}
+
+func TestParseSimpleCSV(t *testing.T) {
+	rows, err := Parse([]byte("Text,Created_At\nhello,1000\n\"two, words\",\nbad,yesterday\ntoo,many,fields\n"))
+	assert.Equal(t, nil, err)
+	assert.Equal(t, 4, len(rows))
+
+	assert.Equal(t, "hello", rows[0].Note.Text)
+	assert.Equal(t, time.Unix(1000, 0).UTC(), rows[0].Note.CreatedAt)
+	assert.Equal(t, "two, words", rows[1].Note.Text)
+	assert.Equal(t, true, rows[1].Note.CreatedAt.IsZero())
+
+	assert.Equal(t, 4, rows[2].Line)
+	assert.NotEqual(t, nil, rows[2].Err)
+	assert.NotEqual(t, nil, rows[3].Err)
+}
+
+func TestParseInvalid(t *testing.T) {
+	for _, file := range []string{
+		"",
+		"note,created_at\nhello,1\n",
+		`{"schema_version": 99, "notes": []}`,
+		`{"notes": []}`,
+		"PK\x03\x04 not really",
+	} {
+		_, err := Parse([]byte(file))
+		assert.NotEqual(t, nil, err)
+	}
+
+	rows, err := Parse([]byte(`[{"text": "ok"}, {"text": 1}]`))
+	assert.Equal(t, nil, err)
+	assert.Equal(t, nil, rows[0].Err)
+	assert.Equal(t, true, strings.Contains(rows[1].Err.Error(), "invalid note"))
+}
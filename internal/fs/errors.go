@@ -0,0 +1,25 @@
+package fs
+
+// Errors are typed by behaviour rather than by name so callers (i.e.
+// internal/api) can classify them with errors.Cause without importing this
+// package.
+
+type invalidError struct{ error }
+
+func (invalidError) Invalid() bool { return true }
+
+type notFoundError struct{ error }
+
+func (notFoundError) NotFound() bool { return true }
+
+type conflictError struct{ error }
+
+func (conflictError) Conflict() bool { return true }
+
+type unauthorizedError struct{ error }
+
+func (unauthorizedError) Unauthorized() bool { return true }
+
+type lockedError struct{ error }
+
+func (lockedError) Locked() bool { return true }
@@ -2,7 +2,13 @@ package fs
 
 import (
 	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"strings"
 	"time"
+	"unicode"
 
 	"cloud.google.com/go/firestore"
 	"github.com/dgrijalva/jwt-go"
@@ -10,23 +16,67 @@ import (
 	"golang.org/x/exp/utf8string"
 	"google.golang.org/api/iterator"
 	"google.golang.org/api/option"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
 	"smscp.xyz/internal/common"
+	"smscp.xyz/internal/envelope"
 )
 
 type securityLayer interface {
 	HashCreate(pass string) (string, error)
 	HashCompare(pass, hash string) error
-	TokenCreate(val jwt.Claims) (string, error)
-	TokenFrom(tokenString string) (jwt.MapClaims, error)
+	TokenCreate(ctx context.Context, val jwt.Claims) (string, error)
+	TokenFrom(ctx context.Context, tokenString string) (jwt.MapClaims, error)
 }
 
 type FS struct {
-	sec  securityLayer
-	conn *firestore.Client
+	sec             securityLayer
+	conn            *firestore.Client
+	maxFailedLogins int
+	keys            *envelope.Keyring
+	observers       []Observer
+}
+
+// Observer is told when each storage operation starts, and handed its error
+// once it ends, i.e. to measure latency or trace it.
+type Observer interface {
+	Observe(ctx context.Context, op string) (done func(err error))
 }
 
 func Default(sec securityLayer, conn *firestore.Client) FS {
-	return FS{sec, conn}
+	return FS{sec, conn, 5, nil, nil}
+}
+
+// WithObserver also reports every exported operation to o.
+func (fs FS) WithObserver(o Observer) FS {
+	fs.observers = append(fs.observers[:len(fs.observers):len(fs.observers)], o)
+	return fs
+}
+
+// op reports an operation to the observers, as
+// defer fs.op(ctx, "NoteGet")(&_err).
+func (fs FS) op(ctx context.Context, name string) func(*error) {
+	done := make([]func(error), len(fs.observers))
+	for i, o := range fs.observers {
+		done[i] = o.Observe(ctx, name)
+	}
+	return func(err *error) {
+		for _, d := range done {
+			d(*err)
+		}
+	}
+}
+
+// WithKeyring encrypts note text at rest with master keys from keys.
+func (fs FS) WithKeyring(keys *envelope.Keyring) FS {
+	fs.keys = keys
+	return fs
+}
+
+// WithMaxFailedLogins sets how many wrong passwords in a row lock an account.
+func (fs FS) WithMaxFailedLogins(n int) FS {
+	fs.maxFailedLogins = n
+	return fs
 }
 
 // private
@@ -37,9 +87,9 @@ func (fs FS) snaptouser(ctx context.Context, doc *firestore.DocumentSnapshot) (c
 		return nil, errors.Wrap(err, "user value corrupted")
 	}
 
-	token, err := fs.sec.TokenCreate(jwt.MapClaims{"UserID": user.ID()})
+	token, err := fs.userToken(ctx, &user)
 	if err != nil {
-		return nil, errors.Wrap(err, "failed to create unique token for user")
+		return nil, err
 	}
 
 	user.token = token
@@ -48,38 +98,142 @@ func (fs FS) snaptouser(ctx context.Context, doc *firestore.DocumentSnapshot) (c
 	return &user, nil
 }
 
+// userToken signs a token for user's current session; ending their sessions
+// (UserEndSessions) makes it stop working.
+func (fs FS) userToken(ctx context.Context, user *User) (string, error) {
+	token, err := fs.sec.TokenCreate(ctx, jwt.MapClaims{"UserID": user.ID(), "Session": user.UserSessions})
+	if err != nil {
+		return "", errors.Wrap(err, "failed to create unique token for user")
+	}
+	return token, nil
+}
+
+func (fs FS) snaptonote(ctx context.Context, doc *firestore.DocumentSnapshot) (common.Note, error) {
+	note := Note{ref: doc.Ref}
+	if err := doc.DataTo(&note); err != nil {
+		return nil, errors.Wrap(err, "note value corrupted")
+	}
+
+	if err := fs.open(&note); err != nil {
+		return nil, err
+	}
+
+	token, err := fs.sec.TokenCreate(ctx, jwt.MapClaims{"NoteID": note.ID()})
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to create unique token for note")
+	}
+
+	note.token = token
+	note.fs = fs
+
+	return &note, nil
+}
+
+// seal returns note as it should be stored, with its text encrypted under a
+// new data key. Without a keyring notes are stored as given.
+func (fs FS) seal(note Note) (Note, error) {
+	if fs.keys == nil {
+		return note, nil
+	}
+
+	dk, err := fs.keys.NewDataKey(note.ref.ID)
+	if err != nil {
+		return Note{}, err
+	}
+
+	text, err := dk.Seal(note.NoteText, "NoteText")
+	if err != nil {
+		return Note{}, errors.Wrap(err, "failed to encrypt note")
+	}
+	short, err := dk.Seal(note.NoteShort, "NoteShort")
+	if err != nil {
+		return Note{}, errors.Wrap(err, "failed to encrypt note")
+	}
+
+	note.NoteText, note.NoteShort = text, short
+	note.NoteKey, note.NoteKeyID = dk.Wrapped, dk.KeyID
+	return note, nil
+}
+
+// open decrypts a stored note in place. Notes written before encryption at
+// rest have no key and are left alone.
+func (fs FS) open(note *Note) error {
+	if note.NoteKeyID == "" {
+		return nil
+	}
+	if fs.keys == nil {
+		return errors.New("failed to decrypt note; no master keys configured")
+	}
+
+	dk, err := fs.keys.Unwrap(note.NoteKeyID, note.NoteKey, note.ref.ID)
+	if err != nil {
+		return err
+	}
+
+	text, err := dk.Open(note.NoteText, "NoteText")
+	if err != nil {
+		return errors.Wrap(err, "failed to decrypt note")
+	}
+	short, err := dk.Open(note.NoteShort, "NoteShort")
+	if err != nil {
+		return errors.Wrap(err, "failed to decrypt note")
+	}
+
+	note.NoteText, note.NoteShort = text, short
+	return nil
+}
+
 func (fs FS) itertouser(ctx context.Context, iter *firestore.DocumentIterator) (common.User, error) {
 	doc, err := iter.Next()
+	if err == iterator.Done {
+		return nil, notFoundError{errors.New("failed to find user")}
+	}
 	if err != nil {
-		return nil, errors.New("failed to find user")
+		return nil, errors.Wrap(err, "failed to find user")
 	}
 	return fs.snaptouser(ctx, doc)
 }
 
-func (fs FS) toshort(text string) string {
-	top := 50
-	str := utf8string.NewString(text)
-	if str.RuneCount() > top {
-		return str.Slice(0, top) + "..."
-	}
-	return str.String()
-}
+// livePage is how many notes firstLive reads at a time.
+const livePage = 10
 
-// public
+// firstLive returns the first note of q that hasn't expired, or nil. Expired
+// notes are left for NoteSweepExpired, so it reads a page at a time rather
+// than a note or the whole query.
+func (fs FS) firstLive(ctx context.Context, q firestore.Query) (common.Note, error) {
+	now := time.Now()
+	for {
+		docs, err := q.Limit(livePage).Documents(ctx).GetAll()
+		if err != nil {
+			return nil, errors.Wrap(err, "failed to find note")
+		}
 
-func ConnDefault(ctx context.Context, firestoreProjectID string) (*firestore.Client, error) {
-	// TODO: Good default connection pool options?
-	client, err := firestore.NewClient(ctx, firestoreProjectID, option.WithGRPCConnectionPool(100))
-	return client, err
+		for _, doc := range docs {
+			note, err := fs.snaptonote(ctx, doc)
+			if err != nil {
+				return nil, err
+			}
+			if !expired(note, now) {
+				return note, nil
+			}
+		}
+
+		if len(docs) < livePage {
+			return nil, nil
+		}
+		q = q.StartAfter(docs[len(docs)-1])
+	}
 }
 
-func (fs FS) UserAll(ctx context.Context, user common.User) ([]common.Note, error) {
+// live returns the user's notes that haven't expired, newest first.
+func (fs FS) live(ctx context.Context, user common.User) ([]common.Note, error) {
 	iter := fs.conn.Collection("notes").
 		Where("UserID", "==", user.ID()).
 		OrderBy("NoteCreatedAt", firestore.Desc).
 		Documents(ctx)
 	defer iter.Stop()
 
+	now := time.Now()
 	var ret []common.Note
 	for {
 		doc, err := iter.Next()
@@ -91,18 +245,13 @@ func (fs FS) UserAll(ctx context.Context, user common.User) ([]common.Note, erro
 			return nil, errors.Wrap(err, "failed to read all note values")
 		}
 
-		note := Note{ref: doc.Ref}
-		if err := doc.DataTo(&note); err != nil {
-			return nil, errors.Wrap(err, "note value corrupted")
-		}
-
-		token, err := fs.sec.TokenCreate(jwt.MapClaims{"NoteID": note.ID()})
+		note, err := fs.snaptonote(ctx, doc)
 		if err != nil {
-			return nil, errors.Wrap(err, "failed to create unique token for note")
+			return nil, err
+		}
+		if expired(note, now) {
+			continue
 		}
-
-		note.token = token
-		note.fs = fs
 
 		ret = append(ret, note)
 	}
@@ -110,104 +259,408 @@ func (fs FS) UserAll(ctx context.Context, user common.User) ([]common.Note, erro
 	return ret, nil
 }
 
-func (fs FS) UserDel(ctx context.Context, user common.User) error {
-	// Delete notes
+// burnShort stands in for the text of burn after read notes everywhere but
+// the gets that burn them (NoteGet and NoteGetLatest) and NoteGetAllStored, so
+// they are read once.
+const burnShort = "burn after read note"
+
+// unread hides the text of note when it burns after read.
+func unread(note common.Note) common.Note {
+	if n, ok := note.(*Note); ok && n.NoteBurn {
+		n.NoteText, n.NoteShort = "", burnShort
+	}
+	return note
+}
+
+// expired notes are treated as deleted until the sweeper gets to them.
+func expired(note common.Note, now time.Time) bool {
+	at := note.ExpiresAt()
+	return !at.IsZero() && !now.Before(at)
+}
+
+func (fs FS) toshort(text string) string {
+	top := 50
+	str := utf8string.NewString(text)
+	if str.RuneCount() > top {
+		return str.Slice(0, top) + "..."
+	}
+	return str.String()
+}
+
+// public
+
+// Ping reads at most one document to check firestore answers.
+func (fs FS) Ping(ctx context.Context) (_err error) {
+	defer fs.op(ctx, "Ping")(&_err)
+
+	iter := fs.conn.Collection("users").Limit(1).Documents(ctx)
+	defer iter.Stop()
+
+	if _, err := iter.Next(); err != nil && err != iterator.Done {
+		return errors.Wrap(err, "failed to reach firestore")
+	}
+	return nil
+}
+
+func ConnDefault(ctx context.Context, firestoreProjectID string) (*firestore.Client, error) {
+	// TODO: Good default connection pool options?
+	client, err := firestore.NewClient(ctx, firestoreProjectID, option.WithGRPCConnectionPool(100))
+	return client, err
+}
+
+// UserAll returns the user's live notes for export, without the text of burn
+// after read notes.
+func (fs FS) UserAll(ctx context.Context, user common.User) (_ []common.Note, _err error) {
+	defer fs.op(ctx, "UserAll")(&_err)
+
+	notes, err := fs.live(ctx, user)
+	if err != nil {
+		return nil, err
+	}
+	for i := range notes {
+		notes[i] = unread(notes[i])
+	}
+	return notes, nil
+}
+
+// NoteGetAllStored returns the user's live notes as stored, burn after read
+// text included. It's for telling whether an import is already here; never
+// show what it returns.
+func (fs FS) NoteGetAllStored(ctx context.Context, user common.User) (_ []common.Note, _err error) {
+	defer fs.op(ctx, "NoteGetAllStored")(&_err)
+
+	return fs.live(ctx, user)
+}
+
+// UserDel deletes the user's notes in batches, then the user. If it fails
+// part way, calling it again carries on where it stopped; the user is only
+// gone once their notes are.
+func (fs FS) UserDel(ctx context.Context, user common.User) (_err error) {
+	defer fs.op(ctx, "UserDel")(&_err)
+
 	iter := fs.conn.Collection("notes").
 		Where("UserID", "==", user.ID()).
 		Documents(ctx)
 	defer iter.Stop()
 
-	for {
+	if _, err := fs.deleteAll(ctx, iter, false); err != nil {
+		return errors.Wrap(err, "failed to delete notes")
+	}
+
+	if _, err := fs.conn.Collection("users").Doc(user.ID()).Delete(ctx); err != nil {
+		return errors.Wrap(err, "failed to delete user")
+	}
+
+	return nil
+}
+
+// UserGetDueForDeletion returns users whose deletion grace period has ended by
+// now.
+func (fs FS) UserGetDueForDeletion(ctx context.Context, now time.Time) (_ []common.User, _err error) {
+	defer fs.op(ctx, "UserGetDueForDeletion")(&_err)
 
+	iter := fs.conn.Collection("users").
+		Where("UserDeleteAt", ">", 0).
+		Where("UserDeleteAt", "<=", now.Unix()).
+		Documents(ctx)
+	defer iter.Stop()
+
+	var ret []common.User
+	for {
 		doc, err := iter.Next()
 		if err == iterator.Done {
 			break
 		}
-
 		if err != nil {
-			return errors.Wrap(err, "failed to read all note values")
+			return nil, errors.Wrap(err, "failed to find users due for deletion")
 		}
 
-		if _, err := doc.Ref.Delete(ctx); err != nil {
-			return errors.Wrap(err, "failed to delete note")
+		user, err := fs.snaptouser(ctx, doc)
+		if err != nil {
+			return nil, err
 		}
+		ret = append(ret, user)
 	}
 
-	// Delete user
-	if _, err := fs.conn.Collection("users").Doc(user.ID()).Delete(ctx); err != nil {
-		return errors.Wrap(err, "failed to delete user")
-	}
+	return ret, nil
+}
 
-	return nil
+func (fs FS) NoteGetLatest(ctx context.Context, user common.User) (_ common.Note, _err error) {
+	defer fs.op(ctx, "NoteGetLatest")(&_err)
+
+	q := fs.conn.Collection("notes").
+		Where("UserID", "==", user.ID()).
+		OrderBy("NoteCreatedAt", firestore.Desc)
+
+	return fs.firstLive(ctx, q)
 }
 
-func (fs FS) NoteGetLatest(ctx context.Context, user common.User) (common.Note, error) {
-	iter := fs.conn.Collection("notes").
+// NoteGetLatestWithTime is for display, so hides a burn after read note's text.
+func (fs FS) NoteGetLatestWithTime(ctx context.Context, user common.User, t time.Duration) (_ common.Note, _err error) {
+	defer fs.op(ctx, "NoteGetLatestWithTime")(&_err)
+
+	q := fs.conn.Collection("notes").
 		Where("UserID", "==", user.ID()).
-		OrderBy("NoteCreatedAt", firestore.Desc).
-		Limit(1).
-		Documents(ctx)
+		Where("NoteCreatedAt", ">=", time.Now().UTC().Add(-t).Unix()). // Negate .Add, awesome.
+		OrderBy("NoteCreatedAt", firestore.Desc)
 
-	defer iter.Stop()
+	note, err := fs.firstLive(ctx, q)
+	if note == nil || err != nil {
+		return nil, err
+	}
+	return unread(note), nil
+}
 
-	doc, err := iter.Next()
-	if err == iterator.Done {
+// NoteGet returns nil when the note does not exist or belongs to another user.
+func (fs FS) NoteGet(ctx context.Context, user common.User, id string) (_ common.Note, _err error) {
+	defer fs.op(ctx, "NoteGet")(&_err)
+
+	doc, err := fs.conn.Collection("notes").Doc(id).Get(ctx)
+	if status.Code(err) == codes.NotFound {
 		return nil, nil
 	}
 	if err != nil {
 		return nil, errors.Wrap(err, "failed to find note")
 	}
 
-	note := Note{ref: doc.Ref}
-	if err := doc.DataTo(&note); err != nil {
-		return nil, errors.Wrap(err, "note value corrupted")
+	if owner, err := doc.DataAt("UserID"); err != nil || owner != user.ID() {
+		return nil, nil
 	}
 
-	token, err := fs.sec.TokenCreate(jwt.MapClaims{"NoteID": note.ID()})
-	if err != nil {
-		return nil, errors.Wrap(err, "failed to create unique token for note")
+	note, err := fs.snaptonote(ctx, doc)
+	if err != nil || expired(note, time.Now()) {
+		return nil, err
 	}
 
-	note.token = token
-	note.fs = fs
+	return note, nil
+}
 
-	return &note, nil
+// NoteDel fails with NotFound when the note is already gone, so of two
+// concurrent deletes only one succeeds.
+func (fs FS) NoteDel(ctx context.Context, note common.Note) (_err error) {
+	defer fs.op(ctx, "NoteDel")(&_err)
+
+	_, err := fs.conn.Collection("notes").Doc(note.ID()).Delete(ctx, firestore.Exists)
+	if status.Code(err) == codes.NotFound {
+		return notFoundError{errors.New("note already deleted")}
+	}
+	if err != nil {
+		return errors.Wrap(err, "failed to delete note")
+	}
+	return nil
 }
 
-func (fs FS) NoteGetLatestWithTime(ctx context.Context, user common.User, t time.Duration) (common.Note, error) {
+// maxBatch is the most writes Firestore takes in one batch.
+const maxBatch = 500
+
+// NoteSweepExpired deletes notes whose TTL has passed by now, returning how
+// many were deleted.
+func (fs FS) NoteSweepExpired(ctx context.Context, now time.Time) (_ int, _err error) {
+	defer fs.op(ctx, "NoteSweepExpired")(&_err)
+
 	iter := fs.conn.Collection("notes").
-		Where("UserID", "==", user.ID()).
-		Where("NoteCreatedAt", ">=", time.Now().UTC().Add(-t).Unix()). // Negate .Add, awesome.
-		OrderBy("NoteCreatedAt", firestore.Desc).
-		Limit(1).
+		Where("NoteExpiresAt", ">", 0).
+		Where("NoteExpiresAt", "<=", now.Unix()).
 		Documents(ctx)
 	defer iter.Stop()
 
-	doc, err := iter.Next()
-	if err == iterator.Done {
-		return nil, nil
+	n, err := fs.deleteAll(ctx, iter, false)
+	return n, errors.Wrap(err, "failed to sweep expired notes")
+}
+
+// NotePurge deletes the notes each user's retention setting no longer keeps,
+// returning how many were deleted by user ID. With dryRun nothing is deleted
+// and the counts are what would have been.
+func (fs FS) NotePurge(ctx context.Context, now time.Time, dryRun bool) (_ map[string]int, _err error) {
+	defer fs.op(ctx, "NotePurge")(&_err)
+
+	report := map[string]int{}
+
+	// A user has at most one of these set, so no user is visited twice.
+	for _, field := range []string{"UserRetentionDays", "UserRetentionKeep"} {
+		iter := fs.conn.Collection("users").Where(field, ">", 0).Documents(ctx)
+		err := fs.eachUser(iter, func(user User) error {
+			n, err := fs.notePurgeUser(ctx, user, now, dryRun)
+			if n > 0 {
+				report[user.ID()] = n
+			}
+			return err
+		})
+		iter.Stop()
+		if err != nil {
+			return report, errors.Wrap(err, "failed to purge notes")
+		}
 	}
-	if err != nil {
-		return nil, errors.Wrap(err, "failed to find note")
+
+	return report, nil
+}
+
+func (fs FS) notePurgeUser(ctx context.Context, user User, now time.Time, dryRun bool) (int, error) {
+	query := fs.conn.Collection("notes").Where("UserID", "==", user.ID())
+
+	retention := user.Retention()
+	switch {
+	case retention.Days > 0:
+		cutoff := now.AddDate(0, 0, -retention.Days).Unix()
+		query = query.Where("NoteCreatedAt", "<", cutoff)
+	case retention.Keep > 0:
+		query = query.OrderBy("NoteCreatedAt", firestore.Desc).Offset(retention.Keep)
+	default:
+		return 0, nil
 	}
 
-	note := Note{ref: doc.Ref}
-	if err := doc.DataTo(&note); err != nil {
-		return nil, errors.Wrap(err, "note value corrupted")
+	iter := query.Documents(ctx)
+	defer iter.Stop()
+
+	return fs.deleteAll(ctx, iter, dryRun)
+}
+
+func (fs FS) eachUser(iter *firestore.DocumentIterator, fn func(User) error) error {
+	for {
+		doc, err := iter.Next()
+		if err == iterator.Done {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+
+		user := User{ref: doc.Ref}
+		if err := doc.DataTo(&user); err != nil {
+			return errors.Wrap(err, "user value corrupted")
+		}
+		if err := fn(user); err != nil {
+			return err
+		}
 	}
+}
 
-	token, err := fs.sec.TokenCreate(jwt.MapClaims{"NoteID": note.ID()})
-	if err != nil {
-		return nil, errors.Wrap(err, "failed to create unique token for note")
+// deleteAll deletes every document from iter in batches, returning how many
+// were deleted, or with dryRun only counted.
+func (fs FS) deleteAll(ctx context.Context, iter *firestore.DocumentIterator, dryRun bool) (int, error) {
+	var (
+		batch   = fs.conn.Batch()
+		pending = 0
+		deleted = 0
+	)
+	for {
+		doc, err := iter.Next()
+		if err == iterator.Done {
+			break
+		}
+		if err != nil {
+			return deleted, err
+		}
+
+		if dryRun {
+			deleted++
+			continue
+		}
+
+		batch.Delete(doc.Ref)
+		if pending++; pending == maxBatch {
+			if _, err := batch.Commit(ctx); err != nil {
+				return deleted, err
+			}
+			deleted += pending
+			batch, pending = fs.conn.Batch(), 0
+		}
 	}
 
-	note.token = token
-	note.fs = fs
+	if pending > 0 {
+		if _, err := batch.Commit(ctx); err != nil {
+			return deleted, err
+		}
+		deleted += pending
+	}
 
-	return &note, nil
+	return deleted, nil
 }
 
-func (fs FS) NoteGetList(ctx context.Context, user common.User, page, count int) ([]common.Note, bool, error) {
+// NoteRotateKeys re-wraps every note's data key with the current master key,
+// after which older master keys can be removed from configuration. Notes
+// stored before encryption at rest are encrypted on the way. It returns how
+// many notes were written.
+func (fs FS) NoteRotateKeys(ctx context.Context) (_ int, _err error) {
+	defer fs.op(ctx, "NoteRotateKeys")(&_err)
+
+	if fs.keys == nil {
+		return 0, errors.New("failed to rotate keys; no master keys configured")
+	}
+
+	iter := fs.conn.Collection("notes").Documents(ctx)
+	defer iter.Stop()
+
+	var (
+		batch   = fs.conn.Batch()
+		pending = 0
+		written = 0
+	)
+	for {
+		doc, err := iter.Next()
+		if err == iterator.Done {
+			break
+		}
+		if err != nil {
+			return written, errors.Wrap(err, "failed to read all note values")
+		}
+
+		note := Note{ref: doc.Ref}
+		if err := doc.DataTo(&note); err != nil {
+			return written, errors.Wrap(err, "note value corrupted")
+		}
+
+		var updates []firestore.Update
+		switch note.NoteKeyID {
+		case fs.keys.Current():
+			continue
+		case "":
+			stored, err := fs.seal(note)
+			if err != nil {
+				return written, err
+			}
+			updates = []firestore.Update{
+				{Path: "NoteText", Value: stored.NoteText},
+				{Path: "NoteShort", Value: stored.NoteShort},
+				{Path: "NoteKey", Value: stored.NoteKey},
+				{Path: "NoteKeyID", Value: stored.NoteKeyID},
+			}
+		default:
+			dk, err := fs.keys.Rewrap(note.NoteKeyID, note.NoteKey, note.ref.ID)
+			if err != nil {
+				return written, errors.Wrapf(err, "failed to rotate note %s", note.ref.ID)
+			}
+			updates = []firestore.Update{
+				{Path: "NoteKey", Value: dk.Wrapped},
+				{Path: "NoteKeyID", Value: dk.KeyID},
+			}
+		}
+
+		batch.Update(note.ref, updates)
+		if pending++; pending == maxBatch {
+			if _, err := batch.Commit(ctx); err != nil {
+				return written, errors.Wrap(err, "failed to write rotated notes")
+			}
+			written += pending
+			batch, pending = fs.conn.Batch(), 0
+		}
+	}
+
+	if pending > 0 {
+		if _, err := batch.Commit(ctx); err != nil {
+			return written, errors.Wrap(err, "failed to write rotated notes")
+		}
+		written += pending
+	}
+
+	return written, nil
+}
+
+// NoteGetList pages through the user's notes for display, without the text of
+// burn after read notes.
+func (fs FS) NoteGetList(ctx context.Context, user common.User, page, count int) (_ []common.Note, _ bool, _err error) {
+	defer fs.op(ctx, "NoteGetList")(&_err)
+
 	iter := fs.conn.Collection("notes").
 		Where("UserID", "==", user.ID()).
 		Offset(page*count).
@@ -216,7 +669,11 @@ func (fs FS) NoteGetList(ctx context.Context, user common.User, page, count int)
 		Documents(ctx)
 	defer iter.Stop()
 
-	var ret []common.Note
+	var (
+		ret  []common.Note
+		read = 0
+		now  = time.Now()
+	)
 	for {
 		doc, err := iter.Next()
 		if err == iterator.Done {
@@ -227,45 +684,61 @@ func (fs FS) NoteGetList(ctx context.Context, user common.User, page, count int)
 			return nil, false, errors.Wrap(err, "failed to read all note values")
 		}
 
-		note := Note{ref: doc.Ref}
-		if err := doc.DataTo(&note); err != nil {
-			return nil, false, errors.Wrap(err, "note value corrupted")
+		// Expired notes still count towards the page so offsets stay put; a
+		// page can come up short until the sweeper runs.
+		if read++; read > count {
+			break
 		}
 
-		token, err := fs.sec.TokenCreate(jwt.MapClaims{"NoteID": note.ID()})
+		note, err := fs.snaptonote(ctx, doc)
 		if err != nil {
-			return nil, false, errors.Wrap(err, "failed to create unique token for note")
+			return nil, false, err
+		}
+		if expired(note, now) {
+			continue
 		}
 
-		note.token = token
-		note.fs = fs
-
-		ret = append(ret, note)
-	}
-
-	hasMore := len(ret) > count
-	if hasMore {
-		ret = ret[:len(ret)-1] /* all but last */
+		ret = append(ret, unread(note))
 	}
 
-	return ret, hasMore, nil
+	return ret, read > count, nil
 }
 
-func (fs FS) NoteCreate(ctx context.Context, user common.User, text string) (common.Note, error) {
+// encryptedShort stands in for the short text of notes sealed by the client
+// (see pkg/e2e); their text is ciphertext, so there is nothing to shorten.
+const encryptedShort = "encrypted note"
+
+// NoteCreate stores a note.
+func (fs FS) NoteCreate(ctx context.Context, user common.User, text string, opts common.NoteOptions) (_ common.Note, _err error) {
+	defer fs.op(ctx, "NoteCreate")(&_err)
+
+	now := time.Now().UTC()
 	note := Note{
 		ref:           fs.conn.Collection("notes").NewDoc(),
 		NoteText:      text,
 		NoteShort:     fs.toshort(text),
-		NoteCreatedAt: time.Now().UTC().Unix(),
+		NoteCreatedAt: now.Unix(),
+		NoteEncrypted: opts.Encrypted,
+		NoteBurn:      opts.Burn,
 		UserID:        user.ID(),
 	}
+	if opts.Encrypted {
+		note.NoteShort = encryptedShort
+	}
+	if opts.TTL > 0 {
+		note.NoteExpiresAt = now.Add(opts.TTL).Unix()
+	}
 
-	_, err := note.ref.Set(ctx, note)
+	stored, err := fs.seal(note)
 	if err != nil {
+		return nil, err
+	}
+
+	if _, err := note.ref.Set(ctx, stored); err != nil {
 		return nil, errors.Wrap(err, "failed to create new note")
 	}
 
-	token, err := fs.sec.TokenCreate(jwt.MapClaims{"NoteID": note.ID()})
+	token, err := fs.sec.TokenCreate(ctx, jwt.MapClaims{"NoteID": note.ID()})
 	if err != nil {
 		return nil, errors.Wrap(err, "failed to create unique token for note")
 	}
@@ -276,44 +749,135 @@ func (fs FS) NoteCreate(ctx context.Context, user common.User, text string) (com
 	return &note, nil
 }
 
-func (fs FS) UserGet(ctx context.Context, token string) (common.User, error) {
-	claims, err := fs.sec.TokenFrom(token)
+// NoteImport stores notes without texting them, in batches, returning how many
+// were written. Each note gets a new ID.
+func (fs FS) NoteImport(ctx context.Context, user common.User, notes []common.ImportedNote) (_ int, _err error) {
+	defer fs.op(ctx, "NoteImport")(&_err)
+
+	var (
+		now     = time.Now().UTC()
+		batch   = fs.conn.Batch()
+		pending = 0
+		written = 0
+	)
+	for _, imported := range notes {
+		note := Note{
+			ref:           fs.conn.Collection("notes").NewDoc(),
+			NoteText:      imported.Text,
+			NoteShort:     fs.toshort(imported.Text),
+			NoteCreatedAt: now.Unix(),
+			NoteEncrypted: imported.Encrypted,
+			NoteBurn:      imported.Burn,
+			UserID:        user.ID(),
+		}
+		if imported.Encrypted {
+			note.NoteShort = encryptedShort
+		}
+		if !imported.CreatedAt.IsZero() {
+			note.NoteCreatedAt = imported.CreatedAt.Unix()
+		}
+		if !imported.ExpiresAt.IsZero() {
+			note.NoteExpiresAt = imported.ExpiresAt.Unix()
+		}
+
+		stored, err := fs.seal(note)
+		if err != nil {
+			return written, err
+		}
+
+		batch.Set(note.ref, stored)
+		if pending++; pending == maxBatch {
+			if _, err := batch.Commit(ctx); err != nil {
+				return written, errors.Wrap(err, "failed to import notes")
+			}
+			written += pending
+			batch, pending = fs.conn.Batch(), 0
+		}
+	}
+
+	if pending > 0 {
+		if _, err := batch.Commit(ctx); err != nil {
+			return written, errors.Wrap(err, "failed to import notes")
+		}
+		written += pending
+	}
+
+	return written, nil
+}
+
+func (fs FS) UserGet(ctx context.Context, token string) (_ common.User, _err error) {
+	defer fs.op(ctx, "UserGet")(&_err)
+
+	claims, err := fs.sec.TokenFrom(ctx, token)
 	if err != nil {
-		return nil, errors.Wrap(err, "corrupted token")
+		return nil, unauthorizedError{errors.Wrap(err, "corrupted token")}
 	}
 
 	id, ok := claims["UserID"].(string)
 	if !ok {
-		return nil, errors.New("invalid token or no user in token")
+		return nil, unauthorizedError{errors.New("invalid token or no user in token")}
 	}
 
 	snap, err := fs.conn.Collection("users").Doc(id).Get(ctx)
+	if status.Code(err) == codes.NotFound {
+		return nil, unauthorizedError{errors.New("user for token no longer exists")}
+	}
 	if err != nil {
 		return nil, errors.Wrap(err, "failed to find user")
 	}
 
-	return fs.snaptouser(ctx, snap)
+	// Tokens from before deletion was requested stop working; only logging in
+	// again (which cancels the deletion) does.
+	if at, err := snap.DataAt("UserDeleteAt"); err == nil && at != int64(0) {
+		return nil, unauthorizedError{errors.New("user is due to be deleted; log in to cancel")}
+	}
+
+	user, err := fs.snaptouser(ctx, snap)
+	if err != nil {
+		return nil, err
+	}
+
+	// Tokens from before the user's sessions were ended carry an older count;
+	// those from before there was one carry none, which matches zero.
+	session, _ := claims["Session"].(float64)
+	if int(session) != user.(*User).UserSessions {
+		return nil, unauthorizedError{errors.New("session ended; log in again")}
+	}
+	if user.(*User).UserLockedByAdmin {
+		return nil, lockedError{errors.New("account locked by an operator")}
+	}
+
+	return user, nil
 }
 
-func (fs FS) UserGetByNumber(ctx context.Context, phone string) (common.User, error) {
+func (fs FS) UserGetByNumber(ctx context.Context, phone string) (_ common.User, _err error) {
+	defer fs.op(ctx, "UserGetByNumber")(&_err)
+
 	iter := fs.conn.Collection("users").Where("UserPhone", "==", phone).Documents(ctx)
 	defer iter.Stop()
 	return fs.itertouser(ctx, iter)
 }
 
-func (fs FS) UserGetByUsername(ctx context.Context, username string) (common.User, error) {
+func (fs FS) UserGetByUsername(ctx context.Context, username string) (_ common.User, _err error) {
+	defer fs.op(ctx, "UserGetByUsername")(&_err)
+
 	iter := fs.conn.Collection("users").Where("UserUsername", "==", username).Documents(ctx)
 	defer iter.Stop()
 	return fs.itertouser(ctx, iter)
 }
 
-func (fs FS) UserLogin(ctx context.Context, username, plaintext string) (common.User, error) {
+func (fs FS) UserLogin(ctx context.Context, username, plaintext string) (_ common.User, _err error) {
+	defer fs.op(ctx, "UserLogin")(&_err)
+
 	iter := fs.conn.Collection("users").Where("UserUsername", "==", username).Documents(ctx)
 	defer iter.Stop()
 
 	doc, err := iter.Next()
+	if err == iterator.Done {
+		return nil, unauthorizedError{errors.New("failed to find user")}
+	}
 	if err != nil {
-		return nil, errors.New("failed to find user")
+		return nil, errors.Wrap(err, "failed to find user")
 	}
 
 	user := User{ref: doc.Ref}
@@ -321,13 +885,28 @@ func (fs FS) UserLogin(ctx context.Context, username, plaintext string) (common.
 		return nil, errors.Wrap(err, "user value corrupted")
 	}
 
+	if user.UserLockedByAdmin {
+		return nil, lockedError{errors.New("account locked by an operator; contact support")}
+	}
+	if user.UserLockedAt != 0 {
+		return nil, lockedError{errors.New("account locked after too many failed logins; reset your password to unlock")}
+	}
+
 	if err := fs.sec.HashCompare(user.ref.ID+plaintext, user.UserEncryptedPassword); err != nil {
-		return nil, errors.New("failed to login user; password hash not matched")
+		return nil, fs.loginFailed(ctx, user.ref)
+	}
+
+	if user.UserFailedLogins > 0 {
+		_, err := user.ref.Update(ctx, []firestore.Update{{Path: "UserFailedLogins", Value: 0}})
+		if err != nil {
+			return nil, errors.Wrap(err, "failed to reset failed logins")
+		}
+		user.UserFailedLogins = 0
 	}
 
-	token, err := fs.sec.TokenCreate(jwt.MapClaims{"UserID": user.ID()})
+	token, err := fs.userToken(ctx, &user)
 	if err != nil {
-		return nil, errors.Wrap(err, "failed to create unique token for user")
+		return nil, err
 	}
 
 	user.token = token
@@ -336,19 +915,161 @@ func (fs FS) UserLogin(ctx context.Context, username, plaintext string) (common.
 	return &user, nil
 }
 
-func (fs FS) UserCreate(ctx context.Context, username, plaintext, phone string) (common.User, error) {
+// loginFailed counts a wrong password against the account and locks it once
+// there have been too many in a row.
+func (fs FS) loginFailed(ctx context.Context, ref *firestore.DocumentRef) error {
+	locked := false
+	err := fs.conn.RunTransaction(ctx, func(ctx context.Context, tx *firestore.Transaction) error {
+		doc, err := tx.Get(ref)
+		if err != nil {
+			return err
+		}
+
+		var user User
+		if err := doc.DataTo(&user); err != nil {
+			return err
+		}
+
+		updates := []firestore.Update{{Path: "UserFailedLogins", Value: user.UserFailedLogins + 1}}
+		if fs.maxFailedLogins > 0 && user.UserFailedLogins+1 >= fs.maxFailedLogins {
+			updates = append(updates, firestore.Update{Path: "UserLockedAt", Value: time.Now().UTC().Unix()})
+			locked = true
+		}
+
+		return tx.Update(ref, updates)
+	})
+	if err != nil {
+		return errors.Wrap(err, "failed to record failed login")
+	}
+
+	if locked {
+		return lockedError{errors.New("too many failed logins; account locked, reset your password to unlock")}
+	}
+	return unauthorizedError{errors.New("failed to login user; password hash not matched")}
+}
+
+// UserSeen remembers the IP and user agent a user signed in from and reports
+// whether either is new. The first device recorded for a user is never new.
+func (fs FS) UserSeen(ctx context.Context, user common.User, ip, agent string) (_ bool, _err error) {
+	defer fs.op(ctx, "UserSeen")(&_err)
+
+	ref := fs.conn.Collection("users").Doc(user.ID())
+	ipHash, agentHash := fingerprint(ip), fingerprint(agent)
+
+	seen := false
+	err := fs.conn.RunTransaction(ctx, func(ctx context.Context, tx *firestore.Transaction) error {
+		doc, err := tx.Get(ref)
+		if err != nil {
+			return err
+		}
+
+		var user User
+		if err := doc.DataTo(&user); err != nil {
+			return err
+		}
+
+		first := len(user.UserKnownIPs) == 0 && len(user.UserKnownAgents) == 0
+		knownIP, knownAgent := contains(user.UserKnownIPs, ipHash), contains(user.UserKnownAgents, agentHash)
+		seen = !first && (!knownIP || !knownAgent)
+
+		if knownIP && knownAgent {
+			return nil
+		}
+
+		return tx.Update(ref, []firestore.Update{
+			{Path: "UserKnownIPs", Value: remember(user.UserKnownIPs, ipHash)},
+			{Path: "UserKnownAgents", Value: remember(user.UserKnownAgents, agentHash)},
+		})
+	})
+	if err != nil {
+		return false, errors.Wrap(err, "failed to record login device")
+	}
+
+	return seen, nil
+}
+
+// UserResetCreate issues a nonce for a password reset link, replacing any
+// issued before it so only the newest link works.
+func (fs FS) UserResetCreate(ctx context.Context, user common.User) (_ string, _err error) {
+	defer fs.op(ctx, "UserResetCreate")(&_err)
+
+	byt := make([]byte, 16)
+	if _, err := rand.Read(byt); err != nil {
+		return "", errors.Wrap(err, "failed to create reset nonce")
+	}
+	nonce := hex.EncodeToString(byt)
+
+	_, err := fs.conn.Collection("users").Doc(user.ID()).Update(ctx, []firestore.Update{
+		{Path: "UserResetNonce", Value: fingerprint(nonce)},
+	})
+	if err != nil {
+		return "", errors.Wrap(err, "failed to store reset nonce")
+	}
+
+	return nonce, nil
+}
+
+// UserResetRedeem uses up the nonce issued by UserResetCreate, returning the
+// user it was issued to.
+func (fs FS) UserResetRedeem(ctx context.Context, id, nonce string) (_ common.User, _err error) {
+	defer fs.op(ctx, "UserResetRedeem")(&_err)
+
+	ref := fs.conn.Collection("users").Doc(id)
+
+	var snap *firestore.DocumentSnapshot
+	err := fs.conn.RunTransaction(ctx, func(ctx context.Context, tx *firestore.Transaction) error {
+		doc, err := tx.Get(ref)
+		if err != nil {
+			return err
+		}
+
+		var user User
+		if err := doc.DataTo(&user); err != nil {
+			return err
+		}
+
+		want, got := []byte(user.UserResetNonce), []byte(fingerprint(nonce))
+		if user.UserResetNonce == "" || subtle.ConstantTimeCompare(want, got) != 1 {
+			return invalidError{errors.New("this link has already been used or a newer one was sent")}
+		}
+
+		snap = doc
+		return tx.Update(ref, []firestore.Update{{Path: "UserResetNonce", Value: ""}})
+	})
+	if status.Code(err) == codes.NotFound {
+		return nil, invalidError{errors.New("user for reset link no longer exists")}
+	}
+	if err != nil {
+		if _, ok := err.(invalidError); ok {
+			return nil, err
+		}
+		return nil, errors.Wrap(err, "failed to redeem reset link")
+	}
+
+	user, err := fs.snaptouser(ctx, snap)
+	if err != nil {
+		return nil, err
+	}
+	user.(*User).UserResetNonce = ""
+
+	return user, nil
+}
+
+func (fs FS) UserCreate(ctx context.Context, username, plaintext, phone string) (_ common.User, _err error) {
+	defer fs.op(ctx, "UserCreate")(&_err)
+
 	// Check username taken.
 	usernameIter := fs.conn.Collection("users").Where("UserUsername", "==", username).Documents(ctx)
 	defer usernameIter.Stop()
 	if _, err := usernameIter.Next(); err != iterator.Done {
-		return nil, errors.New("username already exists")
+		return nil, conflictError{errors.New("username already exists")}
 	}
 
 	// Check phone taken.
 	phoneIter := fs.conn.Collection("users").Where("UserPhone", "==", phone).Documents(ctx)
 	defer phoneIter.Stop()
 	if _, err := phoneIter.Next(); err != iterator.Done {
-		return nil, errors.New("phone already used; try reseting password")
+		return nil, conflictError{errors.New("phone already used; try reseting password")}
 	}
 
 	ref := fs.conn.Collection("users").NewDoc()
@@ -370,9 +1091,9 @@ func (fs FS) UserCreate(ctx context.Context, username, plaintext, phone string)
 		return nil, errors.Wrap(err, "failed to create new user")
 	}
 
-	token, err := fs.sec.TokenCreate(jwt.MapClaims{"UserID": user.ID()})
+	token, err := fs.userToken(ctx, &user)
 	if err != nil {
-		return nil, errors.Wrap(err, "failed to create unique token for user")
+		return nil, err
 	}
 
 	user.token = token
@@ -381,6 +1102,271 @@ func (fs FS) UserCreate(ctx context.Context, username, plaintext, phone string)
 	return &user, nil
 }
 
+// admin
+
+// UserSearch finds up to limit accounts by username prefix, or by phone when
+// query is a full number as stored (i.e. 12085550100).
+func (fs FS) UserSearch(ctx context.Context, query string, limit int) (_ []common.Account, _err error) {
+	defer fs.op(ctx, "UserSearch")(&_err)
+
+	users := fs.conn.Collection("users")
+	q := users.Where("UserPhone", "==", query)
+	if strings.TrimFunc(query, unicode.IsDigit) != "" {
+		q = users.Where("UserUsername", ">=", query).
+			Where("UserUsername", "<", query+"\uf8ff").
+			OrderBy("UserUsername", firestore.Asc)
+	}
+
+	iter := q.Limit(limit).Documents(ctx)
+	defer iter.Stop()
+
+	var ret []common.Account
+	err := fs.eachUser(iter, func(user User) error {
+		ret = append(ret, user.account())
+		return nil
+	})
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to search users")
+	}
+
+	return ret, nil
+}
+
+// UserAccount returns the account with id, counting its notes.
+func (fs FS) UserAccount(ctx context.Context, id string) (_ common.Account, _err error) {
+	defer fs.op(ctx, "UserAccount")(&_err)
+
+	snap, err := fs.conn.Collection("users").Doc(id).Get(ctx)
+	if status.Code(err) == codes.NotFound {
+		return common.Account{}, notFoundError{errors.New("no such user")}
+	}
+	if err != nil {
+		return common.Account{}, errors.Wrap(err, "failed to find user")
+	}
+
+	user := User{ref: snap.Ref}
+	if err := snap.DataTo(&user); err != nil {
+		return common.Account{}, errors.Wrap(err, "user value corrupted")
+	}
+	account := user.account()
+
+	iter := fs.conn.Collection("notes").Where("UserID", "==", id).Select().Documents(ctx)
+	defer iter.Stop()
+	for {
+		_, err := iter.Next()
+		if err == iterator.Done {
+			break
+		}
+		if err != nil {
+			return common.Account{}, errors.Wrap(err, "failed to count notes")
+		}
+		account.Notes++
+	}
+
+	return account, nil
+}
+
+// UserSetLocked locks the account with id until an operator unlocks it, or
+// unlocks it however it was locked.
+func (fs FS) UserSetLocked(ctx context.Context, id string, locked bool) (_err error) {
+	defer fs.op(ctx, "UserSetLocked")(&_err)
+
+	updates := []firestore.Update{
+		{Path: "UserLockedByAdmin", Value: false},
+		{Path: "UserLockedAt", Value: int64(0)},
+		{Path: "UserFailedLogins", Value: 0},
+	}
+	if locked {
+		updates = []firestore.Update{
+			{Path: "UserLockedByAdmin", Value: true},
+			{Path: "UserLockedAt", Value: time.Now().UTC().Unix()},
+		}
+	}
+
+	return fs.userUpdate(ctx, id, updates)
+}
+
+// UserSetAdmin grants or revokes access to /admin.
+func (fs FS) UserSetAdmin(ctx context.Context, id string, admin bool) (_err error) {
+	defer fs.op(ctx, "UserSetAdmin")(&_err)
+
+	return fs.userUpdate(ctx, id, []firestore.Update{{Path: "UserAdmin", Value: admin}})
+}
+
+// UserEndSessions logs the account with id out everywhere: web sessions, the
+// cli and api tokens.
+func (fs FS) UserEndSessions(ctx context.Context, id string) (_err error) {
+	defer fs.op(ctx, "UserEndSessions")(&_err)
+
+	ref := fs.conn.Collection("users").Doc(id)
+	err := fs.conn.RunTransaction(ctx, func(ctx context.Context, tx *firestore.Transaction) error {
+		doc, err := tx.Get(ref)
+		if err != nil {
+			return err
+		}
+
+		var user User
+		if err := doc.DataTo(&user); err != nil {
+			return err
+		}
+
+		return tx.Update(ref, []firestore.Update{{Path: "UserSessions", Value: user.UserSessions + 1}})
+	})
+	if status.Code(err) == codes.NotFound {
+		return notFoundError{errors.New("no such user")}
+	}
+	if err != nil {
+		return errors.Wrap(err, "failed to end sessions")
+	}
+	return nil
+}
+
+func (fs FS) userUpdate(ctx context.Context, id string, updates []firestore.Update) error {
+	_, err := fs.conn.Collection("users").Doc(id).Update(ctx, updates)
+	if status.Code(err) == codes.NotFound {
+		return notFoundError{errors.New("no such user")}
+	}
+	if err != nil {
+		return errors.Wrap(err, "failed to update user")
+	}
+	return nil
+}
+
+// UserSMSSent counts a text sent to user on the UTC day of now, forgetting
+// days older than common.SMSDays.
+func (fs FS) UserSMSSent(ctx context.Context, user common.User, now time.Time) (_err error) {
+	defer fs.op(ctx, "UserSMSSent")(&_err)
+
+	ref := fs.conn.Collection("users").Doc(user.ID())
+	today := now.UTC().Format("2006-01-02")
+	oldest := now.UTC().AddDate(0, 0, 1-common.SMSDays).Format("2006-01-02")
+
+	var sent map[string]int
+	err := fs.conn.RunTransaction(ctx, func(ctx context.Context, tx *firestore.Transaction) error {
+		doc, err := tx.Get(ref)
+		if err != nil {
+			return err
+		}
+
+		var stored User
+		if err := doc.DataTo(&stored); err != nil {
+			return err
+		}
+
+		sent = map[string]int{today: 1}
+		for day, n := range stored.UserSMSSent {
+			if day == today {
+				sent[day] += n
+			} else if day >= oldest {
+				sent[day] = n
+			}
+		}
+
+		return tx.Update(ref, []firestore.Update{{Path: "UserSMSSent", Value: sent}})
+	})
+	if err != nil {
+		return errors.Wrap(err, "failed to count sms sent")
+	}
+
+	// Keep a later Save from writing back the old counts.
+	if u, ok := user.(*User); ok {
+		u.UserSMSSent = sent
+	}
+	return nil
+}
+
+// audit
+
+// AuditAppend records event. Events are never changed or deleted, and outlive
+// the account they are about.
+func (fs FS) AuditAppend(ctx context.Context, event common.AuditEvent) (_err error) {
+	defer fs.op(ctx, "AuditAppend")(&_err)
+
+	audit := Audit{
+		AuditType:      string(event.Type),
+		AuditUsername:  event.Username,
+		AuditIP:        event.IP,
+		AuditUserAgent: event.UserAgent,
+		AuditDetail:    event.Detail,
+		AuditActor:     event.Actor,
+		AuditAt:        event.At.Unix(),
+		UserID:         event.UserID,
+	}
+	if _, err := fs.conn.Collection("audit").NewDoc().Create(ctx, audit); err != nil {
+		return errors.Wrap(err, "failed to record audit event")
+	}
+
+	return nil
+}
+
+// AuditList returns the newest limit events about the user with id, or about
+// every user when id is empty. A limit of zero returns them all.
+func (fs FS) AuditList(ctx context.Context, id string, limit int) (_ []common.AuditEvent, _err error) {
+	defer fs.op(ctx, "AuditList")(&_err)
+
+	q := fs.conn.Collection("audit").Query
+	if id != "" {
+		q = q.Where("UserID", "==", id)
+	}
+	q = q.OrderBy("AuditAt", firestore.Desc)
+	if limit > 0 {
+		q = q.Limit(limit)
+	}
+
+	iter := q.Documents(ctx)
+	defer iter.Stop()
+
+	var ret []common.AuditEvent
+	for {
+		doc, err := iter.Next()
+		if err == iterator.Done {
+			break
+		}
+		if err != nil {
+			return nil, errors.Wrap(err, "failed to read audit events")
+		}
+
+		var audit Audit
+		if err := doc.DataTo(&audit); err != nil {
+			return nil, errors.Wrap(err, "audit event value corrupted")
+		}
+		ret = append(ret, audit.event())
+	}
+
+	return ret, nil
+}
+
+// devices
+
+const maxKnownDevices = 20
+
+// fingerprint keeps IPs and user agents out of the database in the clear.
+func fingerprint(value string) string {
+	sum := sha256.Sum256([]byte(value))
+	return hex.EncodeToString(sum[:16])
+}
+
+func contains(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
+
+// remember appends value, if new, keeping only the most recent entries.
+func remember(values []string, value string) []string {
+	if contains(values, value) {
+		return values
+	}
+	values = append(values, value)
+	if len(values) > maxKnownDevices {
+		values = values[len(values)-maxKnownDevices:]
+	}
+	return values
+}
+
 // user type
 
 type User struct {
@@ -389,22 +1375,109 @@ type User struct {
 	UserPhone             string
 	UserEncryptedPassword string
 	UserCreatedAt         int64
+	UserFailedLogins      int
+	UserLockedAt          int64    /* Zero unless locked. */
+	UserKnownIPs          []string /* Fingerprints, most recent last. */
+	UserKnownAgents       []string /* Fingerprints, most recent last. */
+	UserResetNonce        string   /* Fingerprint of the only valid reset link. */
+	UserRetentionDays     int      /* See common.Retention; at most one is set. */
+	UserRetentionKeep     int
+	UserDeleteAt          int64 /* Zero unless deletion was requested. */
+	UserAdmin             bool
+	UserLockedByAdmin     bool           /* Kept locked through password resets. */
+	UserSessions          int            /* Tokens carry it; bumped to end every session. */
+	UserSMSSent           map[string]int /* See common.Account. */
 
 	// Set when retrieved:
 	token string
 	fs    FS
 
 	// Set while updating
-	err error
+	err     error
+	updates []firestore.Update /* Fields the setters changed, for Save. */
 }
 
 func (user *User) Username() string { return user.UserUsername }
 func (user *User) Phone() string    { return user.UserPhone }
 func (user *User) ID() string       { return user.ref.ID }
 func (user *User) Token() string    { return user.token }
+func (user *User) CreatedAt() time.Time {
+	return time.Unix(user.UserCreatedAt, 0).UTC()
+}
+func (user *User) Admin() bool { return user.UserAdmin }
+
+func (user *User) account() common.Account {
+	account := common.Account{
+		ID:            user.ID(),
+		Username:      user.UserUsername,
+		Phone:         user.UserPhone,
+		CreatedAt:     user.CreatedAt(),
+		Admin:         user.UserAdmin,
+		FailedLogins:  user.UserFailedLogins,
+		LockedByAdmin: user.UserLockedByAdmin,
+		DeleteAt:      user.DeleteAt(),
+		SMSSent:       user.UserSMSSent,
+	}
+	if user.UserLockedAt != 0 {
+		account.LockedAt = time.Unix(user.UserLockedAt, 0).UTC()
+	}
+	return account
+}
+
+// update records a field a setter changed. Save writes only those, so it
+// can't undo what other paths wrote since the user was read, i.e. a login
+// failure, an admin lock or the end of every session.
+func (user *User) update(path string, value interface{}) {
+	for i := range user.updates {
+		if user.updates[i].Path == path {
+			user.updates[i].Value = value
+			return
+		}
+	}
+	user.updates = append(user.updates, firestore.Update{Path: path, Value: value})
+}
 
-func (user *User) SetUsername(value string) { user.UserUsername = value }
-func (user *User) SetPhone(value string)    { user.UserPhone = value }
+func (user *User) SetUsername(value string) {
+	user.UserUsername = value
+	user.update("UserUsername", value)
+}
+
+func (user *User) SetPhone(value string) {
+	user.UserPhone = value
+	user.update("UserPhone", value)
+}
+
+func (user *User) Retention() common.Retention {
+	return common.Retention{Days: user.UserRetentionDays, Keep: user.UserRetentionKeep}
+}
+
+func (user *User) SetRetention(value common.Retention) {
+	user.UserRetentionDays, user.UserRetentionKeep = value.Days, value.Keep
+	user.update("UserRetentionDays", value.Days)
+	user.update("UserRetentionKeep", value.Keep)
+}
+
+func (user *User) DeleteAt() time.Time {
+	if user.UserDeleteAt == 0 {
+		return time.Time{}
+	}
+	return time.Unix(user.UserDeleteAt, 0).UTC()
+}
+
+func (user *User) SetDeleteAt(value time.Time) {
+	user.UserDeleteAt = 0
+	if !value.IsZero() {
+		user.UserDeleteAt = value.Unix()
+	}
+	user.update("UserDeleteAt", user.UserDeleteAt)
+}
+
+func (user *User) Unlock() {
+	user.UserFailedLogins = 0
+	user.UserLockedAt = 0
+	user.update("UserFailedLogins", 0)
+	user.update("UserLockedAt", int64(0))
+}
 
 func (user *User) SetPass(plaintext string) {
 	if user.err != nil {
@@ -418,17 +1491,28 @@ func (user *User) SetPass(plaintext string) {
 	}
 
 	user.UserEncryptedPassword = pass
+	user.update("UserEncryptedPassword", pass)
 }
 
-func (user *User) Save(ctx context.Context) error {
+func (user *User) Save(ctx context.Context) (_err error) {
+	defer user.fs.op(ctx, "UserSave")(&_err)
+
 	if user.err != nil {
 		return user.err
 	}
+	if len(user.updates) == 0 {
+		return nil
+	}
 
-	if _, err := user.fs.conn.Collection("users").Doc(user.ID()).Set(ctx, user); err != nil {
+	_, err := user.fs.conn.Collection("users").Doc(user.ID()).Update(ctx, user.updates)
+	if status.Code(err) == codes.NotFound {
+		return notFoundError{errors.New("user no longer exists")}
+	}
+	if err != nil {
 		return errors.Wrap(err, "failed to update user")
 	}
 
+	user.updates = nil
 	return nil
 }
 
@@ -439,6 +1523,13 @@ type Note struct {
 	NoteText      string
 	NoteShort     string
 	NoteCreatedAt int64
+	NoteEncrypted bool  /* NoteText is ciphertext from pkg/e2e. */
+	NoteExpiresAt int64 /* Zero unless created with a TTL. */
+	NoteBurn      bool
+
+	// Encryption at rest, empty for notes stored before it:
+	NoteKey   string `json:"-"` /* Data key, wrapped by master key NoteKeyID. */
+	NoteKeyID string `json:"-"`
 
 	// Relations:
 	UserID string
@@ -448,7 +1539,48 @@ type Note struct {
 	fs    FS
 }
 
-func (Note Note) Short() string { return Note.NoteShort }
-func (Note Note) Text() string  { return Note.NoteText }
-func (Note Note) ID() string    { return Note.ref.ID }
-func (Note Note) Token() string { return Note.token }
+func (Note Note) Short() string   { return Note.NoteShort }
+func (Note Note) Text() string    { return Note.NoteText }
+func (Note Note) ID() string      { return Note.ref.ID }
+func (Note Note) Token() string   { return Note.token }
+func (Note Note) Encrypted() bool { return Note.NoteEncrypted }
+func (Note Note) Burn() bool      { return Note.NoteBurn }
+func (Note Note) CreatedAt() time.Time {
+	return time.Unix(Note.NoteCreatedAt, 0).UTC()
+}
+func (Note Note) ExpiresAt() time.Time {
+	if Note.NoteExpiresAt == 0 {
+		return time.Time{}
+	}
+	return time.Unix(Note.NoteExpiresAt, 0).UTC()
+}
+
+// audit type
+
+// Audit is stored as is; unlike UserKnownIPs the IP and user agent are kept in
+// the clear so users can recognise them.
+type Audit struct {
+	AuditType      string
+	AuditUsername  string
+	AuditIP        string
+	AuditUserAgent string
+	AuditDetail    string
+	AuditActor     string
+	AuditAt        int64
+
+	// Relations:
+	UserID string
+}
+
+func (audit Audit) event() common.AuditEvent {
+	return common.AuditEvent{
+		Type:      common.AuditType(audit.AuditType),
+		UserID:    audit.UserID,
+		Username:  audit.AuditUsername,
+		IP:        audit.AuditIP,
+		UserAgent: audit.AuditUserAgent,
+		Detail:    audit.AuditDetail,
+		Actor:     audit.AuditActor,
+		At:        time.Unix(audit.AuditAt, 0).UTC(),
+	}
+}
@@ -0,0 +1,126 @@
+// Package logger writes one JSON object per line. A logger carrying the
+// request ID travels in the context, so what the webhook, storage and sms do
+// for one request can be found together. Fields that could hold a phone
+// number, password, token or note text are redacted before writing.
+package logger
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+type Fields map[string]interface{}
+
+type Logger struct {
+	mu     *sync.Mutex /* Shared by everything derived with With. */
+	w      io.Writer
+	fields Fields
+	now    func() time.Time
+}
+
+func Default(w io.Writer) *Logger {
+	return &Logger{&sync.Mutex{}, w, Fields{}, time.Now}
+}
+
+// std is used where no logger is in the context, i.e. background jobs.
+var std = Default(os.Stderr)
+
+// With returns a logger adding fields to every line.
+func (l *Logger) With(fields Fields) *Logger {
+	merged := make(Fields, len(l.fields)+len(fields))
+	for k, v := range l.fields {
+		merged[k] = v
+	}
+	for k, v := range fields {
+		merged[k] = v
+	}
+	return &Logger{l.mu, l.w, merged, l.now}
+}
+
+func (l *Logger) Info(msg string, fields Fields) { l.write("info", msg, nil, fields) }
+
+func (l *Logger) Warn(msg string, fields Fields) { l.write("warn", msg, nil, fields) }
+
+// Error logs err with each error it wraps under "causes".
+func (l *Logger) Error(msg string, err error, fields Fields) { l.write("error", msg, err, fields) }
+
+func (l *Logger) write(level, msg string, err error, fields Fields) {
+	line := make(Fields, len(l.fields)+len(fields)+5)
+	for k, v := range l.fields {
+		line[k] = redact(k, v)
+	}
+	for k, v := range fields {
+		line[k] = redact(k, v)
+	}
+	line["time"] = l.now().UTC().Format(time.RFC3339Nano)
+	line["level"] = level
+	line["msg"] = Scrub(msg)
+	if err != nil {
+		line["error"] = Scrub(err.Error())
+		line["causes"] = Causes(err)
+	}
+
+	byt, jerr := json.Marshal(line)
+	if jerr != nil {
+		byt, _ = json.Marshal(Fields{"level": "error", "msg": "failed to encode log line", "error": jerr.Error()})
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	_, _ = l.w.Write(append(byt, '\n'))
+}
+
+// Causes lists the message each layer of err adds, outermost first, with the
+// innermost error's type so the source (i.e. firestore or twilio) is clear.
+func Causes(err error) []string {
+	var causes []string
+	for err != nil {
+		next := unwrap(err)
+		msg := err.Error()
+		if next != nil {
+			msg = strings.TrimSuffix(msg, next.Error())
+			msg = strings.TrimSuffix(msg, ": ")
+		} else {
+			msg = fmt.Sprintf("%s (%T)", msg, err)
+		}
+		if msg != "" {
+			causes = append(causes, Scrub(msg))
+		}
+		err = next
+	}
+	return causes
+}
+
+// unwrap understands both github.com/pkg/errors and fmt's %w.
+func unwrap(err error) error {
+	switch err := err.(type) {
+	case interface{ Cause() error }:
+		return err.Cause()
+	case interface{ Unwrap() error }:
+		return err.Unwrap()
+	}
+	return nil
+}
+
+// The key travels as a plain string, as gin.Context only looks up those.
+const contextKey = "smscp.logger"
+
+func NewContext(ctx context.Context, l *Logger) context.Context {
+	return context.WithValue(ctx, contextKey, l)
+}
+
+// From returns the logger in ctx, or the default one writing to stderr.
+func From(ctx context.Context) *Logger {
+	if ctx != nil {
+		if l, ok := ctx.Value(contextKey).(*Logger); ok {
+			return l
+		}
+	}
+	return std
+}
@@ -0,0 +1,102 @@
+package logger
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/pkg/errors"
+	"gopkg.in/go-playground/assert.v1"
+)
+
+func lines(t *testing.T, buf *bytes.Buffer) []map[string]interface{} {
+	var out []map[string]interface{}
+	for _, line := range strings.Split(strings.TrimSpace(buf.String()), "\n") {
+		var fields map[string]interface{}
+		if err := json.Unmarshal([]byte(line), &fields); err != nil {
+			t.Fatalf("not json: %q", line)
+		}
+		out = append(out, fields)
+	}
+	return out
+}
+
+func TestRedact(t *testing.T) {
+	var buf bytes.Buffer
+	l := Default(&buf)
+	l.now = func() time.Time { return time.Unix(0, 0) }
+
+	l.With(Fields{"user": "alice"}).Info("texted +1 208-555-0100", Fields{
+		"phone":    "12085550100",
+		"Password": "hunter2",
+		"to":       "12085550100",
+		"text":     "my secret note",
+		"reset":    "/reset/eyJhbGciOiJIUzI1NiJ9.eyJzdWIiOiIxIn0.sig",
+		"notes":    3,
+	})
+
+	out := lines(t, &buf)[0]
+	assert.Equal(t, "info", out["level"])
+	assert.Equal(t, "1970-01-01T00:00:00Z", out["time"])
+	assert.Equal(t, "alice", out["user"])
+	assert.Equal(t, "texted [redacted]", out["msg"])
+	assert.Equal(t, redacted, out["phone"])
+	assert.Equal(t, redacted, out["Password"])
+	assert.Equal(t, redacted, out["to"])
+	assert.Equal(t, redacted, out["text"])
+	assert.Equal(t, "/reset/[redacted]", out["reset"])
+	assert.Equal(t, float64(3), out["notes"])
+}
+
+func TestCauses(t *testing.T) {
+	var buf bytes.Buffer
+	root := errors.New("rpc error: code = Unavailable")
+	err := errors.Wrap(errors.Wrap(root, "failed to find user"), "failed to login")
+
+	Default(&buf).Error("request failed", err, nil)
+
+	out := lines(t, &buf)[0]
+	assert.Equal(t, "failed to login: failed to find user: rpc error: code = Unavailable", out["error"])
+	assert.Equal(t, []interface{}{"failed to login", "failed to find user", "rpc error: code = Unavailable (*errors.fundamental)"}, out["causes"])
+}
+
+func TestMiddleware(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	var buf bytes.Buffer
+
+	router := gin.New()
+	router.Use(Middleware(Default(&buf)))
+	router.GET("/fail", func(c *gin.Context) {
+		From(c).Info("handling", nil)
+		_ = c.Error(errors.Wrap(errors.New("unavailable"), "failed to save"))
+		c.Status(http.StatusInternalServerError)
+	})
+
+	w := httptest.NewRecorder()
+	req, _ := http.NewRequest("GET", "/fail?token=abc", nil)
+	router.ServeHTTP(w, req)
+	id := w.Header().Get(RequestIDHeader)
+	assert.Equal(t, 16, len(id))
+
+	out := lines(t, &buf)
+	assert.Equal(t, 3, len(out))
+	for _, line := range out {
+		assert.Equal(t, id, line["request_id"])
+	}
+	assert.Equal(t, "handling", out[0]["msg"])
+	assert.Equal(t, "error", out[1]["level"])
+	assert.Equal(t, []interface{}{"failed to save", "unavailable (*errors.fundamental)"}, out[1]["causes"])
+	assert.Equal(t, "/fail", out[2]["path"])
+	assert.Equal(t, float64(500), out[2]["status"])
+
+	// A well formed incoming ID is kept.
+	req.Header.Set(RequestIDHeader, "from-the-balancer")
+	w = httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+	assert.Equal(t, "from-the-balancer", w.Header().Get(RequestIDHeader))
+}
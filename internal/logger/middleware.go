@@ -0,0 +1,69 @@
+package logger
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"net/http"
+	"regexp"
+
+	"github.com/gin-gonic/gin"
+	"smscp.xyz/internal/clientip"
+)
+
+const RequestIDHeader = "X-Request-ID"
+
+// An incoming request ID, i.e. from a load balancer, is kept if it looks like
+// one.
+var requestID = regexp.MustCompile(`^[\w\-]{8,64}$`)
+
+// Middleware gives each request an ID, returned as X-Request-ID, and a logger
+// carrying it in the request's context. Once the request is handled it logs
+// one line for it, and one for each error handlers recorded with c.Error.
+func Middleware(l *Logger) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		start := l.now()
+
+		id := c.GetHeader(RequestIDHeader)
+		if !requestID.MatchString(id) {
+			id = newID()
+		}
+		c.Header(RequestIDHeader, id)
+
+		rl := l.With(Fields{"request_id": id})
+		c.Set(contextKey, rl)
+		c.Request = c.Request.WithContext(NewContext(c.Request.Context(), rl))
+
+		c.Next()
+
+		status := c.Writer.Status()
+		for _, e := range c.Errors {
+			if status >= http.StatusInternalServerError {
+				rl.Error("request failed", e.Err, Fields{"status": status})
+			} else {
+				rl.Warn("request rejected", Fields{"status": status, "error": e.Err, "causes": Causes(e.Err)})
+			}
+		}
+
+		fields := Fields{
+			"method":     c.Request.Method,
+			"path":       c.Request.URL.Path, /* Without the query, which may hold tokens. */
+			"status":     status,
+			"bytes":      c.Writer.Size(),
+			"ip":         clientip.Get(c),
+			"latency_ms": float64(l.now().Sub(start).Microseconds()) / 1000,
+		}
+		if status >= http.StatusInternalServerError {
+			rl.Warn("request", fields)
+		} else {
+			rl.Info("request", fields)
+		}
+	}
+}
+
+func newID() string {
+	byt := make([]byte, 8)
+	if _, err := rand.Read(byt); err != nil {
+		return "unknown"
+	}
+	return hex.EncodeToString(byt)
+}
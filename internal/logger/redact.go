@@ -0,0 +1,57 @@
+package logger
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+const redacted = "[redacted]"
+
+// sensitive are substrings of field names whose values are never logged, and
+// exact those that are too short to match as substrings.
+var (
+	sensitive = []string{"phone", "number", "pass", "token", "secret", "text", "body", "authorization", "cookie", "nonce", "hash"}
+	exact     = map[string]bool{"to": true, "from": true}
+)
+
+// plain fields are set by this package and logged as is.
+var plain = map[string]bool{"request_id": true}
+
+var (
+	phone = regexp.MustCompile(`\+?\d[\d\- ]{6,}\d`)
+	jwt   = regexp.MustCompile(`eyJ[\w-]+\.[\w-]+\.[\w-]*`)
+)
+
+// redact hides the value of a sensitive field, and scrubs strings of others.
+func redact(key string, value interface{}) interface{} {
+	lower := strings.ToLower(key)
+	if plain[lower] {
+		return value
+	}
+	if exact[lower] {
+		return redacted
+	}
+	for _, s := range sensitive {
+		if strings.Contains(lower, s) {
+			return redacted
+		}
+	}
+
+	switch v := value.(type) {
+	case string:
+		return Scrub(v)
+	case error:
+		return Scrub(v.Error())
+	case fmt.Stringer:
+		return Scrub(v.String())
+	}
+	return value
+}
+
+// Scrub hides phone numbers and tokens inside free text such as messages and
+// errors, which may quote what failed.
+func Scrub(s string) string {
+	s = jwt.ReplaceAllString(s, redacted)
+	return phone.ReplaceAllString(s, redacted)
+}
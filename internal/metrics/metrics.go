@@ -0,0 +1,156 @@
+// Package metrics exposes Prometheus metrics: request latency per route, notes
+// created per channel, sms sends per provider and outcome, webhook rejects and
+// storage latency per operation.
+package metrics
+
+import (
+	"context"
+	"crypto/subtle"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/pkg/errors"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+type Metrics struct {
+	registry *prometheus.Registry
+	requests *prometheus.HistogramVec
+	notes    *prometheus.CounterVec
+	sms      *prometheus.CounterVec
+	rejects  *prometheus.CounterVec
+	storage  *prometheus.HistogramVec
+}
+
+// Default registers the metrics, with the Go runtime and process collectors,
+// on a registry of its own.
+func Default() *Metrics {
+	m := &Metrics{
+		registry: prometheus.NewRegistry(),
+		requests: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Name:    "smscp_http_request_duration_seconds",
+			Help:    "HTTP request latency by route.",
+			Buckets: prometheus.DefBuckets,
+		}, []string{"method", "route", "status"}),
+		notes: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "smscp_notes_created_total",
+			Help: "Notes created by channel: web, cli, api, sms or import.",
+		}, []string{"channel"}),
+		sms: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "smscp_sms_sent_total",
+			Help: "SMS sends by provider and result; failures by error class.",
+		}, []string{"provider", "result", "class"}),
+		rejects: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "smscp_webhook_rejected_total",
+			Help: "Inbound sms webhooks not turned into a note, by reason.",
+		}, []string{"reason"}),
+		storage: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Name:    "smscp_storage_operation_duration_seconds",
+			Help:    "Storage operation latency by operation and result.",
+			Buckets: prometheus.DefBuckets,
+		}, []string{"op", "result"}),
+	}
+
+	m.registry.MustRegister(
+		prometheus.NewGoCollector(),
+		prometheus.NewProcessCollector(prometheus.ProcessCollectorOpts{}),
+		m.requests, m.notes, m.sms, m.rejects, m.storage,
+	)
+	return m
+}
+
+// Handler serves the metrics to requests bearing token. With no token
+// configured they aren't served at all.
+func (m *Metrics) Handler(token string) gin.HandlerFunc {
+	serve := promhttp.HandlerFor(m.registry, promhttp.HandlerOpts{})
+	return func(c *gin.Context) {
+		if token == "" {
+			c.String(http.StatusNotFound, "metrics disabled; set METRICS_TOKEN")
+			return
+		}
+
+		want := []byte("Bearer " + token)
+		if subtle.ConstantTimeCompare([]byte(c.GetHeader("Authorization")), want) != 1 {
+			c.Header("WWW-Authenticate", `Bearer realm="metrics"`)
+			c.String(http.StatusUnauthorized, "invalid or missing bearer token")
+			return
+		}
+
+		serve.ServeHTTP(c.Writer, c.Request)
+	}
+}
+
+// Middleware times each request, labelled by the route it matched rather than
+// its path so ids don't each become a series. router is where the routes are
+// registered, which happens after this is added.
+func (m *Metrics) Middleware(router *gin.Engine) gin.HandlerFunc {
+	var (
+		once   sync.Once
+		routes map[string]string /* method and handler name to route */
+	)
+
+	return func(c *gin.Context) {
+		start := time.Now()
+		c.Next()
+
+		once.Do(func() {
+			routes = map[string]string{}
+			for _, route := range router.Routes() {
+				key := route.Method + " " + route.Handler
+				if _, ok := routes[key]; !ok {
+					routes[key] = route.Path
+				}
+			}
+		})
+
+		route, ok := routes[c.Request.Method+" "+c.HandlerName()]
+		if !ok {
+			route = "unmatched"
+		}
+
+		m.requests.
+			WithLabelValues(c.Request.Method, route, strconv.Itoa(c.Writer.Status())).
+			Observe(time.Since(start).Seconds())
+	}
+}
+
+func (m *Metrics) NoteCreated(channel string, n int) {
+	m.notes.WithLabelValues(channel).Add(float64(n))
+}
+
+func (m *Metrics) WebhookRejected(reason string) {
+	m.rejects.WithLabelValues(reason).Inc()
+}
+
+// Observe times a storage operation; it satisfies fs.Observer.
+func (m *Metrics) Observe(ctx context.Context, op string) func(error) {
+	start := time.Now()
+	return func(err error) {
+		result := "ok"
+		if err != nil {
+			result = "error"
+		}
+		m.storage.WithLabelValues(op, result).Observe(time.Since(start).Seconds())
+	}
+}
+
+// Class names the kind of a failed send by its behaviour, as the api package
+// classifies errors.
+func Class(err error) string {
+	switch cause := errors.Cause(err).(type) {
+	case interface{ Rejected() bool }:
+		if cause.Rejected() {
+			return "rejected"
+		}
+	case interface{ Timeout() bool }:
+		if cause.Timeout() {
+			return "timeout"
+		}
+		return "network"
+	}
+	return "other"
+}
@@ -0,0 +1,88 @@
+package metrics
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/pkg/errors"
+	"gopkg.in/go-playground/assert.v1"
+)
+
+type fakeSender struct {
+	sender
+	err error
+}
+
+func (s fakeSender) Send(ctx context.Context, number, text string) error { return s.err }
+
+type rejected struct{ error }
+
+func (rejected) Rejected() bool { return true }
+
+func scrape(m *Metrics, token string) *httptest.ResponseRecorder {
+	router := gin.New()
+	router.GET("/metrics", m.Handler(token))
+
+	w := httptest.NewRecorder()
+	req, _ := http.NewRequest("GET", "/metrics", nil)
+	req.Header.Set("Authorization", "Bearer secret")
+	router.ServeHTTP(w, req)
+	return w
+}
+
+func TestHandler(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	m := Default()
+
+	assert.Equal(t, http.StatusNotFound, scrape(m, "").Code)
+	assert.Equal(t, http.StatusUnauthorized, scrape(m, "other").Code)
+
+	m.NoteCreated("sms", 1)
+	w := scrape(m, "secret")
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, true, strings.Contains(w.Body.String(), `smscp_notes_created_total{channel="sms"} 1`))
+}
+
+func TestMiddleware(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	m := Default()
+
+	router := gin.New()
+	router.Use(m.Middleware(router))
+	router.GET("/notes/:id", func(c *gin.Context) { c.Status(http.StatusOK) })
+
+	for _, path := range []string{"/notes/1", "/notes/2", "/missing"} {
+		req, _ := http.NewRequest("GET", path, nil)
+		router.ServeHTTP(httptest.NewRecorder(), req)
+	}
+
+	body := scrape(m, "secret").Body.String()
+	assert.Equal(t, true, strings.Contains(body, `smscp_http_request_duration_seconds_count{method="GET",route="/notes/:id",status="200"} 2`))
+	assert.Equal(t, true, strings.Contains(body, `smscp_http_request_duration_seconds_count{method="GET",route="unmatched",status="404"} 1`))
+}
+
+func TestSMSAndStorage(t *testing.T) {
+	m := Default()
+
+	_ = m.SMS("twilio", fakeSender{}).Send(context.Background(), "12085550100", "hi")
+	_ = m.SMS("twilio", fakeSender{err: errors.Wrap(rejected{errors.New("invalid number")}, "failed to send message")}).Send(context.Background(), "1", "hi")
+	_ = m.SMS("twilio", fakeSender{err: &net.OpError{Op: "dial", Err: errors.New("refused")}}).Send(context.Background(), "1", "hi")
+	m.Observe(context.Background(), "NoteGet")(nil)
+	m.Observe(context.Background(), "NoteGet")(errors.New("unavailable"))
+
+	body := scrape(m, "secret").Body.String()
+	for _, want := range []string{
+		`smscp_sms_sent_total{class="",provider="twilio",result="success"} 1`,
+		`smscp_sms_sent_total{class="rejected",provider="twilio",result="failure"} 1`,
+		`smscp_sms_sent_total{class="network",provider="twilio",result="failure"} 1`,
+		`smscp_storage_operation_duration_seconds_count{op="NoteGet",result="ok"} 1`,
+		`smscp_storage_operation_duration_seconds_count{op="NoteGet",result="error"} 1`,
+	} {
+		assert.Equal(t, true, strings.Contains(body, want))
+	}
+}
@@ -0,0 +1,34 @@
+package metrics
+
+import (
+	"context"
+
+	"github.com/gin-gonic/gin"
+)
+
+type sender interface {
+	Send(ctx context.Context, number, text string) error
+	Hook(c *gin.Context) (number, text string, err error)
+	Check() error
+}
+
+// SMS counts the sends of the provider it wraps.
+type SMS struct {
+	sender
+	provider string
+	metrics  *Metrics
+}
+
+func (m *Metrics) SMS(provider string, sms sender) SMS {
+	return SMS{sms, provider, m}
+}
+
+func (s SMS) Send(ctx context.Context, number, text string) error {
+	err := s.sender.Send(ctx, number, text)
+	if err != nil {
+		s.metrics.sms.WithLabelValues(s.provider, "failure", Class(err)).Inc()
+	} else {
+		s.metrics.sms.WithLabelValues(s.provider, "success", "").Inc()
+	}
+	return err
+}
@@ -0,0 +1,80 @@
+package memory
+
+import (
+	"context"
+	"math"
+	"sync"
+	"time"
+
+	"smscp.xyz/internal/ratelimit"
+)
+
+type bucket struct {
+	tokens float64
+	last   time.Time
+	per    time.Duration /* Full again this long after last. */
+}
+
+// Store keeps buckets in process. Every serverless instance gets its own
+// buckets, so use redis anywhere more than one instance runs.
+type Store struct {
+	mu      *sync.Mutex
+	buckets map[string]*bucket
+	now     func() time.Time
+}
+
+func Default() Store {
+	return WithClock(time.Now)
+}
+
+func WithClock(now func() time.Time) Store {
+	return Store{&sync.Mutex{}, map[string]*bucket{}, now}
+}
+
+func (s Store) Take(ctx context.Context, key string, limit ratelimit.Limit) (time.Duration, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := s.now()
+	rate := float64(limit.Burst) / float64(limit.Per) /* tokens per nanosecond */
+
+	b, ok := s.buckets[key]
+	if !ok {
+		b = &bucket{float64(limit.Burst), now, limit.Per}
+		s.buckets[key] = b
+	}
+
+	b.tokens = math.Min(float64(limit.Burst), b.tokens+float64(now.Sub(b.last))*rate)
+	b.last, b.per = now, limit.Per
+
+	if b.tokens >= 1 {
+		b.tokens--
+		s.sweep(now)
+		return 0, nil
+	}
+
+	return time.Duration(math.Ceil((1 - b.tokens) / rate)), nil
+}
+
+func (s Store) Refund(ctx context.Context, key string, limit ratelimit.Limit) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if b, ok := s.buckets[key]; ok {
+		b.tokens = math.Min(float64(limit.Burst), b.tokens+1)
+	}
+	return nil
+}
+
+// sweep drops buckets that have refilled, each by its own limit, so the map
+// doesn't grow with every IP we've ever seen.
+func (s Store) sweep(now time.Time) {
+	if len(s.buckets) < 10000 {
+		return
+	}
+	for key, b := range s.buckets {
+		if now.Sub(b.last) > b.per {
+			delete(s.buckets, key)
+		}
+	}
+}
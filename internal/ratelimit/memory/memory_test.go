@@ -0,0 +1,95 @@
+package memory_test
+
+import (
+	"context"
+	"strconv"
+	"testing"
+	"time"
+
+	"gopkg.in/go-playground/assert.v1"
+	"smscp.xyz/internal/ratelimit"
+	"smscp.xyz/internal/ratelimit/memory"
+)
+
+func TestBucket(t *testing.T) {
+	now := time.Unix(0, 0)
+	store := memory.WithClock(func() time.Time { return now })
+	limit := ratelimit.Limit{Burst: 2, Per: time.Minute}
+	ctx := context.Background()
+
+	for i := 0; i < 2; i++ {
+		wait, err := store.Take(ctx, "key", limit)
+		assert.Equal(t, nil, err)
+		assert.Equal(t, time.Duration(0), wait)
+	}
+
+	wait, _ := store.Take(ctx, "key", limit)
+	assert.Equal(t, 30*time.Second, wait)
+
+	// other keys are unaffected
+	wait, _ = store.Take(ctx, "other", limit)
+	assert.Equal(t, time.Duration(0), wait)
+
+	// one token back after half the period
+	now = now.Add(30 * time.Second)
+	wait, _ = store.Take(ctx, "key", limit)
+	assert.Equal(t, time.Duration(0), wait)
+	wait, _ = store.Take(ctx, "key", limit)
+	assert.Equal(t, 30*time.Second, wait)
+}
+
+func TestLimiter(t *testing.T) {
+	limits, err := ratelimit.ParseLimits("login_username=1/1h,login_ip=0/1s")
+	assert.Equal(t, nil, err)
+
+	limiter := ratelimit.Default(memory.Default(), limits)
+	ctx := context.Background()
+
+	assert.Equal(t, nil, limiter.Login(ctx, "1.1.1.1", "alice"))
+
+	// same username, different ip
+	err = limiter.Login(ctx, "2.2.2.2", "Alice")
+	limited, ok := err.(ratelimit.Error)
+	assert.Equal(t, true, ok)
+	assert.Equal(t, true, limited.RetryAfter() > 59*time.Minute)
+}
+
+// A request the second bucket rejects leaves the first one untouched.
+func TestLimiterRefund(t *testing.T) {
+	limits, err := ratelimit.ParseLimits("login_ip=2/1h,login_username=1/1h")
+	assert.Equal(t, nil, err)
+
+	limiter := ratelimit.Default(memory.Default(), limits)
+	ctx := context.Background()
+
+	assert.Equal(t, nil, limiter.Login(ctx, "1.1.1.1", "alice"))
+	for i := 0; i < 3; i++ {
+		_, ok := limiter.Login(ctx, "1.1.1.1", "alice").(ratelimit.Error)
+		assert.Equal(t, true, ok)
+	}
+
+	// The ip still has its second token.
+	assert.Equal(t, nil, limiter.Login(ctx, "1.1.1.1", "bob"))
+	_, ok := limiter.Login(ctx, "1.1.1.1", "carol").(ratelimit.Error)
+	assert.Equal(t, true, ok)
+}
+
+// Sweeping for a per-minute limit must not reset a daily one that is idle.
+func TestSweepKeepsLongerLimits(t *testing.T) {
+	now := time.Unix(0, 0)
+	store := memory.WithClock(func() time.Time { return now })
+	ctx := context.Background()
+	limits := ratelimit.DefaultLimits()
+	daily := ratelimit.Limit{Burst: 1, Per: limits.SMSDaily.Per}
+
+	wait, _ := store.Take(ctx, "sms:daily:alice", daily)
+	assert.Equal(t, time.Duration(0), wait)
+
+	now = now.Add(time.Hour)
+	for i := 0; i < 10001; i++ {
+		_, _ = store.Take(ctx, "sms:ip:"+strconv.Itoa(i), limits.SMSIP)
+	}
+
+	wait, _ = store.Take(ctx, "sms:daily:alice", daily)
+	assert.Equal(t, 23*time.Hour, wait)
+}
@@ -0,0 +1,183 @@
+package ratelimit
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// Limit is a token bucket holding Burst tokens that refills completely over
+// Per. A zero Limit never limits.
+type Limit struct {
+	Burst int
+	Per   time.Duration
+}
+
+func (l Limit) String() string { return fmt.Sprintf("%d/%s", l.Burst, l.Per) }
+
+// Parse reads a limit written as "<burst>/<duration>", i.e. "5/15m".
+func Parse(value string) (Limit, error) {
+	parts := strings.SplitN(strings.TrimSpace(value), "/", 2)
+	if len(parts) != 2 {
+		return Limit{}, fmt.Errorf("invalid rate limit %q; expected <burst>/<duration>", value)
+	}
+
+	burst, err := strconv.Atoi(parts[0])
+	if err != nil || burst < 0 {
+		return Limit{}, fmt.Errorf("invalid rate limit %q; burst must be a positive number", value)
+	}
+
+	per, err := time.ParseDuration(parts[1])
+	if err != nil || per <= 0 {
+		return Limit{}, fmt.Errorf("invalid rate limit %q; bad duration", value)
+	}
+
+	return Limit{burst, per}, nil
+}
+
+// Store keeps bucket state. Take removes a token from the bucket at key and
+// returns how long to wait before retrying when it was empty. Refund puts back
+// a token Take removed.
+type Store interface {
+	Take(ctx context.Context, key string, limit Limit) (time.Duration, error)
+	Refund(ctx context.Context, key string, limit Limit) error
+}
+
+type Limits struct {
+	LoginIP       Limit /* Login attempts per client IP. */
+	LoginUsername Limit /* Login attempts per username, from any IP. */
+	SignupIP      Limit /* Registrations per client IP. */
+	SMSIP         Limit /* Outbound sms per client IP. */
+	SMSPhone      Limit /* Outbound sms per destination phone. */
+	SMSDaily      Limit /* Outbound sms budget per user. */
+}
+
+func DefaultLimits() Limits {
+	return Limits{
+		LoginIP:       Limit{20, time.Minute},
+		LoginUsername: Limit{5, 15 * time.Minute},
+		SignupIP:      Limit{5, time.Hour},
+		SMSIP:         Limit{30, time.Minute},
+		SMSPhone:      Limit{10, time.Minute},
+		SMSDaily:      Limit{200, 24 * time.Hour},
+	}
+}
+
+// ParseLimits overrides the defaults with a comma separated list such as
+// "login_ip=10/1m,sms_daily=50/24h".
+func ParseLimits(value string) (Limits, error) {
+	limits := DefaultLimits()
+	fields := map[string]*Limit{
+		"login_ip":       &limits.LoginIP,
+		"login_username": &limits.LoginUsername,
+		"signup_ip":      &limits.SignupIP,
+		"sms_ip":         &limits.SMSIP,
+		"sms_phone":      &limits.SMSPhone,
+		"sms_daily":      &limits.SMSDaily,
+	}
+
+	for _, pair := range strings.Split(value, ",") {
+		if strings.TrimSpace(pair) == "" {
+			continue
+		}
+
+		kv := strings.SplitN(pair, "=", 2)
+		field, ok := fields[strings.TrimSpace(kv[0])]
+		if !ok || len(kv) != 2 {
+			return Limits{}, fmt.Errorf("unknown rate limit %q", pair)
+		}
+
+		limit, err := Parse(kv[1])
+		if err != nil {
+			return Limits{}, err
+		}
+		*field = limit
+	}
+
+	return limits, nil
+}
+
+// Error is returned when a bucket is empty.
+type Error struct {
+	key  string
+	wait time.Duration
+}
+
+func (e Error) Error() string {
+	return fmt.Sprintf("too many requests; try again in %s", e.wait.Round(time.Second))
+}
+
+func (e Error) RateLimited() bool         { return true }
+func (e Error) RetryAfter() time.Duration { return e.wait }
+
+type Limiter struct {
+	store  Store
+	limits Limits
+}
+
+func Default(store Store, limits Limits) Limiter {
+	return Limiter{store, limits}
+}
+
+func (l Limiter) Login(ctx context.Context, ip, username string) error {
+	return l.take(ctx,
+		bucket{"login:ip:" + ip, l.limits.LoginIP},
+		bucket{"login:username:" + strings.ToLower(username), l.limits.LoginUsername},
+	)
+}
+
+func (l Limiter) Signup(ctx context.Context, ip string) error {
+	return l.take(ctx, bucket{"signup:ip:" + ip, l.limits.SignupIP})
+}
+
+// SMS guards every message we pay for; userID may be empty when the
+// recipient isn't known to be a user yet.
+func (l Limiter) SMS(ctx context.Context, ip, phone, userID string) error {
+	buckets := []bucket{
+		{"sms:ip:" + ip, l.limits.SMSIP},
+		{"sms:phone:" + phone, l.limits.SMSPhone},
+	}
+	if userID != "" {
+		buckets = append(buckets, bucket{"sms:daily:" + userID, l.limits.SMSDaily})
+	}
+	return l.take(ctx, buckets...)
+}
+
+type bucket struct {
+	key   string
+	limit Limit
+}
+
+// take takes a token from every bucket or from none, so a request one bucket
+// rejects doesn't use up the others.
+func (l Limiter) take(ctx context.Context, buckets ...bucket) (_err error) {
+	var taken []bucket
+	defer func() {
+		if _err == nil {
+			return
+		}
+		for _, b := range taken {
+			_ = l.store.Refund(ctx, b.key, b.limit) /* At worst the bucket stays a token short. */
+		}
+	}()
+
+	for _, b := range buckets {
+		if b.limit.Burst == 0 {
+			continue
+		}
+
+		wait, err := l.store.Take(ctx, b.key, b.limit)
+		if err != nil {
+			return errors.Wrap(err, "failed to check rate limit")
+		}
+		if wait > 0 {
+			return Error{b.key, wait}
+		}
+		taken = append(taken, b)
+	}
+	return nil
+}
@@ -0,0 +1,87 @@
+package redis
+
+import (
+	"context"
+	"time"
+
+	goredis "github.com/go-redis/redis/v7"
+	"github.com/pkg/errors"
+	"smscp.xyz/internal/ratelimit"
+)
+
+const keyPrefix = "smscp:ratelimit:"
+
+// take refills and drains a bucket atomically. Returns the milliseconds to
+// wait, 0 when a token was taken.
+var take = goredis.NewScript(`
+local burst = tonumber(ARGV[1])
+local per = tonumber(ARGV[2])
+local now = tonumber(ARGV[3])
+local rate = burst / per
+
+local state = redis.call('HMGET', KEYS[1], 'tokens', 'ts')
+local tokens = tonumber(state[1]) or burst
+local ts = tonumber(state[2]) or now
+
+tokens = math.min(burst, tokens + math.max(0, now - ts) * rate)
+
+local wait = 0
+if tokens >= 1 then
+	tokens = tokens - 1
+else
+	wait = math.ceil((1 - tokens) / rate)
+end
+
+redis.call('HMSET', KEYS[1], 'tokens', tostring(tokens), 'ts', now)
+redis.call('PEXPIRE', KEYS[1], per)
+return wait
+`)
+
+// refund puts a token back, unless the bucket has since expired and so is
+// full again.
+var refund = goredis.NewScript(`
+local burst = tonumber(ARGV[1])
+local tokens = tonumber(redis.call('HGET', KEYS[1], 'tokens'))
+if tokens then
+	redis.call('HSET', KEYS[1], 'tokens', tostring(math.min(burst, tokens + 1)))
+end
+return 0
+`)
+
+// Store shares buckets between every server instance.
+type Store struct {
+	client *goredis.Client
+	now    func() time.Time
+}
+
+func Default(url string) (Store, error) {
+	opts, err := goredis.ParseURL(url)
+	if err != nil {
+		return Store{}, errors.Wrap(err, "invalid redis url")
+	}
+	return Store{goredis.NewClient(opts), time.Now}, nil
+}
+
+func (s Store) Take(ctx context.Context, key string, limit ratelimit.Limit) (time.Duration, error) {
+	ms := func(d time.Duration) int64 { return int64(d / time.Millisecond) }
+
+	wait, err := take.Run(s.client.WithContext(ctx), []string{keyPrefix + key},
+		limit.Burst, ms(limit.Per), ms(time.Duration(s.now().UnixNano())),
+	).Int64()
+	if err != nil {
+		return 0, errors.Wrap(err, "failed to take rate limit token")
+	}
+
+	return time.Duration(wait) * time.Millisecond, nil
+}
+
+func (s Store) Refund(ctx context.Context, key string, limit ratelimit.Limit) error {
+	if err := refund.Run(s.client.WithContext(ctx), []string{keyPrefix + key}, limit.Burst).Err(); err != nil {
+		return errors.Wrap(err, "failed to refund rate limit token")
+	}
+	return nil
+}
+
+func (s Store) Close() error {
+	return s.client.Close()
+}
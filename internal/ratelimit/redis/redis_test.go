@@ -0,0 +1,49 @@
+package redis_test
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	"gopkg.in/go-playground/assert.v1"
+	"smscp.xyz/internal/ratelimit"
+	"smscp.xyz/internal/ratelimit/redis"
+)
+
+// Two stores sharing one redis stand in for two server instances.
+func TestSharedBucket(t *testing.T) {
+	server, err := miniredis.Run()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer server.Close()
+
+	first, err := redis.Default("redis://" + server.Addr())
+	assert.Equal(t, nil, err)
+	defer first.Close()
+
+	second, err := redis.Default("redis://" + server.Addr())
+	assert.Equal(t, nil, err)
+	defer second.Close()
+
+	limit := ratelimit.Limit{Burst: 1, Per: time.Hour}
+	ctx := context.Background()
+
+	wait, err := first.Take(ctx, "key", limit)
+	assert.Equal(t, nil, err)
+	assert.Equal(t, time.Duration(0), wait)
+
+	wait, err = second.Take(ctx, "key", limit)
+	assert.Equal(t, nil, err)
+	assert.Equal(t, true, wait > 59*time.Minute)
+
+	wait, err = second.Take(ctx, "other", limit)
+	assert.Equal(t, nil, err)
+	assert.Equal(t, time.Duration(0), wait)
+
+	assert.Equal(t, nil, second.Refund(ctx, "key", limit))
+	wait, err = first.Take(ctx, "key", limit)
+	assert.Equal(t, nil, err)
+	assert.Equal(t, time.Duration(0), wait)
+}
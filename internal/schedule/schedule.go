@@ -0,0 +1,52 @@
+// Package schedule runs maintenance jobs in the background of a long running
+// server. Serverless deployments have no background, so run the same work from
+// cmd/smscp-admin on a cron instead.
+package schedule
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"smscp.xyz/internal/logger"
+)
+
+type Job struct {
+	Name  string
+	Every time.Duration
+	Run   func(context.Context) error
+}
+
+// Start runs each job every interval until ctx is done. A failed run is logged
+// and tried again at the next interval. The returned func waits for the jobs
+// to stop, which once ctx is done is as soon as any run in progress returns.
+func Start(ctx context.Context, jobs ...Job) (wait func()) {
+	var wg sync.WaitGroup
+	for _, job := range jobs {
+		wg.Add(1)
+		go func(job Job) {
+			defer wg.Done()
+			job.loop(ctx)
+		}(job)
+	}
+	return wg.Wait
+}
+
+func (job Job) loop(ctx context.Context) {
+	ticker := time.NewTicker(job.Every)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if ctx.Err() != nil {
+				return /* Both were ready; select picks either. */
+			}
+			if err := job.Run(ctx); err != nil {
+				logger.From(ctx).Error("job failed", err, logger.Fields{"job": job.Name})
+			}
+		}
+	}
+}
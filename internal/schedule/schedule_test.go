@@ -0,0 +1,56 @@
+package schedule
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"gopkg.in/go-playground/assert.v1"
+)
+
+func TestStart(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	runs := make(chan struct{})
+
+	Start(ctx, Job{"test", time.Millisecond, func(context.Context) error {
+		runs <- struct{}{}
+		return errors.New("keeps going")
+	}})
+
+	<-runs
+	<-runs
+	cancel()
+
+	select {
+	case <-runs:
+		// A run may have started before cancel.
+	case <-time.After(10 * time.Millisecond):
+	}
+	select {
+	case <-runs:
+		t.Fatal("job ran after its context was done")
+	case <-time.After(10 * time.Millisecond):
+	}
+	assert.Equal(t, context.Canceled, ctx.Err())
+}
+
+func TestStartWait(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	running := make(chan struct{})
+	finished := false
+
+	wait := Start(ctx, Job{"slow", time.Millisecond, func(context.Context) error {
+		if !finished {
+			close(running)
+		}
+		time.Sleep(10 * time.Millisecond)
+		finished = true
+		return nil
+	}})
+
+	<-running
+	cancel()
+	wait()
+	assert.Equal(t, true, finished)
+}
@@ -1,6 +1,7 @@
 package security
 
 import (
+	"context"
 	"fmt"
 
 	"github.com/dgrijalva/jwt-go"
@@ -9,11 +10,32 @@ import (
 )
 
 type Security struct {
-	secret string
+	secret   string
+	observer Observer
+}
+
+// Observer is told when each token is created or parsed, and handed its error
+// once done, i.e. to trace it.
+type Observer interface {
+	Observe(ctx context.Context, op string) (done func(err error))
 }
 
 func Default(secret string) Security {
-	return Security{secret}
+	return Security{secret: secret}
+}
+
+// WithObserver reports every token created or parsed to o.
+func (sec Security) WithObserver(o Observer) Security {
+	sec.observer = o
+	return sec
+}
+
+func (sec Security) op(ctx context.Context, name string) func(*error) {
+	if sec.observer == nil {
+		return func(*error) {}
+	}
+	done := sec.observer.Observe(ctx, name)
+	return func(err *error) { done(*err) }
 }
 
 func (sec Security) HashCreate(pass string) (string, error) {
@@ -25,12 +47,15 @@ func (sec Security) HashCompare(pass, hash string) error {
 	return bcrypt.CompareHashAndPassword([]byte(hash), []byte(pass))
 }
 
-func (sec Security) TokenCreate(val jwt.Claims) (string, error) {
+func (sec Security) TokenCreate(ctx context.Context, val jwt.Claims) (_ string, _err error) {
+	defer sec.op(ctx, "TokenCreate")(&_err)
+
 	token := jwt.NewWithClaims(jwt.SigningMethodHS256, val)
 	return token.SignedString([]byte(sec.secret))
 }
 
-func (sec Security) TokenFrom(tokenString string) (_ret jwt.MapClaims, recoverErr error) {
+func (sec Security) TokenFrom(ctx context.Context, tokenString string) (_ret jwt.MapClaims, recoverErr error) {
+	defer sec.op(ctx, "TokenFrom")(&recoverErr)
 	defer func() {
 		if recover() != nil {
 			recoverErr = errors.New("bogus hash; hash has no value")
@@ -1,7 +1,10 @@
 package twilio
 
 import (
+	"context"
 	"fmt"
+	"sort"
+	"strings"
 
 	"github.com/gin-gonic/gin"
 	"github.com/pkg/errors"
@@ -9,6 +12,12 @@ import (
 	"github.com/ttacon/libphonenumber"
 )
 
+// rejectedError is twilio refusing a message, i.e. for an invalid number,
+// rather than failing to reach twilio.
+type rejectedError struct{ error }
+
+func (rejectedError) Rejected() bool { return true }
+
 type SMS struct {
 	id, secret, from string
 }
@@ -17,20 +26,39 @@ func Default(id, secret, from string) SMS {
 	return SMS{id, secret, from}
 }
 
-func (sms SMS) Send(to, text string) error {
+func (sms SMS) Send(ctx context.Context, to, text string) error {
 	twilio := gotwilio.NewTwilioClient(sms.id, sms.secret)
 
-	if _, _, err := twilio.SendMMS(sms.from, to, text, "", "", ""); err != nil {
+	_, exception, err := twilio.SendMMS(sms.from, to, text, "", "", "")
+	if err != nil {
 		return errors.Wrap(err, "failed to send message")
 	}
+	if exception != nil { /* Twilio answered, but refused it. */
+		return errors.Wrap(rejectedError{*exception}, "failed to send message")
+	}
 
 	return nil
 }
 
+// Check reports missing credentials; twilio is only called when sending.
+func (sms SMS) Check() error {
+	var missing []string
+	for name, value := range map[string]string{"id": sms.id, "secret": sms.secret, "from": sms.from} {
+		if value == "" {
+			missing = append(missing, name)
+		}
+	}
+	if len(missing) > 0 {
+		sort.Strings(missing)
+		return fmt.Errorf("twilio not configured; missing %s", strings.Join(missing, ", "))
+	}
+	return nil
+}
+
 func (sms SMS) Hook(c *gin.Context) (_number, _text string, _err error) {
 	var payload struct{ Body, From, FromCountry string }
 
-	err := c.Bind(&payload)
+	err := c.ShouldBind(&payload)
 	if err != nil {
 		return "", "", err
 	}
@@ -0,0 +1,62 @@
+package tracing
+
+import (
+	"net/http"
+	"sync"
+
+	"github.com/gin-gonic/gin"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// Middleware spans each request, as a child of the caller's span when it sent
+// a traceparent header. Spans are named by the route matched rather than the
+// path, which may hold ids and tokens; router is where the routes are
+// registered, which happens after this is added.
+func (t *Tracing) Middleware(router *gin.Engine) gin.HandlerFunc {
+	tracer := t.Tracer("smscp.xyz/internal/api")
+
+	var (
+		once   sync.Once
+		routes map[string]string /* method and handler name to route */
+	)
+
+	return func(c *gin.Context) {
+		ctx := propagator.Extract(c.Request.Context(), propagation.HeaderCarrier(c.Request.Header))
+		ctx, span := tracer.Start(ctx, c.Request.Method, trace.WithSpanKind(trace.SpanKindServer))
+		defer span.End()
+
+		c.Request = c.Request.WithContext(ctx)
+		c.Set(contextKey, ctx)
+
+		c.Next()
+
+		once.Do(func() {
+			routes = map[string]string{}
+			for _, route := range router.Routes() {
+				key := route.Method + " " + route.Handler
+				if _, ok := routes[key]; !ok {
+					routes[key] = route.Path
+				}
+			}
+		})
+
+		route, ok := routes[c.Request.Method+" "+c.HandlerName()]
+		if !ok {
+			route = "unmatched"
+		}
+
+		status := c.Writer.Status()
+		span.SetName(c.Request.Method + " " + route)
+		span.SetAttributes(
+			attribute.String("http.method", c.Request.Method),
+			attribute.String("http.route", route),
+			attribute.Int("http.status_code", status),
+		)
+		if status >= http.StatusInternalServerError {
+			span.SetStatus(codes.Error, http.StatusText(status))
+		}
+	}
+}
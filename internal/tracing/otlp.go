@@ -0,0 +1,193 @@
+package tracing
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/pkg/errors"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+)
+
+// otlpExporter posts spans to a collector as OTLP/HTTP with JSON bodies, and
+// every collector accepts JSON on /v1/traces. It stands in for otlptracehttp,
+// which doesn't use grpc itself but requires go.opentelemetry.io/proto/otlp,
+// and so grpc v1.37 or later. That grpc dropped the grpc/naming package that
+// google.golang.org/api v0.3.1, under our firestore client, still imports.
+// Replace this with otlptracehttp when cloud.google.com/go is upgraded.
+type otlpExporter struct {
+	url  string
+	http *http.Client
+}
+
+// OTLP exports to the collector at endpoint, i.e. http://localhost:4318.
+func OTLP(endpoint string) sdktrace.SpanExporter {
+	return otlpExporter{strings.TrimRight(endpoint, "/") + "/v1/traces", &http.Client{}}
+}
+
+func (e otlpExporter) ExportSpans(ctx context.Context, spans []sdktrace.ReadOnlySpan) error {
+	if len(spans) == 0 {
+		return nil
+	}
+
+	body, err := json.Marshal(otlpRequest(spans))
+	if err != nil {
+		return errors.Wrap(err, "failed to encode spans")
+	}
+
+	req, err := http.NewRequest("POST", e.url, bytes.NewReader(body))
+	if err != nil {
+		return errors.Wrap(err, "failed to export spans")
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	res, err := e.http.Do(req.WithContext(ctx))
+	if err != nil {
+		return errors.Wrap(err, "failed to export spans")
+	}
+	defer res.Body.Close()
+	_, _ = io.Copy(ioutil.Discard, res.Body)
+
+	if res.StatusCode/100 != 2 {
+		return fmt.Errorf("failed to export spans; collector responded %d", res.StatusCode)
+	}
+	return nil
+}
+
+func (e otlpExporter) Shutdown(ctx context.Context) error { return nil }
+
+// The OTLP JSON encoding: ids are hex, 64 bit integers are strings and enums
+// are numbers.
+
+type otlpTraces struct {
+	ResourceSpans []otlpResourceSpans `json:"resourceSpans"`
+}
+
+type otlpResourceSpans struct {
+	Resource   otlpResource     `json:"resource"`
+	ScopeSpans []otlpScopeSpans `json:"scopeSpans"`
+}
+
+type otlpResource struct {
+	Attributes []otlpKeyValue `json:"attributes"`
+}
+
+type otlpScopeSpans struct {
+	Scope otlpScope  `json:"scope"`
+	Spans []otlpSpan `json:"spans"`
+}
+
+type otlpScope struct {
+	Name    string `json:"name"`
+	Version string `json:"version,omitempty"`
+}
+
+type otlpSpan struct {
+	TraceID           string         `json:"traceId"`
+	SpanID            string         `json:"spanId"`
+	ParentSpanID      string         `json:"parentSpanId,omitempty"`
+	Name              string         `json:"name"`
+	Kind              int            `json:"kind"`
+	StartTimeUnixNano string         `json:"startTimeUnixNano"`
+	EndTimeUnixNano   string         `json:"endTimeUnixNano"`
+	Attributes        []otlpKeyValue `json:"attributes,omitempty"`
+	Status            otlpStatus     `json:"status"`
+}
+
+type otlpStatus struct {
+	Code    int    `json:"code,omitempty"`
+	Message string `json:"message,omitempty"`
+}
+
+type otlpKeyValue struct {
+	Key   string                 `json:"key"`
+	Value map[string]interface{} `json:"value"`
+}
+
+// otlpRequest groups spans by resource, then by the tracer that made them.
+func otlpRequest(spans []sdktrace.ReadOnlySpan) otlpTraces {
+	var out otlpTraces
+	resources := map[string]int{}
+	scopes := map[[2]string]int{}
+
+	for _, span := range spans {
+		res := ""
+		if r := span.Resource(); r != nil {
+			res = r.Encoded(attribute.DefaultEncoder())
+		}
+		ri, ok := resources[res]
+		if !ok {
+			ri = len(out.ResourceSpans)
+			resources[res] = ri
+			var attrs []attribute.KeyValue
+			if r := span.Resource(); r != nil {
+				attrs = r.Attributes()
+			}
+			out.ResourceSpans = append(out.ResourceSpans, otlpResourceSpans{Resource: otlpResource{otlpAttributes(attrs)}})
+		}
+
+		lib := span.InstrumentationLibrary()
+		si, ok := scopes[[2]string{res, lib.Name}]
+		if !ok {
+			si = len(out.ResourceSpans[ri].ScopeSpans)
+			scopes[[2]string{res, lib.Name}] = si
+			out.ResourceSpans[ri].ScopeSpans = append(out.ResourceSpans[ri].ScopeSpans, otlpScopeSpans{Scope: otlpScope{lib.Name, lib.Version}})
+		}
+
+		scope := &out.ResourceSpans[ri].ScopeSpans[si]
+		scope.Spans = append(scope.Spans, otlpSpanFrom(span))
+	}
+	return out
+}
+
+func otlpSpanFrom(span sdktrace.ReadOnlySpan) otlpSpan {
+	sc := span.SpanContext()
+	out := otlpSpan{
+		TraceID:           sc.TraceID().String(),
+		SpanID:            sc.SpanID().String(),
+		Name:              span.Name(),
+		Kind:              int(span.SpanKind()), /* Numbered as OTLP numbers them. */
+		StartTimeUnixNano: strconv.FormatInt(span.StartTime().UnixNano(), 10),
+		EndTimeUnixNano:   strconv.FormatInt(span.EndTime().UnixNano(), 10),
+		Attributes:        otlpAttributes(span.Attributes()),
+	}
+	if parent := span.Parent(); parent.IsValid() {
+		out.ParentSpanID = parent.SpanID().String()
+	}
+
+	// OTLP numbers ok and error the other way round.
+	switch status := span.Status(); status.Code {
+	case codes.Ok:
+		out.Status = otlpStatus{Code: 1}
+	case codes.Error:
+		out.Status = otlpStatus{Code: 2, Message: status.Description}
+	}
+	return out
+}
+
+func otlpAttributes(attrs []attribute.KeyValue) []otlpKeyValue {
+	out := make([]otlpKeyValue, 0, len(attrs))
+	for _, kv := range attrs {
+		var value map[string]interface{}
+		switch kv.Value.Type() {
+		case attribute.BOOL:
+			value = map[string]interface{}{"boolValue": kv.Value.AsBool()}
+		case attribute.INT64:
+			value = map[string]interface{}{"intValue": strconv.FormatInt(kv.Value.AsInt64(), 10)}
+		case attribute.FLOAT64:
+			value = map[string]interface{}{"doubleValue": kv.Value.AsFloat64()}
+		default:
+			value = map[string]interface{}{"stringValue": kv.Value.Emit()}
+		}
+		out = append(out, otlpKeyValue{string(kv.Key), value})
+	}
+	return out
+}
@@ -0,0 +1,36 @@
+package tracing
+
+import (
+	"context"
+
+	"github.com/gin-gonic/gin"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+)
+
+type sender interface {
+	Send(ctx context.Context, number, text string) error
+	Hook(c *gin.Context) (number, text string, err error)
+	Check() error
+}
+
+// SMS spans the sends of the provider it wraps.
+type SMS struct {
+	sender
+	provider string
+	tracer   trace.Tracer
+}
+
+func (t *Tracing) SMS(provider string, sms sender) SMS {
+	return SMS{sms, provider, t.Tracer("smscp.xyz/internal/sms")}
+}
+
+func (s SMS) Send(ctx context.Context, number, text string) error {
+	ctx, span := s.tracer.Start(Context(ctx), "sms.Send",
+		trace.WithSpanKind(trace.SpanKindClient),
+		trace.WithAttributes(attribute.String("sms.provider", s.provider)),
+	)
+	err := s.sender.Send(ctx, number, text)
+	end(span, err)
+	return err
+}
@@ -0,0 +1,118 @@
+// Package tracing records OpenTelemetry spans for each request, storage
+// operation, token created or parsed and sms sent, so a slow page can be
+// pinned on the call that made it slow. Spans join a trace started by the
+// caller, i.e. the cli, through the W3C traceparent header, and are exported
+// to stdout or an OTLP collector.
+package tracing
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"time"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/exporters/stdout/stdouttrace"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/trace"
+	"smscp.xyz/internal/logger"
+)
+
+// The exporters TRACE_EXPORTER chooses between.
+const (
+	ExporterNone   = "none"
+	ExporterStdout = "stdout"
+	ExporterOTLP   = "otlp"
+)
+
+// contextKey holds the traced request context in a gin context, whose Value
+// only looks up string keys; data and sms calls are handed the gin context.
+const contextKey = "smscp.trace"
+
+// propagator reads and writes the traceparent and tracestate headers.
+var propagator = propagation.TraceContext{}
+
+type Tracing struct {
+	provider trace.TracerProvider
+	shutdown func(context.Context) error
+}
+
+// Default records nothing, for when TRACE_EXPORTER is none.
+func Default() *Tracing {
+	return &Tracing{trace.NewNoopTracerProvider(), func(context.Context) error { return nil }}
+}
+
+// New batches spans to exporter.
+func New(exporter sdktrace.SpanExporter) *Tracing {
+	provider := sdktrace.NewTracerProvider(
+		sdktrace.WithBatcher(exporter),
+		sdktrace.WithResource(resource.NewSchemaless(attribute.String("service.name", "smscp"))),
+	)
+	return &Tracing{provider, provider.Shutdown}
+}
+
+// Exporter builds the exporter named by TRACE_EXPORTER; stdout writes to w.
+// It's nil for none.
+func Exporter(name, endpoint string, w io.Writer) (sdktrace.SpanExporter, error) {
+	switch name {
+	case ExporterNone, "":
+		return nil, nil
+	case ExporterStdout:
+		return stdouttrace.New(stdouttrace.WithWriter(w))
+	case ExporterOTLP:
+		return OTLP(endpoint), nil
+	}
+	return nil, fmt.Errorf("unknown trace exporter %q; want none, stdout or otlp", name)
+}
+
+// Tracer names the spans' source, i.e. smscp.xyz/internal/fs.
+func (t *Tracing) Tracer(name string) trace.Tracer {
+	return t.provider.Tracer(name)
+}
+
+// Close exports the spans still buffered.
+func (t *Tracing) Close() error {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	return t.shutdown(ctx)
+}
+
+// Context returns ctx carrying the request's span, for contexts that hide it,
+// i.e. the gin context handlers pass on.
+func Context(ctx context.Context) context.Context {
+	if traced, ok := ctx.Value(contextKey).(context.Context); ok {
+		return trace.ContextWithSpan(ctx, trace.SpanFromContext(traced))
+	}
+	return ctx
+}
+
+// Observer spans each operation it's told of; it satisfies fs.Observer and
+// security.Observer.
+type Observer struct {
+	tracer trace.Tracer
+	prefix string
+}
+
+// Observer names its spans after component, i.e. fs.NoteGetList.
+func (t *Tracing) Observer(component string) Observer {
+	return Observer{t.Tracer("smscp.xyz/internal/" + component), component + "."}
+}
+
+func (o Observer) Observe(ctx context.Context, op string) func(error) {
+	_, span := o.tracer.Start(Context(ctx), o.prefix+op)
+	return func(err error) {
+		end(span, err)
+	}
+}
+
+// end marks span failed by err, scrubbed of phone numbers and tokens, and ends
+// it.
+func end(span trace.Span, err error) {
+	if err != nil {
+		span.SetStatus(codes.Error, logger.Scrub(err.Error()))
+	}
+	span.End()
+}
@@ -0,0 +1,107 @@
+package tracing
+
+import (
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/pkg/errors"
+	"go.opentelemetry.io/otel/codes"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+	"gopkg.in/go-playground/assert.v1"
+)
+
+const (
+	traceID     = "4bf92f3577b34da6a3ce929d0e0e4736"
+	traceparent = "00-" + traceID + "-00f067aa0ba902b7-01"
+)
+
+type fakeSender struct {
+	sender
+	err error
+}
+
+func (s fakeSender) Send(ctx context.Context, number, text string) error { return s.err }
+
+func recorded() (*Tracing, *tracetest.SpanRecorder) {
+	rec := tracetest.NewSpanRecorder()
+	provider := sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(rec))
+	return &Tracing{provider, provider.Shutdown}, rec
+}
+
+func TestMiddleware(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	traces, rec := recorded()
+	fs := traces.Observer("fs")
+	sms := traces.SMS("twilio", fakeSender{err: errors.New("invalid To number 12085550100")})
+
+	router := gin.New()
+	router.Use(traces.Middleware(router))
+	router.GET("/notes/:id", func(c *gin.Context) {
+		fs.Observe(c, "NoteGetList")(nil)
+		_ = sms.Send(c, "12085550100", "hi")
+		c.Status(http.StatusOK)
+	})
+
+	req, _ := http.NewRequest("GET", "/notes/1", nil)
+	req.Header.Set("traceparent", traceparent)
+	router.ServeHTTP(httptest.NewRecorder(), req)
+
+	spans := map[string]sdktrace.ReadOnlySpan{}
+	for _, span := range rec.Ended() {
+		spans[span.Name()] = span
+	}
+	assert.Equal(t, 3, len(spans))
+
+	server := spans["GET /notes/:id"]
+	assert.Equal(t, traceID, server.SpanContext().TraceID().String())
+	assert.Equal(t, "00f067aa0ba902b7", server.Parent().SpanID().String())
+
+	for _, name := range []string{"fs.NoteGetList", "sms.Send"} {
+		assert.Equal(t, server.SpanContext().SpanID(), spans[name].Parent().SpanID())
+	}
+	assert.Equal(t, codes.Error, spans["sms.Send"].Status().Code)
+	assert.Equal(t, "invalid To number [redacted]", spans["sms.Send"].Status().Description)
+}
+
+func TestOTLP(t *testing.T) {
+	var (
+		path string
+		body struct {
+			ResourceSpans []struct {
+				ScopeSpans []struct {
+					Scope struct{ Name string }
+					Spans []struct {
+						TraceID, ParentSpanID, Name string
+						Status                      struct{ Code int }
+					}
+				}
+			}
+		}
+	)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		path = r.URL.Path
+		byt, _ := ioutil.ReadAll(r.Body)
+		_ = json.Unmarshal(byt, &body)
+	}))
+	defer srv.Close()
+
+	traces, rec := recorded()
+	traces.Observer("security").Observe(context.Background(), "TokenFrom")(errors.New("signature is invalid"))
+
+	err := OTLP(srv.URL+"/").ExportSpans(context.Background(), rec.Ended())
+	assert.Equal(t, nil, err)
+	assert.Equal(t, "/v1/traces", path)
+
+	scope := body.ResourceSpans[0].ScopeSpans[0]
+	assert.Equal(t, "smscp.xyz/internal/security", scope.Scope.Name)
+	assert.Equal(t, "security.TokenFrom", scope.Spans[0].Name)
+	assert.Equal(t, 32, len(scope.Spans[0].TraceID))
+	assert.Equal(t, "", scope.Spans[0].ParentSpanID)
+	assert.Equal(t, 2, scope.Spans[0].Status.Code)
+}
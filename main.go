@@ -2,17 +2,22 @@ package main
 
 import (
 	"log"
+	"os"
 
-	// only used in dev
-	// but dep is used to minify web/html/*
-	// before deploy
-	_ "github.com/tdewolff/minify"
+	"smscp.xyz/internal/config"
 	"smscp.xyz/pkg/builder"
 	"smscp.xyz/pkg/mode"
 )
 
 func main() {
-	server, err := builder.Build(mode.Dev)
+	cfg, err := config.Load(mode.Dev, os.Args[1:])
+	if err != nil {
+		log.Fatal(err)
+		return
+	}
+	log.Printf("config:\n%s", cfg)
+
+	server, err := builder.New(mode.Dev, builder.WithConfig(cfg))
 	if err != nil {
 		log.Fatal(err)
 		return
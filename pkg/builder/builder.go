@@ -2,87 +2,398 @@ package builder
 
 import (
 	"context"
+	"io"
 	"net/http"
 	"os"
+	"os/signal"
+	"sync"
+	"syscall"
+	"time"
 
 	"smscp.xyz/internal/api"
-	"smscp.xyz/internal/csv"
+	"smscp.xyz/internal/bus"
+	"smscp.xyz/internal/bus/memory"
+	"smscp.xyz/internal/bus/redis"
+	"smscp.xyz/internal/clientip"
+	"smscp.xyz/internal/config"
+	"smscp.xyz/internal/envelope"
+	"smscp.xyz/internal/export"
 	"smscp.xyz/internal/fs"
+	"smscp.xyz/internal/logger"
+	"smscp.xyz/internal/metrics"
+	"smscp.xyz/internal/ratelimit"
+	ratememory "smscp.xyz/internal/ratelimit/memory"
+	rateredis "smscp.xyz/internal/ratelimit/redis"
+	"smscp.xyz/internal/schedule"
 	"smscp.xyz/internal/security"
 	"smscp.xyz/internal/sms/twilio"
+	"smscp.xyz/internal/tracing"
 	"smscp.xyz/pkg/mode"
+	"smscp.xyz/web"
 
 	"github.com/gin-contrib/sessions"
 	"github.com/gin-contrib/sessions/cookie"
 	"github.com/gin-gonic/gin"
+	"github.com/pkg/errors"
 )
 
-type App struct{ router *gin.Engine }
+// App is the server. New wires it; Start serves it with its background jobs
+// until Shutdown, and Run does both around a signal. Serverless deployments
+// only use ServeHTTP.
+type App struct {
+	router  *gin.Engine
+	server  *http.Server
+	timeout time.Duration /* Run's grace period for Shutdown. */
+	log     *logger.Logger
+	jobs    []schedule.Job
+	ctx     context.Context /* Done once Shutdown is called, stopping the jobs. */
+	stop    context.CancelFunc
+	mu      sync.Mutex
+	wait    func()      /* Waits for the jobs to stop; nil until Start. */
+	closers []io.Closer /* Connections New opened, closed by Shutdown. */
+}
+
+// How often a running server sweeps expired notes, and purges notes past each
+// user's retention and accounts past their deletion grace period.
+const (
+	sweepEvery = time.Minute
+	purgeEvery = time.Hour
+)
+
+// Storage is everything the app and its background jobs need from a data
+// store.
+type Storage interface {
+	api.Data
+	NoteSweepExpired(ctx context.Context, now time.Time) (int, error)
+	NotePurge(ctx context.Context, now time.Time, dryRun bool) (map[string]int, error)
+}
 
-var dbConn, dbErr = fs.ConnDefault(context.Background(), os.Getenv("GOOGLE_PROJECT_ID"))
+type options struct {
+	cfg  config.Config
+	data Storage
+	sms  api.SMS
+	now  func() time.Time
+	log  *logger.Logger
+}
+
+type Option func(*options)
 
-func Build(m mode.Mode) (*App, error) {
-	if dbErr != nil {
-		return nil, dbErr
+// WithConfig sets the config, which should already be validated by
+// config.Load. The default is config.Default().
+func WithConfig(cfg config.Config) Option {
+	return func(o *options) { o.cfg = cfg }
+}
+
+// WithStorage replaces firestore. The caller keeps ownership; Shutdown won't
+// close it.
+func WithStorage(data Storage) Option {
+	return func(o *options) { o.data = data }
+}
+
+// WithSMS replaces twilio.
+func WithSMS(sms api.SMS) Option {
+	return func(o *options) { o.sms = sms }
+}
+
+// WithClock replaces time.Now.
+func WithClock(now func() time.Time) Option {
+	return func(o *options) { o.now = now }
+}
+
+// WithLogger replaces logging JSON to stderr.
+func WithLogger(l *logger.Logger) Option {
+	return func(o *options) { o.log = l }
+}
+
+// New wires the app. It connects to firestore, and redis when configured,
+// unless given storage, and exports spans as TRACE_EXPORTER says.
+func New(m mode.Mode, opts ...Option) (*App, error) {
+	o := options{cfg: config.Default(), now: time.Now, log: logger.Default(os.Stderr)}
+	for _, opt := range opts {
+		opt(&o)
 	}
+	cfg := o.cfg
 
 	if m == mode.Test {
 		gin.SetMode(gin.TestMode)
 	}
 
-	router := gin.Default()
-	router.LoadHTMLGlob("web/html/*")
-	router.Static("/static", "web/static/")
-	store := cookie.NewStore([]byte(os.Getenv("SESSION_SECRET")))
-	router.Use(sessions.Sessions(os.Getenv("SESSION_NAME"), store))
+	a := &App{log: o.log}
+	a.ctx, a.stop = context.WithCancel(logger.NewContext(context.Background(), o.log))
 
-	security := security.Default(os.Getenv("JWT_SECRET"))
-	data := fs.Default(security, dbConn)
-	sms := twilio.Default(os.Getenv("TWILIO_ID"), os.Getenv("TWILIO_SECRET"), os.Getenv("TWILIO_FROM"))
+	stats := metrics.Default()
 
-	csv := csv.Default()
-	app := api.AppDefault(data, sms, csv, security)
+	traces := tracing.Default()
+	exporter, err := tracing.Exporter(cfg.TraceExporter, cfg.OTLPEndpoint, os.Stdout)
+	if err != nil {
+		return nil, err
+	}
+	if exporter != nil {
+		traces = tracing.New(exporter)
+		a.closers = append(a.closers, traces)
+	}
 
-	router.GET("/", app.Page)
-	router.POST("/", app.Page)
+	security := security.Default(cfg.JWTSecret).WithObserver(traces.Observer("security"))
+	data := o.data
+	if data == nil {
+		conn, err := fs.ConnDefault(context.Background(), cfg.ProjectID)
+		if err != nil {
+			a.close()
+			return nil, errors.Wrap(err, "failed to connect to firestore")
+		}
+		a.closers = append(a.closers, conn)
 
+		store := fs.Default(security, conn).WithMaxFailedLogins(cfg.LoginMaxFailures).WithObserver(stats).WithObserver(traces.Observer("fs"))
+		if cfg.MasterKeys != "" {
+			keys, err := envelope.Parse(cfg.MasterKeys)
+			if err != nil {
+				a.close()
+				return nil, errors.Wrap(err, "invalid MASTER_KEYS")
+			}
+			store = store.WithKeyring(keys)
+		}
+		data = store
+	}
+
+	provider, sender := "custom", o.sms
+	if sender == nil {
+		provider, sender = "twilio", twilio.Default(cfg.TwilioID, cfg.TwilioSecret, cfg.TwilioFrom)
+	}
+	sms := traces.SMS(provider, stats.SMS(provider, sender))
+
+	limits, err := ratelimit.ParseLimits(cfg.RateLimits)
+	if err != nil {
+		a.close()
+		return nil, err
+	}
+
+	// Serverless instances share nothing in memory, so live updates and rate
+	// limits need redis anywhere other than a single local process.
+	var notes bus.Bus = memory.Default()
+	var buckets ratelimit.Store = ratememory.Default()
+	if url := cfg.RedisURL; url != "" {
+		conn, err := redis.Default(url)
+		if err != nil {
+			a.close()
+			return nil, err
+		}
+		a.closers = append(a.closers, conn)
+		notes = conn
+
+		store, err := rateredis.Default(url)
+		if err != nil {
+			a.close()
+			return nil, err
+		}
+		a.closers = append(a.closers, store)
+		buckets = store
+	}
+
+	export := export.Default()
+	limit := ratelimit.Default(buckets, limits)
+	app := api.AppDefault(data, sms, export, security, notes, limit).WithClock(o.now).WithMetrics(stats).WithDone(a.ctx)
+	if url := cfg.BaseURL; url != "" {
+		app = app.WithBaseURL(url)
+	}
+
+	templates, err := web.Templates()
+	if err != nil {
+		a.close()
+		return nil, errors.Wrap(err, "failed to parse templates")
+	}
+
+	a.router = gin.New()
+	a.router.Use(clientip.Middleware(cfg.ClientIPHeader), logger.Middleware(o.log), traces.Middleware(a.router), stats.Middleware(a.router), gin.Recovery())
+	a.router.SetHTMLTemplate(templates)
+	a.router.Static("/static", "web/static/")
+	a.router.Use(sessions.Sessions(cfg.SessionName, cookie.NewStore([]byte(cfg.SessionSecret))))
+	routes(a.router, app, stats.Handler(cfg.MetricsToken))
+
+	a.server = &http.Server{Addr: ":" + cfg.Port, Handler: a.router}
+	a.timeout = cfg.ShutdownTimeout
+	a.jobs = jobs(app, data, o.now, cfg.PurgeDryRun)
+
+	return a, nil
+}
+
+// jobs are the background work of a long running server; serverless has no
+// background, so runs the same work from smscp-admin.
+func jobs(app api.App, data Storage, now func() time.Time, dryRun bool) []schedule.Job {
+	sweep := schedule.Job{Name: "sweep expired notes", Every: sweepEvery, Run: func(ctx context.Context) error {
+		n, err := data.NoteSweepExpired(ctx, now())
+		if n > 0 {
+			logger.From(ctx).Info("swept expired notes", logger.Fields{"notes": n})
+		}
+		return err
+	}}
+
+	// PURGE_DRY_RUN logs what retention would delete without deleting it.
+	purge := schedule.Job{Name: "purge notes past retention", Every: purgeEvery, Run: func(ctx context.Context) error {
+		report, err := data.NotePurge(ctx, now(), dryRun)
+		for user, n := range report {
+			logger.From(ctx).Info("purged notes past retention", logger.Fields{"user": user, "notes": n, "dry_run": dryRun})
+		}
+		return err
+	}}
+
+	deleted := schedule.Job{Name: "purge deleted accounts", Every: purgeEvery, Run: func(ctx context.Context) error {
+		n, err := app.UserPurgeDeleted(ctx, now())
+		if n > 0 {
+			logger.From(ctx).Info("deleted accounts past their grace period", logger.Fields{"accounts": n})
+		}
+		return err
+	}}
+
+	return []schedule.Job{sweep, purge, deleted}
+}
+
+// routes registers every endpoint. Each one must also be documented in
+// internal/api/openapi.go; builder_test.go enforces it.
+func routes(router gin.IRouter, app api.App, metrics gin.HandlerFunc) {
 	router.GET("/ping", app.Pong)
+	router.GET("/healthz", app.Healthz)
+	router.GET("/readyz", app.Readyz)
+	router.GET("/metrics", metrics)
+	router.GET("/api/openapi.json", app.OpenAPI)
+
+	// everything authenticated by the session cookie
+	web := router.Group("", app.CSRF)
+
+	web.GET("/", app.Page)
+	web.POST("/", app.Page)
 
-	router.POST("/user/login", app.UserLogin)
-	router.POST("/user/create", app.UserCreate)
-	router.POST("/user/update", app.UserUpdate)
-	router.POST("/user/logout", app.UserLogout)
+	web.POST("/user/login", app.UserLogin)
+	web.POST("/user/create", app.UserCreate)
+	web.POST("/user/update", app.UserUpdate)
+	web.POST("/user/logout", app.UserLogout)
 
 	// reset pass
-	router.POST("/user/forgot-password", app.UserForgotPassword)
-	router.GET("/reset/:hash", app.PageForgotPassword)
-	router.POST("/reset/:hash", app.UserForgotPasswordNewPassword)
+	web.POST("/user/forgot-password", app.UserForgotPassword)
+	web.GET("/reset/:hash", app.PageForgotPassword)
+	web.POST("/reset/:hash", app.UserForgotPasswordNewPassword)
 
-	router.POST("/note/create", app.NoteCreate)
-	router.GET("/note/list/:page", app.NoteListJSON)
+	web.POST("/note/create", app.NoteCreate)
+	web.GET("/note/list/:page", app.NoteListJSON)
+	web.GET("/note/events", app.NoteEvents)
 
+	// gdpr
+	web.GET("/gdpr", app.UserExportAllData)
+	web.POST("/gdpr", app.UserDeleteAllData)
+
+	// operators; grant with smscp-admin grant-admin
+	admin := web.Group("/admin", app.Admin)
+	admin.GET("", app.PageAdmin)
+	admin.GET("/users/:id", app.PageAdminUser)
+	admin.POST("/users/:id/lock", app.AdminUserLock)
+	admin.POST("/users/:id/unlock", app.AdminUserUnlock)
+	admin.POST("/users/:id/logout", app.AdminUserLogout)
+	admin.GET("/audit", app.AdminAuditExport)
+
+	v1 := router.Group("/api/v1")
+	v1.POST("/users", app.UserCreateV1)
+	v1.POST("/sessions", app.SessionCreateV1)
+
+	v1auth := v1.Group("", app.AuthV1)
+	v1auth.GET("/users/me", app.UserGetV1)
+	v1auth.GET("/notes", app.NoteListV1)
+	v1auth.POST("/notes", app.NoteCreateV1)
+	v1auth.POST("/notes/import", app.NoteImportV1)
+	v1auth.GET("/notes/:id", app.NoteGetV1)
+	v1auth.DELETE("/notes/:id", app.NoteDeleteV1)
+
+	// deprecated; kept for older cli builds, use /api/v1
 	router.POST("/cli/user/login", app.UserLoginCLI)
 	router.POST("/cli/user/create", app.UserCreateCLI)
 	router.POST("/cli/note/create", app.NoteCreateCLI)
 	router.POST("/cli/note/latest", app.NoteLatestCLI)
 
 	router.POST("/hook/sms/receive", app.HookSMS)
-
-	// gdpr
-	router.GET("/gdpr", app.UserExportAllData)
-	router.POST("/gdpr", app.UserDeleteAllData)
-
-	return &App{router}, nil
 }
 
-func (app App) ServeHTTP(w http.ResponseWriter, r *http.Request) {
-	app.router.ServeHTTP(w, r)
+func (a *App) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	a.router.ServeHTTP(w, r)
 }
 
-func (app App) Run(opts ...string) error {
-	if err := app.router.Run(opts...); err != nil {
-		defer dbConn.Close()
+// Start runs the background jobs and serves until Shutdown, when it returns
+// nil.
+func (a *App) Start() error {
+	defer a.stop()
+	a.mu.Lock()
+	a.wait = schedule.Start(a.ctx, a.jobs...)
+	a.mu.Unlock()
+
+	if err := a.server.ListenAndServe(); err != http.ErrServerClosed {
 		return err
 	}
 	return nil
 }
+
+// Shutdown stops taking requests and starting jobs, and ends event streams
+// (see api.App.WithDone). It waits until ctx is done for the requests and
+// jobs in flight to finish, then closes the connections New opened.
+func (a *App) Shutdown(ctx context.Context) error {
+	a.stop()
+
+	err := a.server.Shutdown(ctx)
+
+	a.mu.Lock()
+	wait := a.wait
+	a.mu.Unlock()
+	if wait != nil {
+		done := make(chan struct{})
+		go func() {
+			wait()
+			close(done)
+		}()
+		select {
+		case <-done:
+		case <-ctx.Done():
+			if err == nil {
+				err = errors.Wrap(ctx.Err(), "background jobs still running")
+			}
+		}
+	}
+
+	if cerr := a.close(); err == nil {
+		err = cerr
+	}
+	return err
+}
+
+// Run starts the app and shuts it down on SIGINT or SIGTERM, giving requests
+// and jobs in flight SHUTDOWN_TIMEOUT to finish; deploys send SIGTERM, so
+// inbound sms webhooks aren't dropped.
+func (a *App) Run() error {
+	started := make(chan error, 1)
+	go func() { started <- a.Start() }()
+
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, syscall.SIGINT, syscall.SIGTERM)
+	defer signal.Stop(signals)
+
+	select {
+	case err := <-started:
+		started <- err /* Failed to listen; cleaned up and returned below. */
+	case sig := <-signals:
+		a.log.Info("shutting down", logger.Fields{"signal": sig.String(), "timeout": a.timeout.String()})
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), a.timeout)
+	defer cancel()
+	if serr := a.Shutdown(ctx); serr != nil {
+		a.log.Error("failed to shut down cleanly", serr, nil)
+	}
+
+	return <-started
+}
+
+func (a *App) close() error {
+	var err error
+	for _, closer := range a.closers {
+		if cerr := closer.Close(); cerr != nil && err == nil {
+			err = cerr
+		}
+	}
+	a.closers = nil
+	return err
+}
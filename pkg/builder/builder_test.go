@@ -0,0 +1,92 @@
+package builder
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"regexp"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"gopkg.in/go-playground/assert.v1"
+	"smscp.xyz/internal/api"
+	"smscp.xyz/internal/config"
+	"smscp.xyz/internal/metrics"
+	"smscp.xyz/pkg/mode"
+)
+
+var pathParam = regexp.MustCompile(`[:*]([^/]+)`)
+
+// Fails when a route is registered without being documented, or documented
+// without being registered.
+func TestOpenAPIDocumentsEveryRoute(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	router := gin.New()
+	routes(router, api.AppDefault(nil, nil, nil, nil, nil, nil), metrics.Default().Handler(""))
+
+	w := httptest.NewRecorder()
+	req, _ := http.NewRequest("GET", "/api/openapi.json", nil)
+	router.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusOK, w.Code)
+
+	var doc struct {
+		OpenAPI string
+		Paths   map[string]map[string]json.RawMessage
+	}
+	if err := json.Unmarshal(w.Body.Bytes(), &doc); err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, "3.0.3", doc.OpenAPI)
+
+	registered := map[string]bool{}
+	for _, route := range router.Routes() {
+		path := pathParam.ReplaceAllString(route.Path, "{$1}")
+		method := strings.ToLower(route.Method)
+		registered[method+" "+path] = true
+		if _, ok := doc.Paths[path][method]; !ok {
+			t.Errorf("%s %s is registered in builder but not documented in internal/api/openapi.go", route.Method, route.Path)
+		}
+	}
+
+	for path, item := range doc.Paths {
+		for method := range item {
+			if !registered[method+" "+path] {
+				t.Errorf("%s %s is documented but never registered", strings.ToUpper(method), path)
+			}
+		}
+	}
+}
+
+type fakeStorage struct{ api.Data }
+
+func (fakeStorage) NoteSweepExpired(ctx context.Context, now time.Time) (int, error) { return 0, nil }
+func (fakeStorage) NotePurge(ctx context.Context, now time.Time, dryRun bool) (map[string]int, error) {
+	return nil, nil
+}
+
+type fakeSMS struct{ api.SMS }
+
+// New must not dial anything when given storage, and Start returns once
+// Shutdown is called.
+func TestLifecycle(t *testing.T) {
+	cfg := config.Default()
+	cfg.Port = "0"
+	app, err := New(mode.Test, WithConfig(cfg), WithStorage(fakeStorage{}), WithSMS(fakeSMS{}))
+	assert.Equal(t, nil, err)
+
+	w := httptest.NewRecorder()
+	req, _ := http.NewRequest("GET", "/ping", nil)
+	app.ServeHTTP(w, req)
+	assert.Equal(t, "pong", w.Body.String())
+
+	started := make(chan error)
+	go func() { started <- app.Start() }()
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	assert.Equal(t, nil, app.Shutdown(ctx))
+	assert.Equal(t, nil, <-started)
+}
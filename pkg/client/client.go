@@ -0,0 +1,313 @@
+// Package client talks to the smscp /api/v1 endpoints.
+package client
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/trace"
+)
+
+const DefaultBaseURL = "https://smscp.xyz"
+
+// ErrNoToken is returned by authenticated calls made before Login, Register or
+// WithToken.
+var ErrNoToken = errors.New("no token; please login")
+
+type User struct {
+	ID        string    `json:"id"`
+	Username  string    `json:"username"`
+	Phone     string    `json:"phone"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+type Note struct {
+	ID        string     `json:"id"`
+	Text      string     `json:"text"`
+	Short     string     `json:"short"`
+	Encrypted bool       `json:"encrypted"` /* Text is sealed; open with pkg/e2e. */
+	Burn      bool       `json:"burn"`      /* Already deleted by the get that returned it. */
+	ExpiresAt *time.Time `json:"expires_at"`
+	CreatedAt time.Time  `json:"created_at"`
+}
+
+// NoteOptions are chosen when a note is created.
+type NoteOptions struct {
+	Encrypted bool          /* Text is ciphertext from e2e.Seal. */
+	TTL       time.Duration /* Rounded down to seconds; zero keeps the note. */
+	Burn      bool          /* Delete the note once it is fetched. */
+}
+
+type NoteList struct {
+	Notes   []Note `json:"notes"`
+	HasMore bool   `json:"has_more"`
+}
+
+type ImportResult struct {
+	Imported   int           `json:"imported"`
+	Duplicates int           `json:"duplicates"` /* Already stored, so skipped. */
+	Errors     []ImportError `json:"errors"`
+}
+
+type ImportError struct {
+	Line    int    `json:"line"`
+	Message string `json:"message"`
+}
+
+type Session struct {
+	Token string `json:"token"`
+	User  User   `json:"user"`
+}
+
+// Error is any non-2xx response from the server.
+type Error struct {
+	StatusCode int
+	RetryAfter time.Duration /* Set when rate limited. */
+	TraceID    string        /* Set when traced, to find the request's spans. */
+	Code       string        `json:"code"`
+	Message    string        `json:"message"`
+}
+
+func (e *Error) Error() string {
+	if e.Message == "" {
+		return fmt.Sprintf("remote server responded %d", e.StatusCode)
+	}
+	return e.Message
+}
+
+func (e *Error) NotFound() bool     { return e.StatusCode == http.StatusNotFound }
+func (e *Error) Unauthorized() bool { return e.StatusCode == http.StatusUnauthorized }
+func (e *Error) Invalid() bool      { return e.StatusCode == http.StatusBadRequest }
+func (e *Error) Conflict() bool     { return e.StatusCode == http.StatusConflict }
+func (e *Error) RateLimited() bool  { return e.StatusCode == http.StatusTooManyRequests }
+func (e *Error) Locked() bool       { return e.StatusCode == http.StatusForbidden && e.Code == "locked" }
+
+// UserAgent identifies requests from this package.
+const UserAgent = "smscp-client"
+
+type Client struct {
+	base        string
+	http        *http.Client
+	token       string
+	tracer      trace.Tracer /* nil only propagates a span already in the context. */
+	traceparent bool         /* See WithTraceparent. */
+	channel     string       /* See WithChannel. */
+}
+
+type Option func(*Client)
+
+func WithBaseURL(base string) Option {
+	return func(c *Client) { c.base = strings.TrimRight(base, "/") }
+}
+
+func WithHTTPClient(http *http.Client) Option {
+	return func(c *Client) { c.http = http }
+}
+
+func WithToken(token string) Option {
+	return func(c *Client) { c.token = token }
+}
+
+// WithTracer spans each call. Its trace context is sent as a W3C traceparent
+// header, so the server's spans join the caller's trace.
+func WithTracer(tracer trace.Tracer) Option {
+	return func(c *Client) { c.tracer = tracer }
+}
+
+// WithTraceparent starts a trace for each call made outside of one, without a
+// tracer: it only mints the IDs for the traceparent header. A failed call's
+// Error.TraceID then finds the server's spans for it.
+func WithTraceparent() Option {
+	return func(c *Client) { c.traceparent = true }
+}
+
+// WithChannel tells the server which client created notes, i.e. "cli", for
+// its notes created metric. Anything it doesn't know counts as the api.
+func WithChannel(channel string) Option {
+	return func(c *Client) { c.channel = channel }
+}
+
+func New(opts ...Option) *Client {
+	c := &Client{base: DefaultBaseURL, http: http.DefaultClient}
+	for _, opt := range opts {
+		opt(c)
+	}
+	return c
+}
+
+// Token is the bearer token in use, set by Login and Register.
+func (c *Client) Token() string { return c.token }
+
+func (c *Client) Register(ctx context.Context, username, password, verify, phone string) (Session, error) {
+	var session Session
+	err := c.do(ctx, "POST", "/api/v1/users", false, map[string]string{
+		"username": username,
+		"password": password,
+		"verify":   verify,
+		"phone":    phone,
+	}, &session)
+	if err != nil {
+		return Session{}, err
+	}
+	c.token = session.Token
+	return session, nil
+}
+
+func (c *Client) Login(ctx context.Context, username, password string) (Session, error) {
+	var session Session
+	err := c.do(ctx, "POST", "/api/v1/sessions", false, map[string]string{
+		"username": username,
+		"password": password,
+	}, &session)
+	if err != nil {
+		return Session{}, err
+	}
+	c.token = session.Token
+	return session, nil
+}
+
+func (c *Client) CreateNote(ctx context.Context, text string) (Note, error) {
+	return c.CreateNoteWith(ctx, text, NoteOptions{})
+}
+
+// CreateEncryptedNote stores ciphertext from e2e.Seal; the server never sees
+// the passphrase and texts a stub in place of the note.
+func (c *Client) CreateEncryptedNote(ctx context.Context, ciphertext string) (Note, error) {
+	return c.CreateNoteWith(ctx, ciphertext, NoteOptions{Encrypted: true})
+}
+
+func (c *Client) CreateNoteWith(ctx context.Context, text string, opts NoteOptions) (Note, error) {
+	var note Note
+	err := c.do(ctx, "POST", "/api/v1/notes", true, map[string]interface{}{
+		"text":        text,
+		"encrypted":   opts.Encrypted,
+		"ttl_seconds": int(opts.TTL / time.Second),
+		"burn":        opts.Burn,
+		"client":      c.channel,
+	}, &note)
+	return note, err
+}
+
+func (c *Client) Latest(ctx context.Context) (Note, error) {
+	var note Note
+	err := c.do(ctx, "GET", "/api/v1/notes/latest", true, nil, &note)
+	return note, err
+}
+
+func (c *Client) Get(ctx context.Context, id string) (Note, error) {
+	var note Note
+	err := c.do(ctx, "GET", "/api/v1/notes/"+url.PathEscape(id), true, nil, &note)
+	return note, err
+}
+
+func (c *Client) List(ctx context.Context, page int) (NoteList, error) {
+	var list NoteList
+	err := c.do(ctx, "GET", "/api/v1/notes?page="+strconv.Itoa(page), true, nil, &list)
+	return list, err
+}
+
+func (c *Client) Delete(ctx context.Context, id string) error {
+	return c.do(ctx, "DELETE", "/api/v1/notes/"+url.PathEscape(id), true, nil, nil)
+}
+
+// Import uploads notes from an export made at /gdpr, or from a CSV with a text
+// column. Imported notes are not texted.
+func (c *Client) Import(ctx context.Context, file io.Reader) (ImportResult, error) {
+	var res ImportResult
+	err := c.send(ctx, "POST", "/api/v1/notes/import", true, file, "application/octet-stream", &res)
+	return res, err
+}
+
+func (c *Client) do(ctx context.Context, method, path string, auth bool, in, out interface{}) error {
+	if in == nil {
+		return c.send(ctx, method, path, auth, nil, "", out)
+	}
+
+	byt, err := json.Marshal(in)
+	if err != nil {
+		return errors.Wrap(err, "failed to encode request")
+	}
+	return c.send(ctx, method, path, auth, bytes.NewReader(byt), "application/json", out)
+}
+
+func (c *Client) send(ctx context.Context, method, path string, auth bool, body io.Reader, contentType string, out interface{}) error {
+	if auth && c.token == "" {
+		return ErrNoToken
+	}
+
+	if c.tracer != nil {
+		var span trace.Span
+		ctx, span = c.tracer.Start(ctx, method+" "+strings.SplitN(path, "?", 2)[0], trace.WithSpanKind(trace.SpanKindClient))
+		defer span.End()
+	} else if c.traceparent && !trace.SpanContextFromContext(ctx).IsValid() {
+		ctx = trace.ContextWithSpanContext(ctx, newSpanContext())
+	}
+
+	req, err := http.NewRequest(method, c.base+path, body)
+	if err != nil {
+		return errors.Wrap(err, "failed to create request to remote server")
+	}
+	req = req.WithContext(ctx)
+	propagation.TraceContext{}.Inject(ctx, propagation.HeaderCarrier(req.Header))
+	req.Header.Set("Accept", "application/json")
+	req.Header.Set("User-Agent", UserAgent)
+	if contentType != "" {
+		req.Header.Set("Content-Type", contentType)
+	}
+	if auth {
+		req.Header.Set("Authorization", "Bearer "+c.token)
+	}
+
+	resp, err := c.http.Do(req)
+	if err != nil {
+		return errors.Wrap(err, "failed to reach remote server")
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		var envelope struct{ Error *Error }
+		if err := json.NewDecoder(resp.Body).Decode(&envelope); err != nil || envelope.Error == nil {
+			envelope.Error = &Error{}
+		}
+		envelope.Error.StatusCode = resp.StatusCode
+		if sc := trace.SpanContextFromContext(ctx); sc.IsValid() {
+			envelope.Error.TraceID = sc.TraceID().String()
+		}
+		if secs, err := strconv.Atoi(resp.Header.Get("Retry-After")); err == nil {
+			envelope.Error.RetryAfter = time.Duration(secs) * time.Second
+		}
+		return envelope.Error
+	}
+
+	if out == nil {
+		return nil
+	}
+	if err := json.NewDecoder(resp.Body).Decode(out); err != nil {
+		return errors.Wrap(err, "invalid response from remote server")
+	}
+
+	return nil
+}
+
+// newSpanContext makes random, sampled trace and span IDs as the W3C Trace
+// Context spec asks of a trace's first caller.
+func newSpanContext() trace.SpanContext {
+	var (
+		traceID trace.TraceID
+		spanID  trace.SpanID
+	)
+	_, _ = rand.Read(traceID[:])
+	_, _ = rand.Read(spanID[:])
+	return trace.NewSpanContext(trace.SpanContextConfig{TraceID: traceID, SpanID: spanID, TraceFlags: trace.FlagsSampled})
+}
@@ -0,0 +1,135 @@
+package client_test
+
+import (
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"gopkg.in/go-playground/assert.v1"
+	"smscp.xyz/pkg/client"
+)
+
+func server(t *testing.T) *httptest.Server {
+	mux := http.NewServeMux()
+
+	mux.HandleFunc("/api/v1/sessions", func(w http.ResponseWriter, r *http.Request) {
+		var payload struct{ Username, Password string }
+		json.NewDecoder(r.Body).Decode(&payload)
+		if payload.Password != "pass" {
+			w.WriteHeader(http.StatusUnauthorized)
+			w.Write([]byte(`{"error":{"code":"unauthorized","message":"invalid username or password"}}`))
+			return
+		}
+		w.WriteHeader(http.StatusCreated)
+		w.Write([]byte(`{"token":"abc","user":{"id":"1","username":"` + payload.Username + `"}}`))
+	})
+
+	mux.HandleFunc("/api/v1/notes/latest", func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Authorization") != "Bearer abc" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		w.Write([]byte(`{"id":"n1","text":"hello","short":"hello","created_at":"2019-11-01T00:00:00Z"}`))
+	})
+
+	mux.HandleFunc("/api/v1/notes/missing", func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusNotFound)
+		w.Write([]byte(`{"error":{"code":"not_found","message":"note not found"}}`))
+	})
+
+	return httptest.NewServer(mux)
+}
+
+func TestLoginThenLatest(t *testing.T) {
+	srv := server(t)
+	defer srv.Close()
+
+	c := client.New(client.WithBaseURL(srv.URL), client.WithHTTPClient(srv.Client()))
+
+	_, err := c.Latest(context.Background())
+	assert.Equal(t, client.ErrNoToken, err)
+
+	session, err := c.Login(context.Background(), "alice", "pass")
+	assert.Equal(t, nil, err)
+	assert.Equal(t, "alice", session.User.Username)
+	assert.Equal(t, "abc", c.Token())
+
+	note, err := c.Latest(context.Background())
+	assert.Equal(t, nil, err)
+	assert.Equal(t, "hello", note.Text)
+	assert.Equal(t, 2019, note.CreatedAt.Year())
+}
+
+func TestTypedErrors(t *testing.T) {
+	srv := server(t)
+	defer srv.Close()
+
+	c := client.New(client.WithBaseURL(srv.URL), client.WithToken("abc"))
+
+	_, err := c.Login(context.Background(), "alice", "wrong")
+	e, ok := err.(*client.Error)
+	assert.Equal(t, true, ok)
+	assert.Equal(t, true, e.Unauthorized())
+	assert.Equal(t, "invalid username or password", e.Error())
+
+	_, err = c.Get(context.Background(), "missing")
+	e, ok = err.(*client.Error)
+	assert.Equal(t, true, ok)
+	assert.Equal(t, true, e.NotFound())
+	assert.Equal(t, "not_found", e.Code)
+}
+
+func TestTraceparent(t *testing.T) {
+	var traceparent string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		traceparent = r.Header.Get("traceparent")
+		w.WriteHeader(http.StatusInternalServerError)
+	}))
+	defer srv.Close()
+
+	tracer := sdktrace.NewTracerProvider().Tracer("smscp")
+	c := client.New(client.WithBaseURL(srv.URL), client.WithToken("abc"), client.WithTracer(tracer))
+
+	_, err := c.Latest(context.Background())
+	e, ok := err.(*client.Error)
+	assert.Equal(t, true, ok)
+	assert.Equal(t, 32, len(e.TraceID))
+
+	// version-traceid-spanid-flags, sampled
+	parts := strings.Split(traceparent, "-")
+	assert.Equal(t, 4, len(parts))
+	assert.Equal(t, e.TraceID, parts[1])
+	assert.Equal(t, "01", parts[3])
+
+	// Without a tracer the client mints the IDs itself.
+	c = client.New(client.WithBaseURL(srv.URL), client.WithToken("abc"), client.WithTraceparent())
+	_, err = c.Latest(context.Background())
+	e, ok = err.(*client.Error)
+	assert.Equal(t, true, ok)
+	assert.Equal(t, 32, len(e.TraceID))
+	assert.Equal(t, true, strings.HasPrefix(traceparent, "00-"+e.TraceID+"-"))
+	assert.Equal(t, true, strings.HasSuffix(traceparent, "-01"))
+}
+
+func TestImport(t *testing.T) {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/api/v1/notes/import", func(w http.ResponseWriter, r *http.Request) {
+		byt, _ := ioutil.ReadAll(r.Body)
+		assert.Equal(t, "text\nhello\n", string(byt))
+		assert.Equal(t, "application/octet-stream", r.Header.Get("Content-Type"))
+		w.Write([]byte(`{"imported":1,"duplicates":0,"errors":[{"line":3,"message":"text is required"}]}`))
+	})
+	srv := httptest.NewServer(mux)
+	defer srv.Close()
+
+	c := client.New(client.WithBaseURL(srv.URL), client.WithHTTPClient(srv.Client()), client.WithToken("abc"))
+	res, err := c.Import(context.Background(), strings.NewReader("text\nhello\n"))
+	assert.Equal(t, nil, err)
+	assert.Equal(t, 1, res.Imported)
+	assert.Equal(t, []client.ImportError{{Line: 3, Message: "text is required"}}, res.Errors)
+}
@@ -0,0 +1,134 @@
+// Package e2e seals note text on the client so the server only ever sees
+// ciphertext. Keys are derived from a passphrase with Argon2id and notes are
+// sealed with XChaCha20-Poly1305. The web UI opens the same format in the
+// browser (see web/html/e2e.js); change both together.
+package e2e
+
+import (
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/binary"
+	"strings"
+
+	"github.com/pkg/errors"
+	"golang.org/x/crypto/argon2"
+	"golang.org/x/crypto/chacha20poly1305"
+)
+
+// Prefix starts every sealed note. What follows is unpadded base64url of
+//
+//	time (4) | memory (4) | threads (1) | salt (16) | nonce (24) | ciphertext
+//
+// where the integers are big endian and everything before the ciphertext is
+// authenticated as additional data.
+const Prefix = "smscp:e2e:v1:"
+
+const (
+	saltSize   = 16
+	tagSize    = 16 /* Poly1305 */
+	headerSize = 4 + 4 + 1 + saltSize + chacha20poly1305.NonceSizeX
+)
+
+// Params are the Argon2id costs; memory is in KiB.
+type Params struct {
+	Time    uint32
+	Memory  uint32
+	Threads uint8
+}
+
+// DefaultParams are the OWASP minimum for Argon2id. Notes are opened by plain
+// JavaScript in the browser, where anything much costlier takes seconds.
+var DefaultParams = Params{Time: 2, Memory: 19 * 1024, Threads: 1}
+
+// maxParams bounds what Open will spend on a note someone else sealed.
+var maxParams = Params{Time: 16, Memory: 1024 * 1024, Threads: 16}
+
+// ErrOpen is returned by Open for a wrong passphrase or tampered note.
+var ErrOpen = errors.New("failed to decrypt note; wrong passphrase or corrupted note")
+
+func Seal(passphrase, plaintext string) (string, error) {
+	return SealWith(DefaultParams, passphrase, plaintext)
+}
+
+func SealWith(params Params, passphrase, plaintext string) (string, error) {
+	if passphrase == "" {
+		return "", errors.New("passphrase is required")
+	}
+
+	header := make([]byte, headerSize)
+	binary.BigEndian.PutUint32(header[0:4], params.Time)
+	binary.BigEndian.PutUint32(header[4:8], params.Memory)
+	header[8] = params.Threads
+	if _, err := rand.Read(header[9:]); err != nil {
+		return "", errors.Wrap(err, "failed to create salt and nonce")
+	}
+	salt, nonce := header[9:9+saltSize], header[9+saltSize:]
+
+	aead, err := chacha20poly1305.NewX(key(params, passphrase, salt))
+	if err != nil {
+		return "", errors.Wrap(err, "failed to create cipher")
+	}
+
+	sealed := aead.Seal(header, nonce, []byte(plaintext), header)
+	return Prefix + base64.RawURLEncoding.EncodeToString(sealed), nil
+}
+
+func Open(passphrase, sealed string) (string, error) {
+	params, header, ciphertext, err := parse(sealed)
+	if err != nil {
+		return "", err
+	}
+	if params.Time > maxParams.Time || params.Memory > maxParams.Memory || params.Threads > maxParams.Threads {
+		return "", errors.New("failed to decrypt note; key derivation too expensive")
+	}
+
+	salt, nonce := header[9:9+saltSize], header[9+saltSize:]
+	aead, err := chacha20poly1305.NewX(key(params, passphrase, salt))
+	if err != nil {
+		return "", errors.Wrap(err, "failed to create cipher")
+	}
+
+	plaintext, err := aead.Open(nil, nonce, ciphertext, header)
+	if err != nil {
+		return "", ErrOpen
+	}
+
+	return string(plaintext), nil
+}
+
+// Valid reports whether text is shaped like a sealed note. It cannot tell
+// whether the note will open, only that it isn't plaintext sent by mistake.
+func Valid(text string) bool {
+	_, _, _, err := parse(text)
+	return err == nil
+}
+
+func parse(sealed string) (Params, []byte, []byte, error) {
+	if !strings.HasPrefix(sealed, Prefix) {
+		return Params{}, nil, nil, errors.New("not an encrypted note")
+	}
+
+	byt, err := base64.RawURLEncoding.DecodeString(strings.TrimPrefix(sealed, Prefix))
+	if err != nil {
+		return Params{}, nil, nil, errors.Wrap(err, "encrypted note corrupted")
+	}
+	if len(byt) < headerSize+tagSize {
+		return Params{}, nil, nil, errors.New("encrypted note corrupted; too short")
+	}
+
+	header := byt[:headerSize]
+	params := Params{
+		Time:    binary.BigEndian.Uint32(header[0:4]),
+		Memory:  binary.BigEndian.Uint32(header[4:8]),
+		Threads: header[8],
+	}
+	if params.Time < 1 || params.Threads < 1 {
+		return Params{}, nil, nil, errors.New("encrypted note corrupted; invalid parameters")
+	}
+
+	return params, header, byt[headerSize:], nil
+}
+
+func key(params Params, passphrase string, salt []byte) []byte {
+	return argon2.IDKey([]byte(passphrase), salt, params.Time, params.Memory, params.Threads, chacha20poly1305.KeySize)
+}
@@ -0,0 +1,136 @@
+package e2e
+
+import (
+	"bytes"
+	"encoding/hex"
+	"encoding/json"
+	"math/rand"
+	"os/exec"
+	"strings"
+	"testing"
+
+	"golang.org/x/crypto/argon2"
+	"golang.org/x/crypto/blake2b"
+	"golang.org/x/crypto/chacha20"
+	"golang.org/x/crypto/chacha20poly1305"
+	"golang.org/x/crypto/poly1305"
+	"gopkg.in/go-playground/assert.v1"
+)
+
+// cheap keeps the tests fast; the format doesn't depend on the costs.
+var cheap = Params{Time: 1, Memory: 64, Threads: 1}
+
+func TestRoundTrip(t *testing.T) {
+	sealed, err := SealWith(cheap, "correct horse", "my secret")
+	assert.Equal(t, nil, err)
+	assert.Equal(t, true, strings.HasPrefix(sealed, Prefix))
+	assert.Equal(t, false, strings.Contains(sealed, "my secret"))
+	assert.Equal(t, true, Valid(sealed))
+
+	text, err := Open("correct horse", sealed)
+	assert.Equal(t, nil, err)
+	assert.Equal(t, "my secret", text)
+
+	_, err = Open("battery staple", sealed)
+	assert.Equal(t, ErrOpen, err)
+}
+
+func TestTampered(t *testing.T) {
+	sealed, err := SealWith(cheap, "pass", "text")
+	assert.Equal(t, nil, err)
+
+	// Flip a character in the authenticated header (the salt).
+	i, c := len(Prefix)+14, "A"
+	if sealed[i] == 'A' {
+		c = "B"
+	}
+	flipped := sealed[:i] + c + sealed[i+1:]
+	_, err = Open("pass", flipped)
+	assert.Equal(t, ErrOpen, err)
+}
+
+func TestValid(t *testing.T) {
+	assert.Equal(t, false, Valid("plain text"))
+	assert.Equal(t, false, Valid(Prefix+"!!!"))
+	assert.Equal(t, false, Valid(Prefix+"AAAA"))
+}
+
+// TestJS runs web/html/e2e.js under node against the vectors in
+// testdata/e2e_test.js, and against golang.org/x/crypto and SealWith on
+// random inputs, so the browser opens exactly what the CLI seals.
+func TestJS(t *testing.T) {
+	node, err := exec.LookPath("node")
+	if err != nil {
+		t.Skip("node not installed")
+	}
+
+	var (
+		rnd    = rand.New(rand.NewSource(1))
+		random = func(n int) []byte {
+			b := make([]byte, n)
+			rnd.Read(b)
+			return b
+		}
+		cases = map[string][]map[string]interface{}{}
+		add   = func(kind string, c map[string]interface{}) { cases[kind] = append(cases[kind], c) }
+		x     = hex.EncodeToString
+	)
+
+	for _, size := range []int{1, 20, 32, 48, 64} {
+		for _, n := range []int{0, 1, 127, 128, 129, 1000} {
+			input := random(n)
+			h, _ := blake2b.New(size, nil)
+			h.Write(input)
+			add("blake2b", map[string]interface{}{"size": size, "input": x(input), "sum": x(h.Sum(nil))})
+		}
+	}
+
+	for _, p := range []Params{cheap, {Time: 3, Memory: 100, Threads: 4}, {Time: 2, Memory: 256, Threads: 2}} {
+		password, salt := "pass word \u00e9", random(saltSize)
+		add("argon2id", map[string]interface{}{
+			"password": password, "salt": x(salt), "time": p.Time, "memory": p.Memory, "threads": p.Threads,
+			"key": x(argon2.IDKey([]byte(password), salt, p.Time, p.Memory, p.Threads, 32)),
+		})
+	}
+
+	for _, n := range []int{0, 1, 63, 64, 65, 300} {
+		key, nonce, input := random(32), random(12), random(n)
+		counter := uint32(rnd.Intn(4))
+		c, _ := chacha20.NewUnauthenticatedCipher(key, nonce)
+		c.SetCounter(counter)
+		output := make([]byte, n)
+		c.XORKeyStream(output, input)
+		add("chacha20", map[string]interface{}{"key": x(key), "nonce": x(nonce), "counter": counter, "input": x(input), "output": x(output)})
+
+		var pkey [32]byte
+		var tag [16]byte
+		copy(pkey[:], random(32))
+		poly1305.Sum(&tag, input, &pkey)
+		add("poly1305", map[string]interface{}{"key": x(pkey[:]), "msg": x(input), "tag": x(tag[:])})
+
+		aead, _ := chacha20poly1305.NewX(key)
+		xnonce, aad := random(24), random(n%20)
+		add("xchacha20poly1305", map[string]interface{}{
+			"key": x(key), "nonce": x(xnonce), "aad": x(aad), "plaintext": x(input),
+			"sealed": x(aead.Seal(nil, xnonce, input, aad)),
+		})
+	}
+
+	for _, text := range []string{"", "my secret", "\u00fcnic\u00f6de \u2603 " + strings.Repeat("long ", 40)} {
+		for _, p := range []Params{cheap, {Time: 2, Memory: 100, Threads: 3}} {
+			sealed, err := SealWith(p, "correct horse", text)
+			assert.Equal(t, nil, err)
+			add("notes", map[string]interface{}{"passphrase": "correct horse", "sealed": sealed, "want": text})
+			add("notes", map[string]interface{}{"passphrase": "battery staple", "sealed": sealed, "want": ErrOpen.Error()})
+		}
+	}
+
+	in, err := json.Marshal(cases)
+	assert.Equal(t, nil, err)
+
+	cmd := exec.Command(node, "testdata/e2e_test.js")
+	cmd.Stdin = bytes.NewReader(in)
+	if out, err := cmd.CombinedOutput(); err != nil {
+		t.Fatalf("web/html/e2e.js disagrees with pkg/e2e: %v\n%s", err, out)
+	}
+}
@@ -0,0 +1,86 @@
+// Run by TestJS: checks web/html/e2e.js against published test vectors and
+// against the cases on stdin, which TestJS computes with golang.org/x/crypto
+// and SealWith. Prints one line per failure and exits non-zero if any.
+'use strict';
+
+var fs = require('fs');
+var path = require('path');
+var vm = require('vm');
+
+vm.runInThisContext(fs.readFileSync(path.join(__dirname, '../../../web/html/e2e.js'), 'utf8'));
+var e2e = globalThis.smscpE2E, p = e2e.primitives;
+var cases = JSON.parse(fs.readFileSync(0, 'utf8'));
+var failed = 0;
+
+function hex(s) {
+  return new Uint8Array(Buffer.from(s, 'hex'));
+}
+
+function text(s) {
+  return new Uint8Array(Buffer.from(s, 'utf8'));
+}
+
+function check(name, got, want) {
+  got = got === null ? 'null' : Buffer.from(got).toString('hex');
+  if (got !== want) {
+    console.log(name + ': got ' + got + ', want ' + want);
+    failed++;
+  }
+}
+
+// RFC 7693, appendix A
+check('blake2b rfc7693', p.blake2b(64, [text('abc')]),
+  'ba80a53f981c4d0d6a2797b69f12f6e94c212f14685ac4b74b12bb6fdbffa2d1' +
+  '7d87c5392aab792dc252d5de4533cc9518d38aa8dbf1925ab92386edd4009923');
+
+// draft-irtf-cfrg-xchacha-03, section 2.2.1
+var key = new Uint8Array(32).map(function(_, i) { return i; });
+check('hchacha20 draft', p.hchacha20(key, hex('000000090000004a0000000031415927')),
+  '82413b4227b27bfed30e42508a877d73a0f9e4d58a74a853c12ec41326d3ecdc');
+
+// RFC 8439, section 2.4.2
+check('chacha20 rfc8439', p.chacha20(key, hex('000000000000004a00000000'), 1,
+  text("Ladies and Gentlemen of the class of '99: If I could offer you only one tip for the future, sunscreen would be it.")),
+  '6e2e359a2568f98041ba0728dd0d6981e97e7aec1d4360c20a27afccfd9fae0b' +
+  'f91b65c5524733ab8f593dabcd62b3571639d624e65152ab8f530c359f0861d8' +
+  '07ca0dbf500d6a6156a38e088a22b65e52bc514d16ccf806818ce91ab7793736' +
+  '5af90bbf74a35be6b40b8eedf2785e42874d');
+
+// RFC 8439, section 2.5.2
+check('poly1305 rfc8439', p.poly1305(hex('85d6be7857556d337f4452fe42d506a80103808afb0db2fd4abff6af4149f51b'),
+  text('Cryptographic Forum Research Group')), 'a8061dc1305136c6c22b8baf0c0127a9');
+
+cases.blake2b.forEach(function(c, i) {
+  check('blake2b ' + i, p.blake2b(c.size, [hex(c.input)]), c.sum);
+});
+
+cases.argon2id.forEach(function(c, i) {
+  check('argon2id ' + i, p.argon2id(text(c.password), hex(c.salt), c.time, c.memory, c.threads, c.key.length / 2), c.key);
+});
+
+cases.chacha20.forEach(function(c, i) {
+  check('chacha20 ' + i, p.chacha20(hex(c.key), hex(c.nonce), c.counter, hex(c.input)), c.output);
+});
+
+cases.poly1305.forEach(function(c, i) {
+  check('poly1305 ' + i, p.poly1305(hex(c.key), hex(c.msg)), c.tag);
+});
+
+cases.xchacha20poly1305.forEach(function(c, i) {
+  check('xchacha20poly1305 ' + i, p.xchacha20poly1305Open(hex(c.key), hex(c.nonce), hex(c.sealed), hex(c.aad)), c.plaintext);
+});
+
+cases.notes.forEach(function(c, i) {
+  var got;
+  try {
+    got = e2e.open(c.passphrase, c.sealed);
+  } catch (err) {
+    got = err.message;
+  }
+  if (got !== c.want) {
+    console.log('note ' + i + ': got ' + JSON.stringify(got) + ', want ' + JSON.stringify(c.want));
+    failed++;
+  }
+});
+
+process.exit(failed ? 1 : 0);
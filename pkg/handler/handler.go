@@ -1,17 +1,54 @@
 package handler
 
 import (
-	"log"
+	"context"
 	"net/http"
+	"sync"
 
+	"smscp.xyz/internal/config"
+	"smscp.xyz/internal/logger"
 	"smscp.xyz/pkg/builder"
 	"smscp.xyz/pkg/mode"
 )
 
+// The app is built by the first request an instance serves and reused after;
+// building connects to firestore and parses templates.
+var (
+	mu     sync.Mutex
+	server *builder.App
+)
+
+// app returns the built app. A failed build isn't kept, so the next request
+// tries again rather than the instance failing until it's recycled. Each build
+// logs the config it resolved, with secrets redacted.
+func app(ctx context.Context) (*builder.App, error) {
+	mu.Lock()
+	defer mu.Unlock()
+
+	if server != nil {
+		return server, nil
+	}
+
+	cfg, err := config.Load(mode.Prod, nil)
+	if err != nil {
+		return nil, err
+	}
+	logger.From(ctx).Info("building app", logger.Fields{"config": cfg.String()})
+
+	built, err := builder.New(mode.Prod, builder.WithConfig(cfg))
+	if err != nil {
+		return nil, err
+	}
+
+	server = built
+	return server, nil
+}
+
 func H(w http.ResponseWriter, r *http.Request) {
-	server, err := builder.Build(mode.Prod)
+	server, err := app(r.Context())
 	if err != nil {
-		log.Fatal(err)
+		logger.From(r.Context()).Error("failed to build app", err, nil)
+		http.Error(w, "smscp is unavailable; try again shortly", http.StatusServiceUnavailable)
 		return
 	}
 	server.ServeHTTP(w, r)
@@ -0,0 +1,23 @@
+package handler
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"testing"
+
+	"gopkg.in/go-playground/assert.v1"
+)
+
+// A bad config answers 503 instead of exiting the function.
+func TestUnavailable(t *testing.T) {
+	if os.Getenv("GOOGLE_PROJECT_ID") != "" {
+		t.Skip("configured; would connect to firestore")
+	}
+
+	w := httptest.NewRecorder()
+	req, _ := http.NewRequest("GET", "/ping", nil)
+	H(w, req)
+	assert.Equal(t, http.StatusServiceUnavailable, w.Code)
+	assert.Equal(t, true, server == nil) /* Tried again next request. */
+}
@@ -7,3 +7,15 @@ const (
 	Dev
 	Prod
 )
+
+func (m Mode) String() string {
+	switch m {
+	case Test:
+		return "test"
+	case Dev:
+		return "dev"
+	case Prod:
+		return "prod"
+	}
+	return "unknown"
+}
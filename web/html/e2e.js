@@ -0,0 +1,562 @@
+// Opens notes sealed by `smscp new --encrypt` (see pkg/e2e) in the browser so
+// the passphrase and plaintext never leave this page. BLAKE2b, Argon2id,
+// XChaCha20 and Poly1305 are written out here as no browser API offers them;
+// pkg/e2e tests run this file under node against test vectors and notes
+// sealed in Go.
+(function(root) {
+  'use strict';
+
+  var PREFIX = 'smscp:e2e:v1:';
+  var SALT = 16, NONCE = 24, TAG = 16, HEADER = 4 + 4 + 1 + SALT + NONCE;
+
+  // blake2b (RFC 7693), 64-bit words as little endian pairs of 32-bit halves
+
+  var IV = new Uint32Array([
+    0xf3bcc908, 0x6a09e667, 0x84caa73b, 0xbb67ae85,
+    0xfe94f82b, 0x3c6ef372, 0x5f1d36f1, 0xa54ff53a,
+    0xade682d1, 0x510e527f, 0x2b3e6c1f, 0x9b05688c,
+    0xfb41bd6b, 0x1f83d9ab, 0x137e2179, 0x5be0cd19
+  ]);
+
+  var SIGMA = [
+    0, 1, 2, 3, 4, 5, 6, 7, 8, 9, 10, 11, 12, 13, 14, 15,
+    14, 10, 4, 8, 9, 15, 13, 6, 1, 12, 0, 2, 11, 7, 5, 3,
+    11, 8, 12, 0, 5, 2, 15, 13, 10, 14, 3, 6, 7, 1, 9, 4,
+    7, 9, 3, 1, 13, 12, 11, 14, 2, 6, 5, 10, 4, 0, 15, 8,
+    9, 0, 5, 7, 2, 4, 10, 15, 14, 1, 11, 12, 6, 8, 3, 13,
+    2, 12, 6, 10, 0, 11, 8, 3, 4, 13, 7, 5, 15, 14, 1, 9,
+    12, 5, 1, 15, 14, 13, 4, 10, 0, 7, 6, 3, 9, 2, 8, 11,
+    13, 11, 7, 14, 12, 1, 3, 9, 5, 0, 15, 4, 8, 6, 2, 10,
+    6, 15, 14, 9, 11, 3, 0, 8, 12, 2, 13, 7, 1, 4, 10, 5,
+    10, 2, 8, 4, 7, 6, 1, 5, 15, 11, 9, 14, 3, 12, 13, 0,
+    0, 1, 2, 3, 4, 5, 6, 7, 8, 9, 10, 11, 12, 13, 14, 15,
+    14, 10, 4, 8, 9, 15, 13, 6, 1, 12, 0, 2, 11, 7, 5, 3
+  ];
+
+  var bv = new Uint32Array(32), bm = new Uint32Array(32);
+
+  function add64(v, a, b) {
+    var lo = v[a] + v[b];
+    var hi = v[a + 1] + v[b + 1] + (lo >= 0x100000000 ? 1 : 0);
+    v[a] = lo;
+    v[a + 1] = hi;
+  }
+
+  function addm64(v, a, lo, hi) {
+    var l = v[a] + lo;
+    v[a + 1] = v[a + 1] + hi + (l >= 0x100000000 ? 1 : 0);
+    v[a] = l;
+  }
+
+  // xor v[a] with v[b] then rotate right by n (one of 16, 24, 32, 63)
+  function xorrot64(v, a, b, n) {
+    var lo = v[a] ^ v[b], hi = v[a + 1] ^ v[b + 1];
+    if (n === 32) {
+      v[a] = hi;
+      v[a + 1] = lo;
+    } else if (n < 32) {
+      v[a] = (lo >>> n) | (hi << (32 - n));
+      v[a + 1] = (hi >>> n) | (lo << (32 - n));
+    } else {
+      n -= 32;
+      v[a] = (hi >>> n) | (lo << (32 - n));
+      v[a + 1] = (lo >>> n) | (hi << (32 - n));
+    }
+  }
+
+  function b2g(a, b, c, d, x, y) {
+    add64(bv, a, b);
+    addm64(bv, a, bm[x], bm[x + 1]);
+    xorrot64(bv, d, a, 32);
+    add64(bv, c, d);
+    xorrot64(bv, b, c, 24);
+    add64(bv, a, b);
+    addm64(bv, a, bm[y], bm[y + 1]);
+    xorrot64(bv, d, a, 16);
+    add64(bv, c, d);
+    xorrot64(bv, b, c, 63);
+  }
+
+  function b2compress(ctx, last) {
+    var i;
+    for (i = 0; i < 16; i++) {
+      bv[i] = ctx.h[i];
+      bv[i + 16] = IV[i];
+    }
+    bv[24] ^= ctx.t;
+    bv[25] ^= ctx.t / 0x100000000;
+    if (last) {
+      bv[28] = ~bv[28];
+      bv[29] = ~bv[29];
+    }
+    for (i = 0; i < 32; i++) {
+      bm[i] = ctx.b[i * 4] | (ctx.b[i * 4 + 1] << 8) | (ctx.b[i * 4 + 2] << 16) | (ctx.b[i * 4 + 3] << 24);
+    }
+    for (i = 0; i < 12; i++) {
+      var s = i * 16;
+      b2g(0, 8, 16, 24, SIGMA[s + 0] * 2, SIGMA[s + 1] * 2);
+      b2g(2, 10, 18, 26, SIGMA[s + 2] * 2, SIGMA[s + 3] * 2);
+      b2g(4, 12, 20, 28, SIGMA[s + 4] * 2, SIGMA[s + 5] * 2);
+      b2g(6, 14, 22, 30, SIGMA[s + 6] * 2, SIGMA[s + 7] * 2);
+      b2g(0, 10, 20, 30, SIGMA[s + 8] * 2, SIGMA[s + 9] * 2);
+      b2g(2, 12, 22, 24, SIGMA[s + 10] * 2, SIGMA[s + 11] * 2);
+      b2g(4, 14, 16, 26, SIGMA[s + 12] * 2, SIGMA[s + 13] * 2);
+      b2g(6, 8, 18, 28, SIGMA[s + 14] * 2, SIGMA[s + 15] * 2);
+    }
+    for (i = 0; i < 16; i++) {
+      ctx.h[i] ^= bv[i] ^ bv[i + 16];
+    }
+  }
+
+  function b2init(outlen) {
+    var ctx = {b: new Uint8Array(128), h: new Uint32Array(16), t: 0, c: 0, outlen: outlen};
+    for (var i = 0; i < 16; i++) {
+      ctx.h[i] = IV[i];
+    }
+    ctx.h[0] ^= 0x01010000 ^ outlen;
+    return ctx;
+  }
+
+  function b2update(ctx, input) {
+    for (var i = 0; i < input.length; i++) {
+      if (ctx.c === 128) {
+        ctx.t += ctx.c;
+        b2compress(ctx, false);
+        ctx.c = 0;
+      }
+      ctx.b[ctx.c++] = input[i];
+    }
+  }
+
+  function b2final(ctx) {
+    ctx.t += ctx.c;
+    while (ctx.c < 128) {
+      ctx.b[ctx.c++] = 0;
+    }
+    b2compress(ctx, true);
+    var out = new Uint8Array(ctx.outlen);
+    for (var i = 0; i < ctx.outlen; i++) {
+      out[i] = ctx.h[i >> 2] >>> (8 * (i & 3));
+    }
+    return out;
+  }
+
+  function blake2b(outlen, parts) {
+    var ctx = b2init(outlen);
+    for (var i = 0; i < parts.length; i++) {
+      b2update(ctx, parts[i]);
+    }
+    return b2final(ctx);
+  }
+
+  function le32(n) {
+    return new Uint8Array([n, n >>> 8, n >>> 16, n >>> 24]);
+  }
+
+  // argon2 H', the variable length hash
+  function blake2bLong(outlen, input) {
+    if (outlen <= 64) {
+      return blake2b(outlen, [le32(outlen), input]);
+    }
+    var out = new Uint8Array(outlen), pos = 0;
+    var v = blake2b(64, [le32(outlen), input]);
+    while (outlen - pos > 64) {
+      out.set(v.subarray(0, 32), pos);
+      pos += 32;
+      v = blake2b(outlen - pos > 64 ? 64 : outlen - pos, [v]);
+    }
+    out.set(v, pos);
+    return out;
+  }
+
+  // argon2id (RFC 9106), matching golang.org/x/crypto/argon2.IDKey
+
+  var SYNC = 4, WORDS = 256; /* 1024 byte blocks as 32-bit words */
+
+  // x + y + 2 * lo(x) * lo(y) on t[a], t[b]
+  function fBlaMka(t, a, b) {
+    var x = t[a], y = t[b];
+    var x0 = x & 0xffff, x1 = x >>> 16, y0 = y & 0xffff, y1 = y >>> 16;
+    var p00 = x0 * y0, p01 = x0 * y1, p10 = x1 * y0, p11 = x1 * y1;
+    var mid = (p00 >>> 16) + (p01 & 0xffff) + (p10 & 0xffff);
+    var plo = ((mid << 16) | (p00 & 0xffff)) >>> 0;
+    var phi = (p11 + (p01 >>> 16) + (p10 >>> 16) + (mid >>> 16)) >>> 0;
+    phi = ((phi << 1) | (plo >>> 31)) >>> 0;
+    plo = (plo << 1) >>> 0;
+
+    var lo = x + y;
+    var hi = t[a + 1] + t[b + 1] + (lo >= 0x100000000 ? 1 : 0);
+    lo = lo >>> 0;
+    var lo2 = lo + plo;
+    t[a] = lo2;
+    t[a + 1] = hi + phi + (lo2 >= 0x100000000 ? 1 : 0);
+  }
+
+  function ag(t, a, b, c, d) {
+    fBlaMka(t, a, b);
+    xorrot64(t, d, a, 32);
+    fBlaMka(t, c, d);
+    xorrot64(t, b, c, 24);
+    fBlaMka(t, a, b);
+    xorrot64(t, d, a, 16);
+    fBlaMka(t, c, d);
+    xorrot64(t, b, c, 63);
+  }
+
+  // blamka permutes 16 words given as word (not 32-bit) indexes into t
+  function blamka(t, w) {
+    ag(t, w[0] * 2, w[4] * 2, w[8] * 2, w[12] * 2);
+    ag(t, w[1] * 2, w[5] * 2, w[9] * 2, w[13] * 2);
+    ag(t, w[2] * 2, w[6] * 2, w[10] * 2, w[14] * 2);
+    ag(t, w[3] * 2, w[7] * 2, w[11] * 2, w[15] * 2);
+    ag(t, w[0] * 2, w[5] * 2, w[10] * 2, w[15] * 2);
+    ag(t, w[1] * 2, w[6] * 2, w[11] * 2, w[12] * 2);
+    ag(t, w[2] * 2, w[7] * 2, w[8] * 2, w[13] * 2);
+    ag(t, w[3] * 2, w[4] * 2, w[9] * 2, w[14] * 2);
+  }
+
+  var ROWS = [], COLS = [];
+  (function() {
+    for (var i = 0; i < 128; i += 16) {
+      var row = [];
+      for (var j = 0; j < 16; j++) {
+        row.push(i + j);
+      }
+      ROWS.push(row);
+    }
+    for (i = 0; i < 16; i += 2) {
+      COLS.push([
+        i, i + 1, 16 + i, 16 + i + 1, 32 + i, 32 + i + 1, 48 + i, 48 + i + 1,
+        64 + i, 64 + i + 1, 80 + i, 80 + i + 1, 96 + i, 96 + i + 1, 112 + i, 112 + i + 1
+      ]);
+    }
+  })();
+
+  var tmp = new Uint32Array(WORDS), r = new Uint32Array(WORDS);
+
+  // out = (xor ? out : 0) ^ G(x, y); blocks are offsets into the given arrays
+  function processBlock(out, o, x, xo, y, yo, xor) {
+    var i;
+    for (i = 0; i < WORDS; i++) {
+      r[i] = x[xo + i] ^ y[yo + i];
+      tmp[i] = r[i];
+    }
+    for (i = 0; i < 8; i++) {
+      blamka(tmp, ROWS[i]);
+    }
+    for (i = 0; i < 8; i++) {
+      blamka(tmp, COLS[i]);
+    }
+    if (xor) {
+      for (i = 0; i < WORDS; i++) {
+        out[o + i] ^= r[i] ^ tmp[i];
+      }
+    } else {
+      for (i = 0; i < WORDS; i++) {
+        out[o + i] = r[i] ^ tmp[i];
+      }
+    }
+  }
+
+  function argon2id(password, salt, time, memory, threads, keyLen) {
+    var h0 = blake2b(64, [
+      le32(threads), le32(keyLen), le32(memory), le32(time), le32(0x13), le32(2),
+      le32(password.length), password, le32(salt.length), salt, le32(0), le32(0)
+    ]);
+
+    memory = Math.floor(memory / (SYNC * threads)) * (SYNC * threads);
+    if (memory < 2 * SYNC * threads) {
+      memory = 2 * SYNC * threads;
+    }
+    var lanes = memory / threads, segments = lanes / SYNC;
+    var B = new Uint32Array(memory * WORDS);
+    var seed = new Uint8Array(72), lane, i, j;
+    seed.set(h0);
+
+    for (lane = 0; lane < threads; lane++) {
+      seed.set(le32(lane), 68);
+      for (j = 0; j < 2; j++) {
+        seed.set(le32(j), 64);
+        var block = blake2bLong(1024, seed), o = (lane * lanes + j) * WORDS;
+        for (i = 0; i < WORDS; i++) {
+          B[o + i] = block[i * 4] | (block[i * 4 + 1] << 8) | (block[i * 4 + 2] << 16) | (block[i * 4 + 3] << 24);
+        }
+      }
+    }
+
+    var addresses = new Uint32Array(WORDS), input = new Uint32Array(WORDS), zero = new Uint32Array(WORDS);
+
+    function nextAddresses() {
+      input[12]++;
+      processBlock(addresses, 0, input, 0, zero, 0, false);
+      processBlock(addresses, 0, addresses, 0, zero, 0, false);
+    }
+
+    for (var n = 0; n < time; n++) {
+      for (var slice = 0; slice < SYNC; slice++) {
+        for (lane = 0; lane < threads; lane++) {
+          var independent = n === 0 && slice < SYNC / 2;
+          input.fill(0);
+          if (independent) {
+            input[0] = n; input[2] = lane; input[4] = slice;
+            input[6] = memory; input[8] = time; input[10] = 2;
+          }
+
+          var index = 0;
+          if (n === 0 && slice === 0) {
+            index = 2;
+            if (independent) {
+              nextAddresses();
+            }
+          }
+
+          var offset = lane * lanes + slice * segments + index;
+          for (; index < segments; index++, offset++) {
+            var prev = offset - 1;
+            if (index === 0 && slice === 0) {
+              prev += lanes;
+            }
+            var rlo, rhi;
+            if (independent) {
+              if (index % 128 === 0) {
+                nextAddresses();
+              }
+              rlo = addresses[(index % 128) * 2];
+              rhi = addresses[(index % 128) * 2 + 1];
+            } else {
+              rlo = B[prev * WORDS];
+              rhi = B[prev * WORDS + 1];
+            }
+
+            // indexAlpha
+            var refLane = rhi % threads;
+            if (n === 0 && slice === 0) {
+              refLane = lane;
+            }
+            var m = 3 * segments, s = ((slice + 1) % SYNC) * segments;
+            if (lane === refLane) {
+              m += index;
+            }
+            if (n === 0) {
+              m = slice * segments;
+              s = 0;
+              if (slice === 0 || lane === refLane) {
+                m += index;
+              }
+            }
+            if (index === 0 || lane === refLane) {
+              m--;
+            }
+            // phi, with (rlo * rlo) >> 32 and (p * m) >> 32 done exactly
+            var p = mulhi(rlo, rlo);
+            p = mulhi(p, m);
+            var ref = refLane * lanes + (s + m - (p + 1)) % lanes;
+
+            processBlock(B, offset * WORDS, B, prev * WORDS, B, ref * WORDS, n !== 0);
+          }
+        }
+      }
+    }
+
+    var last = (memory - 1) * WORDS;
+    for (lane = 0; lane < threads - 1; lane++) {
+      var lo = (lane * lanes + lanes - 1) * WORDS;
+      for (i = 0; i < WORDS; i++) {
+        B[last + i] ^= B[lo + i];
+      }
+    }
+    var final = new Uint8Array(1024);
+    for (i = 0; i < WORDS; i++) {
+      var w = B[last + i];
+      final[i * 4] = w; final[i * 4 + 1] = w >>> 8; final[i * 4 + 2] = w >>> 16; final[i * 4 + 3] = w >>> 24;
+    }
+    return blake2bLong(keyLen, final);
+  }
+
+  // high 32 bits of a 32x32 bit product
+  function mulhi(a, b) {
+    var a0 = a & 0xffff, a1 = a >>> 16, b0 = b & 0xffff, b1 = b >>> 16;
+    var mid = ((a0 * b0) >>> 16) + (a0 * b1 & 0xffff) + (a1 * b0 & 0xffff);
+    return (a1 * b1 + ((a0 * b1) >>> 16) + ((a1 * b0) >>> 16) + (mid >>> 16)) >>> 0;
+  }
+
+  // chacha20 (RFC 8439) and hchacha20
+
+  function rotl(v, n) {
+    return (v << n) | (v >>> (32 - n));
+  }
+
+  function qr(x, a, b, c, d) {
+    x[a] += x[b]; x[d] = rotl(x[d] ^ x[a], 16);
+    x[c] += x[d]; x[b] = rotl(x[b] ^ x[c], 12);
+    x[a] += x[b]; x[d] = rotl(x[d] ^ x[a], 8);
+    x[c] += x[d]; x[b] = rotl(x[b] ^ x[c], 7);
+  }
+
+  function rounds(x) {
+    for (var i = 0; i < 10; i++) {
+      qr(x, 0, 4, 8, 12); qr(x, 1, 5, 9, 13); qr(x, 2, 6, 10, 14); qr(x, 3, 7, 11, 15);
+      qr(x, 0, 5, 10, 15); qr(x, 1, 6, 11, 12); qr(x, 2, 7, 8, 13); qr(x, 3, 4, 9, 14);
+    }
+  }
+
+  function u32(b, i) {
+    return (b[i] | (b[i + 1] << 8) | (b[i + 2] << 16) | (b[i + 3] << 24)) >>> 0;
+  }
+
+  function state(key, words) {
+    var s = new Uint32Array(16);
+    s[0] = 0x61707865; s[1] = 0x3320646e; s[2] = 0x79622d32; s[3] = 0x6b206574;
+    for (var i = 0; i < 8; i++) {
+      s[4 + i] = u32(key, i * 4);
+    }
+    for (i = 0; i < 4; i++) {
+      s[12 + i] = words[i];
+    }
+    return s;
+  }
+
+  function hchacha20(key, nonce16) {
+    var x = state(key, [u32(nonce16, 0), u32(nonce16, 4), u32(nonce16, 8), u32(nonce16, 12)]);
+    rounds(x);
+    var out = new Uint8Array(32), words = [0, 1, 2, 3, 12, 13, 14, 15];
+    for (var i = 0; i < 8; i++) {
+      out.set(le32(x[words[i]]), i * 4);
+    }
+    return out;
+  }
+
+  function chacha20(key, nonce12, counter, input) {
+    var out = new Uint8Array(input.length);
+    var n = [u32(nonce12, 0), u32(nonce12, 4), u32(nonce12, 8)];
+    for (var pos = 0; pos < input.length; pos += 64, counter++) {
+      var s = state(key, [counter, n[0], n[1], n[2]]), x = new Uint32Array(s);
+      rounds(x);
+      for (var i = 0; i < 16; i++) {
+        x[i] += s[i];
+      }
+      for (i = 0; i < 64 && pos + i < input.length; i++) {
+        out[pos + i] = input[pos + i] ^ (x[i >> 2] >>> (8 * (i & 3)));
+      }
+    }
+    return out;
+  }
+
+  // poly1305 (RFC 8439); notes are small so BigInt is fast enough
+
+  function poly1305(key, msg) {
+    var le = function(b) {
+      var v = BigInt(0);
+      for (var i = b.length - 1; i >= 0; i--) {
+        v = (v << BigInt(8)) | BigInt(b[i]);
+      }
+      return v;
+    };
+    var p = (BigInt(1) << BigInt(130)) - BigInt(5);
+    var r = le(key.subarray(0, 16)) & BigInt('0x0ffffffc0ffffffc0ffffffc0fffffff');
+    var s = le(key.subarray(16, 32)), acc = BigInt(0);
+    for (var i = 0; i < msg.length; i += 16) {
+      var chunk = msg.subarray(i, Math.min(i + 16, msg.length));
+      acc = ((acc + le(chunk) + (BigInt(1) << BigInt(8 * chunk.length))) * r) % p;
+    }
+    acc = (acc + s) & ((BigInt(1) << BigInt(128)) - BigInt(1));
+    var out = new Uint8Array(16);
+    for (i = 0; i < 16; i++) {
+      out[i] = Number(acc & BigInt(255));
+      acc >>= BigInt(8);
+    }
+    return out;
+  }
+
+  function le64(n) {
+    var out = new Uint8Array(8);
+    out.set(le32(n));
+    out.set(le32(Math.floor(n / 0x100000000)), 4);
+    return out;
+  }
+
+  function pad16(n) {
+    return new Uint8Array((16 - n % 16) % 16);
+  }
+
+  function concat(parts) {
+    var len = 0, pos = 0, i;
+    for (i = 0; i < parts.length; i++) {
+      len += parts[i].length;
+    }
+    var out = new Uint8Array(len);
+    for (i = 0; i < parts.length; i++) {
+      out.set(parts[i], pos);
+      pos += parts[i].length;
+    }
+    return out;
+  }
+
+  function xchacha20poly1305Open(key, nonce, sealed, aad) {
+    var subkey = hchacha20(key, nonce.subarray(0, 16));
+    var nonce12 = concat([new Uint8Array(4), nonce.subarray(16, 24)]);
+    var ciphertext = sealed.subarray(0, sealed.length - TAG), tag = sealed.subarray(sealed.length - TAG);
+
+    var polyKey = chacha20(subkey, nonce12, 0, new Uint8Array(32));
+    var want = poly1305(polyKey, concat([
+      aad, pad16(aad.length), ciphertext, pad16(ciphertext.length), le64(aad.length), le64(ciphertext.length)
+    ]));
+    var diff = 0;
+    for (var i = 0; i < TAG; i++) {
+      diff |= want[i] ^ tag[i];
+    }
+    if (diff !== 0) {
+      return null;
+    }
+    return chacha20(subkey, nonce12, 1, ciphertext);
+  }
+
+  // note format
+
+  function base64url(text) {
+    var bin = atob(text.replace(/-/g, '+').replace(/_/g, '/') + '==='.slice((text.length + 3) % 4));
+    var out = new Uint8Array(bin.length);
+    for (var i = 0; i < bin.length; i++) {
+      out[i] = bin.charCodeAt(i);
+    }
+    return out;
+  }
+
+  function open(passphrase, sealed) {
+    if (sealed.indexOf(PREFIX) !== 0) {
+      throw new Error('not an encrypted note');
+    }
+    var byt = base64url(sealed.slice(PREFIX.length));
+    if (byt.length < HEADER + TAG) {
+      throw new Error('encrypted note corrupted; too short');
+    }
+    var header = byt.subarray(0, HEADER);
+    var time = ((header[0] << 24) | (header[1] << 16) | (header[2] << 8) | header[3]) >>> 0;
+    var memory = ((header[4] << 24) | (header[5] << 16) | (header[6] << 8) | header[7]) >>> 0;
+    var threads = header[8];
+    if (time < 1 || threads < 1 || time > 16 || memory > 1024 * 1024 || threads > 16) {
+      throw new Error('encrypted note corrupted; invalid parameters');
+    }
+
+    var key = argon2id(new TextEncoder().encode(passphrase), header.subarray(9, 9 + SALT), time, memory, threads, 32);
+    var plaintext = xchacha20poly1305Open(key, header.subarray(9 + SALT, HEADER), byt.subarray(HEADER), header);
+    if (plaintext === null) {
+      throw new Error('failed to decrypt note; wrong passphrase or corrupted note');
+    }
+    return new TextDecoder().decode(plaintext);
+  }
+
+  root.smscpE2E = {
+    open: open,
+    PREFIX: PREFIX,
+    // checked against RFC vectors and golang.org/x/crypto by pkg/e2e tests
+    primitives: {
+      blake2b: blake2b,
+      argon2id: argon2id,
+      hchacha20: hchacha20,
+      chacha20: chacha20,
+      poly1305: poly1305,
+      xchacha20poly1305Open: xchacha20poly1305Open
+    }
+  };
+})(typeof window !== 'undefined' ? window : this);
@@ -0,0 +1,17 @@
+// Package web holds the page templates, embedded so the server doesn't depend
+// on its working directory or read them from disk per instance.
+package web
+
+import (
+	"embed"
+	"html/template"
+)
+
+//go:embed html
+var html embed.FS
+
+// Templates parses every file in web/html, named by its base name as
+// LoadHTMLGlob would.
+func Templates() (*template.Template, error) {
+	return template.ParseFS(html, "html/*")
+}